@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/soerenchrist/logsync/client/internal/config"
+)
+
+func TestFileIdInGraph(t *testing.T) {
+	tests := []struct {
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{file: "/graphs/Personal/pages/a.md", want: "pages/a.md"},
+		{file: "/graphs/Personal/pages/a b%.md", want: "pages/a%20b%25.md"},
+		{file: "/graphs/Personal/pages/../journals/b.md", want: "journals/b.md"},
+		{file: "/graphs/Personal", wantErr: true},
+		{file: "/graphs/Other/pages/a.md", wantErr: true},
+		{file: "/graphs/Personal/../Personal2/a.md", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := fileIdInGraph("/graphs/Personal", tt.file)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected error, got id %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSelectGraphs(t *testing.T) {
+	conf := config.Config{Sync: config.SyncConfig{Graphs: []string{"/graphs/Personal", "/graphs/Work"}}}
+
+	t.Run("all graphs without arguments", func(t *testing.T) {
+		graphs, err := selectGraphs(conf, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(graphs) != 2 {
+			t.Fatalf("Expected 2 graphs, got %v", graphs)
+		}
+	})
+
+	t.Run("by name and path", func(t *testing.T) {
+		graphs, err := selectGraphs(conf, []string{"Work", "/graphs/Personal/"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(graphs) != 2 || graphs[0] != "/graphs/Work" || graphs[1] != "/graphs/Personal" {
+			t.Fatalf("Expected Work and Personal, got %v", graphs)
+		}
+	})
+
+	t.Run("unknown graph", func(t *testing.T) {
+		_, err := selectGraphs(conf, []string{"Unknown"})
+		if exitCode(err) != ExitUsage {
+			t.Fatalf("Expected usage error, got %v", err)
+		}
+	})
+}
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "logsync", "config.yaml")
+	run := func(args ...string) (int, string) {
+		var out, errOut bytes.Buffer
+		a := &app{out: &out, errOut: &errOut}
+		code := a.execute(append([]string{"init", "--config", configPath}, args...))
+		return code, out.String() + errOut.String()
+	}
+
+	code, output := run("--server", "http://localhost:8080", "--graph", "/graphs/Personal", "--encryption-key", "secret")
+	if code != ExitOK {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, output)
+	}
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Expected config file, got %v", err)
+	}
+	for _, expected := range []string{"host: http://localhost:8080", "- /graphs/Personal", "enabled: true", "key: secret"} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("Expected config to contain %q, got\n%s", expected, content)
+		}
+	}
+
+	code, output = run("--server", "http://localhost:8080", "--graph", "/graphs/Personal")
+	if code != ExitUsage {
+		t.Fatalf("Expected exit code %d without --force, got %d: %s", ExitUsage, code, output)
+	}
+
+	code, output = run("--server", "http://localhost:8080", "--graph", "/graphs/Personal", "--force")
+	if code != ExitOK {
+		t.Fatalf("Expected exit code 0 with --force, got %d: %s", code, output)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if exitCode(nil) != ExitOK {
+		t.Fatal("Expected 0 without error")
+	}
+	if exitCode(errors.New("failed")) != ExitError {
+		t.Fatal("Expected 1 for other errors")
+	}
+	if exitCode(withCode(ExitPending)) != ExitPending {
+		t.Fatal("Expected the given code")
+	}
+
+	var out, errOut bytes.Buffer
+	a := &app{out: &out, errOut: &errOut}
+	if code := a.execute([]string{"status", "--unknown-flag"}); code != ExitUsage {
+		t.Fatalf("Expected usage code for unknown flags, got %d", code)
+	}
+	if code := a.execute([]string{"history"}); code != ExitUsage {
+		t.Fatalf("Expected usage code for missing arguments, got %d", code)
+	}
+}
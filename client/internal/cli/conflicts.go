@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+type conflictsResult struct {
+	Path      string   `json:"path"`
+	Conflicts []string `json:"conflicts"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func (a *app) newConflictsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "conflicts [graph...]",
+		Short: "List the files changed locally and on the server",
+		Long: "List the files, that were changed locally and on the server since the last sync. Those files are skipped by a sync.\n" +
+			"Exits with 3, when there are conflicts.",
+		RunE: a.runConflicts,
+	}
+}
+
+func (a *app) runConflicts(cmd *cobra.Command, args []string) error {
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	code := ExitOK
+	results := make([]conflictsResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		conflicts, err := sync.Conflicts(conf, graphPath)
+		if err != nil {
+			code = ExitError
+		} else if len(conflicts) > 0 && code == ExitOK {
+			code = ExitConflicts
+		}
+		results = append(results, conflictsResult{Path: graphPath, Conflicts: conflicts, Error: errorString(err)})
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			if result.Error != "" {
+				a.printf("%s: failed: %s\n", result.Path, result.Error)
+				continue
+			}
+			for _, fileId := range result.Conflicts {
+				a.printf("%s: %s\n", result.Path, displayPath(fileId))
+			}
+		}
+	}
+	return withCode(code)
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"context"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func (a *app) newDaemonCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Sync the graphs periodically",
+		Long:  "Sync all configured graphs periodically, until the process is stopped. sync.once is ignored.",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE:  a.runDaemon,
+	}
+	cmd.Flags().Int("interval", 0, "seconds between the syncs, overrides sync.interval")
+	bindFlag(cmd.Flags(), "interval", "sync.interval")
+	return cmd
+}
+
+func (a *app) runDaemon(cmd *cobra.Command, args []string) error {
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	conf.Sync.Once = false
+	err = conf.ValidateInterval()
+	if err != nil {
+		return usageError(err)
+	}
+
+	log.SetOutput(a.out)
+	ctx, stop := stopContext(cmd)
+	defer stop()
+	sync.Start(ctx, conf)
+	return nil
+}
+
+func stopContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+type devicesResult struct {
+	Graph   string          `json:"graph"`
+	Path    string          `json:"path"`
+	Devices []remote.Device `json:"devices"`
+	Error   string          `json:"error,omitempty"`
+}
+
+func (a *app) newDevicesCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "devices [graph...]",
+		Short: "List the devices, that sync the graphs",
+		Long: "List the devices, that sync the graphs, with the revision they synced last.\n" +
+			"The server keeps deletions until every device synced them, devices, that stopped syncing, are marked as stale.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runDevices(cmd.Context(), args)
+		},
+	}
+}
+
+func (a *app) runDevices(ctx context.Context, args []string) error {
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	code := ExitOK
+	results := make([]devicesResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		result := devicesResult{Path: graphPath, Devices: make([]remote.Device, 0)}
+		result.Graph, err = graph.GetNameByPath(graphPath)
+		if err == nil {
+			var devices []remote.Device
+			devices, err = remote.NewDevicesRequest(conf).Send(ctx, result.Graph)
+			if errors.Is(err, remote.ErrNotFound) && !errors.Is(err, remote.ErrGraphNotFound) {
+				err = errors.New("the server does not track devices")
+			}
+			if devices != nil {
+				result.Devices = devices
+			}
+		}
+		if err != nil {
+			code = ExitError
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+		return withCode(code)
+	}
+
+	ownId := sync.DeviceId(conf)
+	for _, result := range results {
+		if result.Error != "" {
+			a.printf("%s: failed: %s\n", result.Path, result.Error)
+			continue
+		}
+		a.printf("%s:\n", result.Path)
+		for _, device := range result.Devices {
+			marker := ""
+			if device.Id == ownId {
+				marker = " (this device)"
+			}
+			if device.Stale {
+				marker += " (stale)"
+			}
+			a.printf("  %-20s %s  revision %d, synced %s%s\n",
+				device.Name, device.Id, device.Revision, displayAge(device.Acknowledged), marker)
+		}
+	}
+	return withCode(code)
+}
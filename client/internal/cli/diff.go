@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+)
+
+type fileDiffResult struct {
+	Path  string `json:"path"`
+	File  string `json:"file"`
+	Diff  string `json:"diff"`
+	Error string `json:"error,omitempty"`
+}
+
+func (a *app) runFileDiff(conf config.Config, graphArg string, files []string) error {
+	code := ExitOK
+	results := make([]fileDiffResult, 0, len(files))
+	for _, file := range files {
+		graphPath, fileId, err := resolveFile(conf, graphArg, file)
+		if err != nil {
+			return err
+		}
+
+		diff, err := sync.FileDiff(conf, graphPath, fileId)
+		if err != nil {
+			code = ExitError
+		} else if diff != "" && code == ExitOK {
+			code = ExitPending
+		}
+		results = append(results, fileDiffResult{Path: graphPath, File: displayPath(fileId), Diff: diff, Error: errorString(err)})
+	}
+
+	if a.json {
+		err := a.printJSON(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			if result.Error != "" {
+				a.printf("%s: failed: %s\n", result.File, result.Error)
+				continue
+			}
+			a.printf("%s", result.Diff)
+		}
+	}
+	return withCode(code)
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes of the commands, scripts can rely on them
+const (
+	ExitOK = 0
+	// ExitError a sync or request failed
+	ExitError = 1
+	// ExitUsage invalid arguments, flags or configuration
+	ExitUsage = 2
+	// ExitConflicts files were changed locally and on the server
+	ExitConflicts = 3
+	// ExitPending the graph has changes, that are not synced yet
+	ExitPending = 4
+	// ExitDrift the graph differs from the server, without changes explaining it
+	ExitDrift = 5
+)
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+func usageError(err error) error {
+	return exitError{code: ExitUsage, err: err}
+}
+
+func withCode(code int) error {
+	return exitError{code: code}
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return ExitError
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"path/filepath"
+	"strings"
+)
+
+func selectGraphs(conf config.Config, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return conf.Sync.Graphs, nil
+	}
+
+	selected := make([]string, 0, len(args))
+	for _, arg := range args {
+		graphPath, err := findGraph(conf, arg)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, graphPath)
+	}
+	return selected, nil
+}
+
+func findGraph(conf config.Config, nameOrPath string) (string, error) {
+	wanted, err := filepath.Abs(nameOrPath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, graphPath := range conf.Sync.Graphs {
+		// the name of a graph is the name of its directory
+		if filepath.Base(filepath.Clean(graphPath)) == nameOrPath {
+			return graphPath, nil
+		}
+
+		abs, err := filepath.Abs(graphPath)
+		if err == nil && abs == wanted {
+			return graphPath, nil
+		}
+	}
+	return "", usageError(fmt.Errorf("graph %s is not configured", nameOrPath))
+}
+
+// resolveFile resolves relative paths from the working directory or, with a graph, from the root of the graph.
+func resolveFile(conf config.Config, graphArg, file string) (string, string, error) {
+	if graphArg != "" {
+		graphPath, err := findGraph(conf, graphArg)
+		if err != nil {
+			return "", "", err
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(graphPath, file)
+		}
+		fileId, err := fileIdInGraph(graphPath, file)
+		if err != nil {
+			return "", "", usageError(err)
+		}
+		return graphPath, fileId, nil
+	}
+
+	for _, graphPath := range conf.Sync.Graphs {
+		fileId, err := fileIdInGraph(graphPath, file)
+		if err == nil {
+			return graphPath, fileId, nil
+		}
+	}
+	return "", "", usageError(fmt.Errorf("%s is not inside a configured graph, use --graph", file))
+}
+
+var errOutsideGraph = errors.New("file is outside of the graph")
+
+func fileIdInGraph(graphPath, file string) (string, error) {
+	absGraph, err := filepath.Abs(graphPath)
+	if err != nil {
+		return "", err
+	}
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(absGraph, absFile)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: %s", errOutsideGraph, file)
+	}
+
+	fileId := graph.EncodeFileId(rel)
+	_, err = graph.DecodeFileId(fileId)
+	if err != nil {
+		return "", err
+	}
+	return fileId, nil
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"errors"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+func (a *app) newHistoryCmd() *cobra.Command {
+	var graphArg string
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "history <file>",
+		Short: "Show the changes of a file on the server",
+		Long: "Show the latest changes of a file on the server, newest first.\n" +
+			"The file is resolved from the working directory or, with --graph, from the root of the graph.",
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runHistory(graphArg, args[0], limit)
+		},
+	}
+	cmd.Flags().StringVarP(&graphArg, "graph", "g", "", "name or path of the graph")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 10, "maximum number of changes")
+	return cmd
+}
+
+func (a *app) runHistory(graphArg, file string, limit int) error {
+	a.quiet()
+	if limit < 1 {
+		return usageError(errors.New("limit must be positive"))
+	}
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	graphPath, fileId, err := resolveFile(conf, graphArg, file)
+	if err != nil {
+		return err
+	}
+
+	entries, err := sync.History(conf, graphPath, fileId, limit)
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(entries)
+	}
+	for _, entry := range entries {
+		a.printf("%s  %s  %-7s  %s\n", displayTime(entry.Timestamp), entry.Operation, entry.Kind, entry.TransactionId)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+func (a *app) newIdentityCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "identity",
+		Short: "Print the public key of the identity",
+		Long: "Print the public key of the identity in encryption.identity and create the identity, if it does not exist.\n" +
+			"The owner of a graph shares it with the public key, the private key never leaves the device.",
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runIdentity()
+		},
+	}
+}
+
+func (a *app) runIdentity() error {
+	a.quiet()
+	if a.configFile != "" {
+		config.SetFile(a.configFile)
+	}
+	path, err := config.IdentityFile()
+	if err != nil {
+		return usageError(fmt.Errorf("failed to read config: %w", err))
+	}
+
+	identity, created, err := sync.CreateIdentity(path)
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(map[string]any{"path": path, "public_key": identity.PublicKey(), "created": created})
+	}
+	if created {
+		a.printf("Created identity %s\n", path)
+	}
+	a.printf("%s\n", identity.PublicKey())
+	return nil
+}
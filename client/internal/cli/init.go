@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+type initOptions struct {
+	graphs        []string
+	encryptionKey string
+	keyFile       string
+	interval      int
+	force         bool
+}
+
+type fileConfig struct {
+	Sync struct {
+		Graphs   []string `yaml:"graphs"`
+		Interval int      `yaml:"interval"`
+	} `yaml:"sync"`
+	Server struct {
+		Host     string `yaml:"host"`
+		ApiToken string `yaml:"apitoken,omitempty"`
+	} `yaml:"server"`
+	Encryption struct {
+		Enabled bool   `yaml:"enabled"`
+		Key     string `yaml:"key,omitempty"`
+		KeyFile string `yaml:"keyfile,omitempty"`
+	} `yaml:"encryption"`
+}
+
+func (a *app) newInitCmd() *cobra.Command {
+	opts := initOptions{}
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a config file",
+		Long: "Write a config file to ~/.config/logsync/config.yaml or the path given by --config.\n" +
+			"An existing config file is only replaced with --force.",
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			server, _ := cmd.Flags().GetString("server")
+			apiToken, _ := cmd.Flags().GetString("api-token")
+			return a.runInit(server, apiToken, opts)
+		},
+	}
+	cmd.Flags().StringArrayVarP(&opts.graphs, "graph", "g", nil, "path of a graph directory, can be repeated")
+	cmd.Flags().StringVar(&opts.encryptionKey, "encryption-key", "", "enables the encryption with the given key")
+	cmd.Flags().StringVar(&opts.keyFile, "encryption-key-file", "", "enables the encryption with the passphrase in the file")
+	cmd.Flags().IntVar(&opts.interval, "interval", 60, "seconds between the syncs of the daemon")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "replace an existing config file")
+	return cmd
+}
+
+func (a *app) runInit(server, apiToken string, opts initOptions) error {
+	if server == "" {
+		return usageError(errors.New("--server is required"))
+	}
+	if len(opts.graphs) == 0 {
+		return usageError(errors.New("at least one --graph is required"))
+	}
+	if opts.encryptionKey != "" && opts.keyFile != "" {
+		return usageError(errors.New("only one of --encryption-key and --encryption-key-file can be set"))
+	}
+
+	configPath, err := a.initConfigPath()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(configPath)
+	if err == nil && !opts.force {
+		return usageError(fmt.Errorf("%s already exists, use --force to replace it", configPath))
+	}
+
+	conf := fileConfig{}
+	conf.Server.Host = server
+	conf.Server.ApiToken = apiToken
+	conf.Sync.Interval = opts.interval
+	conf.Encryption.Enabled = opts.encryptionKey != "" || opts.keyFile != ""
+	conf.Encryption.Key = opts.encryptionKey
+	if opts.keyFile != "" {
+		conf.Encryption.KeyFile, err = filepath.Abs(opts.keyFile)
+		if err != nil {
+			return err
+		}
+	}
+	for _, graphPath := range opts.graphs {
+		abs, err := filepath.Abs(graphPath)
+		if err != nil {
+			return err
+		}
+		conf.Sync.Graphs = append(conf.Sync.Graphs, abs)
+	}
+
+	content, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(configPath), 0700)
+	if err != nil {
+		return err
+	}
+	// the file may contain the encryption key and the api token
+	err = os.WriteFile(configPath, content, 0600)
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(map[string]string{"config": configPath})
+	}
+	a.printf("Wrote config to %s\n", configPath)
+	return nil
+}
+
+func (a *app) initConfigPath() (string, error) {
+	if a.configFile != "" {
+		return a.configFile, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "logsync", "config.yaml"), nil
+}
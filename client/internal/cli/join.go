@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"errors"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+func (a *app) newJoinCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "join [graph...]",
+		Short: "Sync graph directories with files for the first time",
+		Long: "Sync graph directories, that already contain files, for the first time. Files with the same content as on the\n" +
+			"server are adopted, the --policy decides which version of the other files is kept:\n" +
+			"  server     replace the local files with the version of the server\n" +
+			"  local      upload the local files as new version\n" +
+			"  keep-both  download the version of the server and keep the local file as 'name (conflict device)'\n" +
+			"The policy overrides sync.join, graphs that were synced before are synced like with logsync sync.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("policy") {
+				return usageError(errors.New("--policy is required"))
+			}
+			policy, _ := cmd.Flags().GetString("policy")
+			_, err := sync.JoinPolicyByName(policy)
+			if err != nil {
+				return usageError(err)
+			}
+			if dryRun {
+				return a.runPlan(args)
+			}
+			return a.runSync(args, false)
+		},
+	}
+	cmd.Flags().String("policy", "", "version of differing files to keep: server, local or keep-both")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "only show what would be synced")
+	bindFlag(cmd.Flags(), "policy", "sync.join")
+	return cmd
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+type membersResult struct {
+	Path     string          `json:"path"`
+	Members  []remote.Member `json:"members,omitempty"`
+	Rotation *sync.Rotation  `json:"rotation,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func (a *app) newMembersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "members",
+		Short: "Share encrypted graphs with other users",
+		Long: "Share encrypted graphs with other users. A shared graph is encrypted with a random key, that is wrapped\n" +
+			"for the public key of every member, see logsync identity. Members don't need the passphrase of the graph.",
+		Args: usageArgs(cobra.NoArgs),
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list [graph...]",
+			Short: "List the members of the graphs",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return a.runMembers(args, "", func(conf config.Config, graphPath string) (membersResult, error) {
+					members, err := sync.Members(cmd.Context(), conf, graphPath)
+					return membersResult{Members: members}, err
+				})
+			},
+		},
+		a.newMembersInviteCmd(),
+		&cobra.Command{
+			Use:   "accept [graph...]",
+			Short: "Accept the invitations to the graphs",
+			Long:  "Accept the invitations to the graphs. The key of the graph is unwrapped and verified, the graphs are synced afterwards.",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return a.runMembers(args, "accepted the invitation", func(conf config.Config, graphPath string) (membersResult, error) {
+					return membersResult{}, sync.AcceptInvitation(cmd.Context(), conf, graphPath)
+				})
+			},
+		},
+		&cobra.Command{
+			Use:   "remove <graph> <public-key>",
+			Short: "Remove a member and rotate the key of the graph",
+			Long: "Remove a member from the graph and encrypt all files with a new key, that is wrapped for the remaining\n" +
+				"members, so that the removed member can't decrypt new changes.",
+			Args: usageArgs(cobra.ExactArgs(2)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return a.runMembers(args[:1], "removed the member", func(conf config.Config, graphPath string) (membersResult, error) {
+					rotation, err := sync.RemoveMember(cmd.Context(), conf, graphPath, args[1])
+					return membersResult{Rotation: &rotation}, err
+				})
+			},
+		},
+		&cobra.Command{
+			Use:   "rotate [graph...]",
+			Short: "Encrypt the graphs with a new key for the current members",
+			Long:  "Encrypt the graphs with a new key for the current members. An interrupted rotation is resumed.",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return a.runMembers(args, "rotated the key", func(conf config.Config, graphPath string) (membersResult, error) {
+					rotation, err := sync.RotateMembers(cmd.Context(), conf, graphPath)
+					return membersResult{Rotation: &rotation}, err
+				})
+			},
+		},
+	)
+	return cmd
+}
+
+func (a *app) newMembersInviteCmd() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "invite <graph> <public-key>",
+		Short: "Share the graph with the owner of the public key",
+		Long: "Share the graph with the owner of the public key, who accepts the invitation with logsync members accept.\n" +
+			"A graph encrypted with a passphrase is encrypted with a random key first, that is wrapped for the own identity\n" +
+			"and the new member. Other devices use the identity instead of the passphrase afterwards.",
+		Args: usageArgs(cobra.ExactArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runMembers(args[:1], "invited the member", func(conf config.Config, graphPath string) (membersResult, error) {
+				rotation, err := sync.InviteMember(cmd.Context(), conf, graphPath, args[1], name)
+				if rotation.Graph == "" {
+					return membersResult{}, err
+				}
+				return membersResult{Rotation: &rotation}, err
+			})
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the member")
+	return cmd
+}
+
+func (a *app) runMembers(args []string, done string, action func(conf config.Config, graphPath string) (membersResult, error)) error {
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	code := ExitOK
+	results := make([]membersResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		result, err := action(conf, graphPath)
+		result.Path = graphPath
+		if err != nil {
+			code = ExitError
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+		return withCode(code)
+	}
+
+	ownKey := sync.PublicKey(conf)
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			a.printf("%s: failed: %s\n", result.Path, result.Error)
+		case done == "":
+			a.printf("%s:\n", result.Path)
+			for _, member := range result.Members {
+				marker := ""
+				if member.PublicKey == ownKey {
+					marker = " (this identity)"
+				}
+				state := "invited"
+				if member.Accepted {
+					state = "accepted"
+				}
+				a.printf("  %-20s %s  %s %s%s\n", member.Name, member.PublicKey, state, displayAge(member.InvitedAt), marker)
+			}
+		case result.Rotation != nil:
+			a.printf("%s: %s, rotated %d files to key epoch %d\n", result.Path, done, result.Rotation.Files, result.Rotation.Epoch)
+		default:
+			a.printf("%s: %s\n", result.Path, done)
+		}
+	}
+	return withCode(code)
+}
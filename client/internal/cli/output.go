@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"time"
+)
+
+func (a *app) printJSON(v any) error {
+	encoder := json.NewEncoder(a.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (a *app) printf(format string, args ...any) {
+	fmt.Fprintf(a.out, format, args...)
+}
+
+func displayPath(fileId string) string {
+	relPath, err := graph.DecodeFileId(fileId)
+	if err != nil {
+		return fileId
+	}
+	return relPath
+}
+
+func displayTime(t time.Time) string {
+	if t.IsZero() || t.Equal(time.UnixMilli(0)) {
+		return "never"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func displayAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	age := time.Since(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%d minutes ago", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%d hours ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%d days ago", int(age.Hours()/24))
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
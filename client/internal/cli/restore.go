@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+func (a *app) newRestoreCmd() *cobra.Command {
+	var graphArg string
+	cmd := &cobra.Command{
+		Use:   "restore <file...>",
+		Short: "Overwrite local files with the version of the server",
+		Long: "Overwrite local files with the version of the server, e.g. to resolve a conflict. Local changes to the files are lost.\n" +
+			"The files are resolved from the working directory or, with --graph, from the root of the graph.",
+		Args: usageArgs(cobra.MinimumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runRestore(graphArg, args)
+		},
+	}
+	cmd.Flags().StringVarP(&graphArg, "graph", "g", "", "name or path of the graph")
+	return cmd
+}
+
+func (a *app) runRestore(graphArg string, files []string) error {
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+
+	// files of different graphs are restored graph by graph
+	fileIds := make(map[string][]string)
+	graphs := make([]string, 0)
+	for _, file := range files {
+		graphPath, fileId, err := resolveFile(conf, graphArg, file)
+		if err != nil {
+			return err
+		}
+		if _, ok := fileIds[graphPath]; !ok {
+			graphs = append(graphs, graphPath)
+		}
+		fileIds[graphPath] = append(fileIds[graphPath], fileId)
+	}
+
+	code := ExitOK
+	results := make([]syncResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		report, err := sync.Restore(conf, graphPath, fileIds[graphPath])
+		if err != nil {
+			code = ExitError
+		}
+		results = append(results, syncResult{Report: report, Path: graphPath, Error: errorString(err)})
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			for _, fileId := range result.Downloaded {
+				a.printf("restored  %s\n", displayPath(fileId))
+			}
+			if result.Error != "" {
+				a.printf("%s: failed: %s\n", result.Path, result.Error)
+			}
+		}
+	}
+	return withCode(code)
+}
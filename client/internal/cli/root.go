@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+)
+
+type app struct {
+	configFile string
+	json       bool
+	verbose    bool
+
+	out    io.Writer
+	errOut io.Writer
+}
+
+// Execute runs the command given by the arguments of the process and returns the exit code
+func Execute() int {
+	a := &app{out: os.Stdout, errOut: os.Stderr}
+	return a.execute(os.Args[1:])
+}
+
+func (a *app) execute(args []string) int {
+	cmd := a.newRootCmd()
+	cmd.SetArgs(args)
+	cmd.SetOut(a.out)
+	cmd.SetErr(a.errOut)
+
+	err := cmd.Execute()
+	var exitErr exitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.err == nil) {
+		fmt.Fprintf(a.errOut, "Error: %v\n", err)
+	}
+	return exitCode(err)
+}
+
+func (a *app) newRootCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logsync",
+		Short: "Sync logseq graphs with a logsync server",
+		Long: "Sync logseq graphs with a logsync server.\n" +
+			"Without a command, the graphs are synced once or periodically, depending on sync.once.",
+		Args:          usageArgs(cobra.NoArgs),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE:          a.runLegacy,
+	}
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError(err)
+	})
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&a.configFile, "config", "", "path of the config file")
+	flags.String("server", "", "url of the server, overrides server.host")
+	flags.String("api-token", "", "api token of the server, overrides server.apitoken")
+	flags.String("key-file", "", "file with the encryption passphrase, - reads it from stdin, overrides encryption.keyfile")
+	flags.BoolVar(&a.json, "json", false, "print the output as json")
+	flags.BoolVarP(&a.verbose, "verbose", "v", false, "print log messages to stderr")
+	bindFlag(flags, "server", "server.host")
+	bindFlag(flags, "api-token", "server.apitoken")
+	bindFlag(flags, "key-file", "encryption.keyfile")
+
+	cmd.AddCommand(
+		a.newInitCmd(),
+		a.newSyncCmd(),
+		a.newJoinCmd(),
+		a.newStatusCmd(),
+		a.newDiffCmd(),
+		a.newConflictsCmd(),
+		a.newHistoryCmd(),
+		a.newRestoreCmd(),
+		a.newVerifyCmd(),
+		a.newDevicesCmd(),
+		a.newRotateKeyCmd(),
+		a.newIdentityCmd(),
+		a.newMembersCmd(),
+		a.newDaemonCmd(),
+	)
+	return cmd
+}
+
+func bindFlag(flags *pflag.FlagSet, name, key string) {
+	err := viper.BindPFlag(key, flags.Lookup(name))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		err := validate(cmd, args)
+		if err != nil {
+			return usageError(err)
+		}
+		return nil
+	}
+}
+
+func (a *app) readConfig() (config.Config, error) {
+	if a.configFile != "" {
+		config.SetFile(a.configFile)
+	}
+
+	conf, err := config.Read()
+	if err != nil {
+		return config.Config{}, usageError(fmt.Errorf("failed to read config: %w", err))
+	}
+
+	err = conf.ValidateGraphs()
+	if err != nil {
+		return config.Config{}, usageError(err)
+	}
+	return conf, nil
+}
+
+func (a *app) quiet() {
+	if a.verbose {
+		log.SetOutput(a.errOut)
+	} else {
+		log.SetOutput(io.Discard)
+	}
+}
+
+func (a *app) runLegacy(cmd *cobra.Command, args []string) error {
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	err = conf.ValidateInterval()
+	if err != nil {
+		return usageError(err)
+	}
+
+	log.SetOutput(a.out)
+	ctx, stop := stopContext(cmd)
+	defer stop()
+	sync.Start(ctx, conf)
+	return nil
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+type rotateResult struct {
+	sync.Rotation
+	Path     string `json:"path"`
+	Canceled bool   `json:"canceled,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (a *app) newRotateKeyCmd() *cobra.Command {
+	var newKeyFile string
+	var cancel bool
+	cmd := &cobra.Command{
+		Use:   "rotate-key [graph...]",
+		Short: "Encrypt the graphs with a new passphrase",
+		Long: "Encrypt all files of the graphs on the server with the key derived from the new passphrase.\n" +
+			"The files are replaced at once, when all are encrypted. An interrupted rotation is resumed by running the\n" +
+			"command again with the same passphrase or discarded with --cancel. Other devices can't upload during the rotation\n" +
+			"and refuse to sync afterwards, until their encryption.key is set to the new passphrase.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runRotateKey(cmd.Context(), args, newKeyFile, cancel)
+		},
+	}
+	cmd.Flags().StringVar(&newKeyFile, "new-key-file", "", "file with the new passphrase, - reads it from stdin")
+	cmd.Flags().BoolVar(&cancel, "cancel", false, "discard a running rotation")
+	return cmd
+}
+
+func (a *app) runRotateKey(ctx context.Context, args []string, newKeyFile string, cancel bool) error {
+	if newKeyFile == "" && !cancel {
+		return usageError(errors.New("--new-key-file is required"))
+	}
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	if newKeyFile == "-" && conf.Encryption.KeyFile == "-" {
+		return usageError(errors.New("only one of --key-file and --new-key-file can read from stdin"))
+	}
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	var passphrase string
+	if !cancel {
+		passphrase, err = config.ReadKeyFile(newKeyFile)
+		if err != nil {
+			return usageError(err)
+		}
+	}
+
+	code := ExitOK
+	results := make([]rotateResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		result := rotateResult{Path: graphPath, Canceled: cancel}
+		if cancel {
+			err = sync.CancelRotation(ctx, conf, graphPath)
+		} else {
+			result.Rotation, err = sync.RotateKey(ctx, conf, graphPath, passphrase)
+		}
+		if err != nil {
+			code = ExitError
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+		return withCode(code)
+	}
+
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			a.printf("%s: failed: %s\n", result.Path, result.Error)
+		case result.Canceled:
+			a.printf("%s: rotation canceled\n", result.Path)
+		default:
+			a.printf("%s: rotated %d files to key epoch %d\n", result.Path, result.Files, result.Epoch)
+		}
+	}
+	if code == ExitOK && !cancel {
+		a.printf("Set encryption.key to the new passphrase on all devices\n")
+	}
+	return withCode(code)
+}
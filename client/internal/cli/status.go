@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+type statusResult struct {
+	sync.Status
+	Error string `json:"error,omitempty"`
+}
+
+func (a *app) newStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status [graph...]",
+		Short: "Show whether the graphs are in sync",
+		Long: "Show the time of the last successful sync, the local changes, that are not uploaded yet, the remote changes,\n" +
+			"that are not downloaded yet, the conflicts and whether the server is reachable.\n" +
+			"Exits with 1, when the server is not reachable, with 3, when there are conflicts, and with 4, when there are pending changes.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := a.readConfigQuiet()
+			if err != nil {
+				return err
+			}
+			return a.runStatus(conf, args, a.printStatus)
+		},
+	}
+}
+
+func (a *app) newDiffCmd() *cobra.Command {
+	var graphArg string
+	cmd := &cobra.Command{
+		Use:   "diff [graph|file...]",
+		Short: "Show the changes of graphs or files",
+		Long: "For graphs, list the files, that were changed locally or on the server since the last sync.\n" +
+			"For files, compare the version on the server with the local file. Encrypted files are decrypted.\n" +
+			"Files are resolved from the working directory or, with --graph, from the root of the graph.\n" +
+			"Exits with 4, when there are changes.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := a.readConfigQuiet()
+			if err != nil {
+				return err
+			}
+			if len(args) > 0 && (graphArg != "" || !allGraphs(conf, args)) {
+				return a.runFileDiff(conf, graphArg, args)
+			}
+			return a.runStatus(conf, args, a.printDiff)
+		},
+	}
+	cmd.Flags().StringVarP(&graphArg, "graph", "g", "", "name or path of the graph of the files")
+	return cmd
+}
+
+func (a *app) readConfigQuiet() (config.Config, error) {
+	a.quiet()
+	return a.readConfig()
+}
+
+func allGraphs(conf config.Config, args []string) bool {
+	for _, arg := range args {
+		_, err := findGraph(conf, arg)
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *app) runStatus(conf config.Config, args []string, print func(statusResult)) error {
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	code := ExitOK
+	results := make([]statusResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		status, err := sync.GetStatus(conf, graphPath)
+		status.Path = graphPath
+		code = statusCode(code, status, err)
+		results = append(results, statusResult{Status: status, Error: errorString(err)})
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			print(result)
+		}
+	}
+	return withCode(code)
+}
+
+func statusCode(code int, status sync.Status, err error) int {
+	switch {
+	case err != nil || !status.Reachable:
+		return ExitError
+	case code == ExitError:
+		return code
+	case len(status.Conflicts) > 0:
+		return ExitConflicts
+	case code == ExitConflicts:
+		return code
+	case !status.InSync():
+		return ExitPending
+	default:
+		return code
+	}
+}
+
+func (a *app) printStatus(result statusResult) {
+	if result.Error != "" {
+		a.printf("%s: failed: %s\n", result.Path, result.Error)
+		return
+	}
+
+	a.printf("%s (%s)\n", result.Graph, result.Path)
+	a.printf("  last sync: %s\n", displayTime(result.LastSync))
+	if result.Mode != sync.ModeBidirectional {
+		a.printf("  mode:      %s\n", result.Mode)
+	}
+	if result.Reachable {
+		a.printf("  server:    reachable\n")
+	} else {
+		a.printf("  server:    not reachable: %s\n", result.ServerError)
+	}
+	if result.HasLocalChanges() {
+		a.printf("  local:     %d created, %d changed, %d deleted\n", len(result.Created), len(result.Changed), len(result.Deleted))
+	} else {
+		a.printf("  local:     no changes\n")
+	}
+	if result.Reachable {
+		a.printf("  remote:    %d changed files\n", len(result.Remote))
+		a.printf("  conflicts: %d\n", len(result.Conflicts))
+	}
+}
+
+func (a *app) printDiff(result statusResult) {
+	if result.Error != "" {
+		a.printf("%s: failed: %s\n", result.Path, result.Error)
+		return
+	}
+
+	a.printf("%s (%s)\n", result.Graph, result.Path)
+	if !result.Reachable {
+		a.printf("  server not reachable: %s\n", result.ServerError)
+	}
+	lists := []struct {
+		label string
+		ids   []string
+	}{
+		{"created", result.Created},
+		{"changed", result.Changed},
+		{"deleted", result.Deleted},
+		{"remote", result.Remote},
+		{"conflict", result.Conflicts},
+	}
+	for _, list := range lists {
+		for _, fileId := range list.ids {
+			a.printf("  %-8s  %s\n", list.label, displayPath(fileId))
+		}
+	}
+}
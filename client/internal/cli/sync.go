@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+type syncResult struct {
+	sync.Report
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+type planResult struct {
+	sync.Plan
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+func (a *app) newSyncCmd() *cobra.Command {
+	var dryRun, confirmDeletes bool
+	cmd := &cobra.Command{
+		Use:   "sync [graph...]",
+		Short: "Sync the graphs once",
+		Long: "Sync the graphs once. Graphs are selected by name or path, without arguments all configured graphs are synced.\n" +
+			"Exits with 3, when there are conflicts, and with 1, when a file or graph could not be synced.\n" +
+			"With --dry-run, only the planned downloads, uploads and deletions are listed. Neither the graph, the server nor\n" +
+			"the state of the last sync are changed. Exits with 4, when there is something to sync.\n" +
+			"A sync deleting more files on the server than sync.deletelimit is refused, until it is run with --confirm-deletes.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dryRun {
+				return a.runPlan(args)
+			}
+			return a.runSync(args, confirmDeletes)
+		},
+	}
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "only show what would be synced")
+	cmd.Flags().BoolVar(&confirmDeletes, "confirm-deletes", false, "delete files on the server above sync.deletelimit")
+	return cmd
+}
+
+func (a *app) runSync(args []string, confirmDeletes bool) error {
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	conf.Sync.ConfirmDeletes = confirmDeletes
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	code := ExitOK
+	results := make([]syncResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		report, err := sync.Graph(conf, graphPath)
+		if err != nil || len(report.Failed) > 0 {
+			code = ExitError
+		} else if len(report.Conflicts) > 0 && code == ExitOK {
+			code = ExitConflicts
+		}
+		results = append(results, syncResult{Report: report, Path: graphPath, Error: errorString(err)})
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			a.printSyncResult(result)
+		}
+	}
+	return withCode(code)
+}
+
+func (a *app) printSyncResult(result syncResult) {
+	if result.Error != "" {
+		a.printf("%s: failed: %s\n", result.Path, result.Error)
+		return
+	}
+
+	a.printf("%s: %d downloaded, %d removed, %d uploaded, %d deleted\n", result.Graph,
+		len(result.Downloaded), len(result.Removed), len(result.Uploaded), len(result.Deleted))
+	for _, fileId := range result.Conflicts {
+		a.printf("  conflict  %s\n", displayPath(fileId))
+	}
+	for _, fileId := range result.Failed {
+		a.printf("  failed    %s\n", displayPath(fileId))
+	}
+	for _, warning := range result.Warnings {
+		a.printf("  warning   %s\n", warning)
+	}
+}
+
+func (a *app) runPlan(args []string) error {
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	code := ExitOK
+	results := make([]planResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		plan, err := sync.GetPlan(conf, graphPath)
+		if err != nil {
+			code = ExitError
+		} else if len(plan.Conflicts) > 0 && code != ExitError {
+			code = ExitConflicts
+		} else if !plan.Empty() && code == ExitOK {
+			code = ExitPending
+		}
+		results = append(results, planResult{Plan: plan, Path: graphPath, Error: errorString(err)})
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range results {
+			a.printPlan(result)
+		}
+	}
+	return withCode(code)
+}
+
+func (a *app) printPlan(result planResult) {
+	if result.Error != "" {
+		a.printf("%s: failed: %s\n", result.Path, result.Error)
+		return
+	}
+
+	a.printf("%s (%s), last sync: %s\n", result.Graph, result.Path, displayTime(result.LastSync))
+	if result.Empty() && len(result.Conflicts) == 0 {
+		a.printf("  nothing to sync\n")
+	}
+	lists := []struct {
+		label string
+		ids   []string
+	}{
+		{"download", result.Download},
+		{"remove", result.Remove},
+		{"upload", result.Upload},
+		{"delete", result.Delete},
+		{"conflict", result.Conflicts},
+		{"skip", result.Skipped},
+		{"invalid", result.Invalid},
+	}
+	for _, list := range lists {
+		for _, fileId := range list.ids {
+			a.printf("  %-8s  %s\n", list.label, displayPath(fileId))
+		}
+	}
+}
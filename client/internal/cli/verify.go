@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+type verifyResult struct {
+	sync.Drift
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+func (a *app) newVerifyCmd() *cobra.Command {
+	var repair bool
+	cmd := &cobra.Command{
+		Use:   "verify [graph...]",
+		Short: "Compare the graphs with the manifest of the server",
+		Long: "Compare the synced state of the graphs with the manifest of the server. Changes since the last sync are ignored,\n" +
+			"the next sync transfers them. With --repair, missing and mismatched files are downloaded and extra files are uploaded by the next sync.\n" +
+			"Exits with 5, when drift was found and not repaired.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runVerify(args, repair)
+		},
+	}
+	cmd.Flags().BoolVar(&repair, "repair", false, "repair the drift")
+	return cmd
+}
+
+func (a *app) runVerify(args []string, repair bool) error {
+	a.quiet()
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	graphs, err := selectGraphs(conf, args)
+	if err != nil {
+		return err
+	}
+
+	code := ExitOK
+	results := make([]verifyResult, 0, len(graphs))
+	for _, graphPath := range graphs {
+		drift, err := sync.Verify(conf, graphPath, repair)
+		if err != nil {
+			code = ExitError
+		} else if !drift.None() && !drift.Repaired && code == ExitOK {
+			code = ExitDrift
+		}
+		results = append(results, verifyResult{Drift: drift, Path: graphPath, Error: errorString(err)})
+	}
+
+	if a.json {
+		err = a.printJSON(results)
+		if err != nil {
+			return err
+		}
+		return withCode(code)
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			a.printf("%s: failed: %s\n", result.Path, result.Error)
+			continue
+		}
+		if result.None() {
+			a.printf("%s: in sync with revision %d\n", result.Path, result.Revision)
+			continue
+		}
+		state := "drift"
+		if result.Repaired {
+			state = "repaired"
+		}
+		a.printf("%s: %s at revision %d\n", result.Path, state, result.Revision)
+		for _, fileId := range result.Missing {
+			a.printf("  missing     %s\n", displayPath(fileId))
+		}
+		for _, fileId := range result.Extra {
+			a.printf("  extra       %s\n", displayPath(fileId))
+		}
+		for _, fileId := range result.Mismatched {
+			a.printf("  mismatched  %s\n", displayPath(fileId))
+		}
+	}
+	return withCode(code)
+}
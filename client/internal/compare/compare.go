@@ -23,10 +23,8 @@ func Graphs(old graph.Graph, new graph.Graph) Result {
 		foundOld := find(old.Files, newFile.Id)
 		if foundOld == nil {
 			created = append(created, newFile)
-		} else {
-			if newFile.LastChange.After(foundOld.LastChange) {
-				changed = append(changed, newFile)
-			}
+		} else if hasChanged(*foundOld, newFile) {
+			changed = append(changed, newFile)
 		}
 	}
 
@@ -44,6 +42,18 @@ func Graphs(old graph.Graph, new graph.Graph) Result {
 	}
 }
 
+// hasChanged directories only change with their mode, their modification time
+// changes whenever an entry is added or removed
+func hasChanged(old graph.File, new graph.File) bool {
+	// graphs saved by older versions don't know the mode
+	modeChanged := old.Mode != 0 && old.Mode != new.Mode
+	if new.IsDir() {
+		return modeChanged
+	}
+
+	return modeChanged || new.Target != old.Target || new.LastChange.After(old.LastChange)
+}
+
 func find(files []graph.File, id string) *graph.File {
 	index := slices.IndexFunc(files, func(file graph.File) bool {
 		return file.Id == id
@@ -99,3 +99,33 @@ func TestGraphs(t *testing.T) {
 		}
 	})
 }
+
+func TestGraphsMetadata(t *testing.T) {
+	t.Run("directory with new modification time", func(t *testing.T) {
+		oldGraph := graph.Graph{Files: []graph.File{
+			{Id: "assets", Kind: graph.KindDir, Mode: 0755, LastChange: time.UnixMilli(100000000)},
+		}}
+		newGraph := graph.Graph{Files: []graph.File{
+			{Id: "assets", Kind: graph.KindDir, Mode: 0755, LastChange: time.UnixMilli(200000000)},
+		}}
+
+		res := Graphs(oldGraph, newGraph)
+		if !res.NoChanges() {
+			t.Fatalf("Expected no changes, got %v", res)
+		}
+	})
+
+	t.Run("changed mode", func(t *testing.T) {
+		oldGraph := graph.Graph{Files: []graph.File{
+			{Id: "test1", Kind: graph.KindFile, Mode: 0644, LastChange: time.UnixMilli(100000000)},
+		}}
+		newGraph := graph.Graph{Files: []graph.File{
+			{Id: "test1", Kind: graph.KindFile, Mode: 0600, LastChange: time.UnixMilli(100000000)},
+		}}
+
+		res := Graphs(oldGraph, newGraph)
+		if len(res.Changed) != 1 {
+			t.Fatalf("Expected 1 changed, got %d", len(res.Changed))
+		}
+	})
+}
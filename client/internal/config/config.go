@@ -1,8 +1,14 @@
 package config
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/log"
 	"github.com/spf13/viper"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -10,30 +16,110 @@ type Config struct {
 	Encryption EncryptionConfig
 	Sync       SyncConfig
 	Server     ServerConfig
+	Device     DeviceConfig
 }
 
 type SyncConfig struct {
 	Graphs   []string
 	Interval int
 	Once     bool
+	Profile  string
+	Symlinks string
+	// Compression of uploaded content, gzip or none
+	Compression string
+	// Mode of the graphs, that don't set their own mode in sync.graphs
+	Mode string
+	// Modes of the graphs by their path
+	Modes map[string]string
+	// Join policy for files, that differ from the server on the first sync of a graph directory with files
+	Join string
+	// DeleteLimit is the number or the percentage of files, that a sync deletes on the server without confirmation
+	DeleteLimit string
+	// ConfirmDeletes allows the sync to exceed the DeleteLimit, it is set by logsync sync --confirm-deletes
+	ConfirmDeletes bool
+	// Verify every n-th periodic sync compares the graphs with the manifest of the server and repairs drift
+	Verify int
 }
 type EncryptionConfig struct {
 	Enabled bool
-	Key     string
+	// Key is the passphrase, the keys of the graphs are derived from
+	Key string
+	// KeyFile contains the passphrase instead of the config, "-" reads it from stdin
+	KeyFile string
+	// Identity is the file with the private key of the user, that unwraps the keys of shared graphs
+	Identity string
+}
+
+// DeviceConfig identifies the client on the server. Without an id in the config,
+// the id generated on the first sync is used.
+type DeviceConfig struct {
+	Id   string
+	Name string
 }
 
 type ServerConfig struct {
 	Host     string
 	ApiToken string
+	// Timeout of a request in seconds, 0 waits forever
+	Timeout int
+	// Retries of requests, that failed because of the network or a server error
+	Retries int
+}
+
+var configFile string
+
+// SetFile reads the config from the given file instead of searching the default locations
+func SetFile(path string) {
+	configFile = path
 }
 
+// Read reads the config file and the environment. Flags bound to viper take precedence.
 func Read() (Config, error) {
-	viper.SetConfigName("config")
+	err := load()
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf := getConfig()
+	conf.Encryption.Key, err = readPassphrase(conf.Encryption)
+	if err != nil {
+		return Config{}, err
+	}
+	err = validateConfig(conf)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return conf, nil
+}
+
+// IdentityFile returns the path of the identity without validating the config,
+// so that the identity can be created before the encryption is configured
+func IdentityFile() (string, error) {
+	err := load()
+	if err != nil {
+		return "", err
+	}
+	path := viper.GetString("encryption.identity")
+	if path == "" {
+		return "", errors.New("encryption.identity is required")
+	}
+	return path, nil
+}
+
+func load() error {
+	if configFile != "" {
+		// SetConfigName would reset the file
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(".")
+		viper.AddConfigPath("..")
+		viper.AddConfigPath("$HOME/.config/logsync")
+		viper.AddConfigPath("/etc/logsync")
+		viper.AddConfigPath("$HOME/.logsync")
+	}
 	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("..")
-	viper.AddConfigPath("/etc/logsync")
-	viper.AddConfigPath("$HOME/.logsync")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.SetEnvPrefix("LOGSYNC_CLIENT")
 	viper.AutomaticEnv()
@@ -42,43 +128,163 @@ func Read() (Config, error) {
 	err := viper.ReadInConfig()
 	if err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return Config{}, err
+			return err
 		}
 	}
-
-	conf := getConfig()
-	err = validateConfig(conf)
-	if err != nil {
-		return Config{}, err
-	}
-
-	return conf, nil
+	return nil
 }
 
 func defineDefaults() {
 	viper.SetDefault("encryption.enabled", false)
+	viper.SetDefault("encryption.identity", identityPath())
 	viper.SetDefault("graphs", []string{})
 
 	viper.SetDefault("sync.interval", 60)
 	viper.SetDefault("sync.once", false)
+	viper.SetDefault("sync.profile", "logseq")
+	viper.SetDefault("sync.symlinks", "skip")
+	viper.SetDefault("sync.compression", "gzip")
+	viper.SetDefault("sync.mode", "bidirectional")
+	viper.SetDefault("sync.join", "ask")
+	viper.SetDefault("sync.deletelimit", "50%")
+	viper.SetDefault("sync.verify", 60)
+	viper.SetDefault("server.timeout", 60)
+	viper.SetDefault("server.retries", 3)
+	viper.SetDefault("device.name", hostname())
 }
 
 func getConfig() Config {
+	graphs, modes := readGraphs()
 	return Config{
 		Encryption: EncryptionConfig{
-			Enabled: viper.GetBool("encryption.enabled"),
-			Key:     viper.GetString("encryption.key"),
+			Enabled:  viper.GetBool("encryption.enabled"),
+			Key:      viper.GetString("encryption.key"),
+			KeyFile:  viper.GetString("encryption.keyfile"),
+			Identity: viper.GetString("encryption.identity"),
 		},
 		Sync: SyncConfig{
-			Graphs:   viper.GetStringSlice("sync.graphs"),
-			Interval: viper.GetInt("sync.interval"),
-			Once:     viper.GetBool("sync.once"),
+			Graphs:      graphs,
+			Modes:       modes,
+			Interval:    viper.GetInt("sync.interval"),
+			Once:        viper.GetBool("sync.once"),
+			Profile:     viper.GetString("sync.profile"),
+			Symlinks:    viper.GetString("sync.symlinks"),
+			Compression: viper.GetString("sync.compression"),
+			Mode:        viper.GetString("sync.mode"),
+			Join:        viper.GetString("sync.join"),
+			DeleteLimit: viper.GetString("sync.deletelimit"),
+			Verify:      viper.GetInt("sync.verify"),
 		},
 		Server: ServerConfig{
 			Host:     viper.GetString("server.host"),
 			ApiToken: viper.GetString("server.apitoken"),
+			Timeout:  viper.GetInt("server.timeout"),
+			Retries:  viper.GetInt("server.retries"),
 		},
+		Device: DeviceConfig{
+			Id:   viper.GetString("device.id"),
+			Name: viper.GetString("device.name"),
+		},
+	}
+}
+
+func readGraphs() ([]string, map[string]string) {
+	entries, ok := viper.Get("sync.graphs").([]any)
+	if !ok {
+		return viper.GetStringSlice("sync.graphs"), map[string]string{}
+	}
+
+	graphs := make([]string, 0, len(entries))
+	modes := make(map[string]string)
+	for _, entry := range entries {
+		switch entry := entry.(type) {
+		case map[string]any:
+			path := fmt.Sprint(entry["path"])
+			graphs = append(graphs, path)
+			if mode, ok := entry["mode"]; ok {
+				modes[path] = fmt.Sprint(mode)
+			}
+		default:
+			graphs = append(graphs, fmt.Sprint(entry))
+		}
+	}
+	return graphs, modes
+}
+
+// ModeOf returns the mode of the graph, sync.mode applies to graphs without their own mode
+func (c SyncConfig) ModeOf(graphPath string) string {
+	if mode, ok := c.Modes[graphPath]; ok {
+		return mode
+	}
+	return c.Mode
+}
+
+func readPassphrase(conf EncryptionConfig) (string, error) {
+	if !conf.Enabled || conf.KeyFile == "" {
+		return conf.Key, nil
+	}
+	if conf.Key != "" {
+		return "", errors.New("only one of encryption.key and encryption.keyfile can be set")
+	}
+	return ReadKeyFile(conf.KeyFile)
+}
+
+// ReadKeyFile reads a passphrase from the file or, with "-", from the first line of stdin
+func ReadKeyFile(path string) (string, error) {
+	var passphrase string
+	source := path
+	if path == "-" {
+		source = "stdin"
+		// only the first line is read, so that the passphrase can be piped into the daemon
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read the passphrase from %s: %w", source, err)
+		}
+		passphrase = line
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the key file: %w", err)
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			log.Info("Key file %s is accessible by other users, restrict it with chmod 600", path)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the key file: %w", err)
+		}
+		passphrase = string(content)
 	}
+
+	passphrase = strings.TrimRight(passphrase, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("the passphrase in %s is empty", source)
+	}
+	return passphrase, nil
+}
+
+func exists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+func identityPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "logsync", "identity")
 }
 
 func validateConfig(config Config) error {
@@ -86,17 +292,25 @@ func validateConfig(config Config) error {
 		return errors.New("server.host is required")
 	}
 
-	if len(config.Sync.Graphs) == 0 {
-		return errors.New("server.graphs must not be empty")
+	if config.Encryption.Enabled && config.Encryption.Key == "" && !exists(config.Encryption.Identity) {
+		return errors.New("encryption.key, encryption.keyfile or an identity is required, when encryption is enabled")
 	}
 
-	if config.Encryption.Enabled && config.Encryption.Key == "" {
-		return errors.New("encryption.key is required, when encryption is enabled")
+	return nil
+}
+
+// ValidateGraphs checks, that graphs are configured. Commands working on the graphs call it.
+func (c Config) ValidateGraphs() error {
+	if len(c.Sync.Graphs) == 0 {
+		return errors.New("sync.graphs must not be empty")
 	}
+	return nil
+}
 
-	if !config.Sync.Once && config.Sync.Interval <= 0 {
+// ValidateInterval checks the interval, when the graphs are synced periodically
+func (c Config) ValidateInterval() error {
+	if !c.Sync.Once && c.Sync.Interval <= 0 {
 		return errors.New("sync.interval must be set, when sync.once is disabled")
 	}
-
 	return nil
 }
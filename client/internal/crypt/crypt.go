@@ -3,14 +3,17 @@ package crypt
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
+	"slices"
 )
 
-func Encrypt(value []byte, key string) ([]byte, error) {
-	aesBlock, err := aes.NewCipher(hashKey(key))
+func Encrypt(value []byte, key Key) ([]byte, error) {
+	aesBlock, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +33,7 @@ func Encrypt(value []byte, key string) ([]byte, error) {
 	return cipheredText, nil
 }
 
-func EncryptString(value string, key string) (string, error) {
+func EncryptString(value string, key Key) (string, error) {
 	data, err := Encrypt([]byte(value), key)
 	if err != nil {
 		return "", err
@@ -38,9 +41,64 @@ func EncryptString(value string, key string) (string, error) {
 	return hex.EncodeToString(data), nil
 }
 
-func Decrypt(encrypted []byte, key string) ([]byte, error) {
-	hashedKey := hashKey(key)
-	aesBlock, err := aes.NewCipher(hashedKey)
+// EncryptFileId encrypts the id deterministically, so the same id always results
+// in the same encrypted id. The nonce is derived from the id (synthetic nonce).
+func EncryptFileId(fileId string, key Key) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := syntheticNonce([]byte(fileId), key, gcm.NonceSize())
+	cipheredText := gcm.Seal(nonce, nonce, []byte(fileId), nil)
+	return hex.EncodeToString(cipheredText), nil
+}
+
+// DecryptFileId decrypts an id encrypted by EncryptFileId. Ids encrypted by
+// older versions used a random nonce, for those legacy is true.
+func DecryptFileId(encrypted string, key Key) (fileId string, legacy bool, err error) {
+	decoded, err := hex.DecodeString(encrypted)
+	if err != nil {
+		return "", false, err
+	}
+	data, err := Decrypt(decoded, key)
+	if err != nil {
+		return "", false, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", false, err
+	}
+	nonce := syntheticNonce(data, key, gcm.NonceSize())
+	legacy = !hmac.Equal(nonce, decoded[:gcm.NonceSize()])
+
+	return string(data), legacy, nil
+}
+
+func syntheticNonce(value []byte, key Key, size int) []byte {
+	mac := hmac.New(sha256.New, nonceKey(key))
+	mac.Write(value)
+	return mac.Sum(nil)[:size]
+}
+
+// nonceKey separate key for deriving nonces, the encryption key is not reused for the hmac
+func nonceKey(key Key) []byte {
+	hash := sha256.Sum256(append(slices.Clone(key), []byte("logsync file id nonce")...))
+	return hash[:]
+}
+
+func newGCM(key Key) (cipher.AEAD, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(aesBlock)
+}
+
+func Decrypt(encrypted []byte, key Key) ([]byte, error) {
+	aesBlock, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -51,12 +109,15 @@ func Decrypt(encrypted []byte, key string) ([]byte, error) {
 	}
 
 	nonceSize := gcm.NonceSize()
+	if len(encrypted) < nonceSize {
+		return nil, errors.New("encrypted value is too short")
+	}
 	nonce, cipheredText := encrypted[:nonceSize], encrypted[nonceSize:]
 
 	return gcm.Open(nil, nonce, cipheredText, nil)
 }
 
-func DecryptString(encrypted string, key string) (string, error) {
+func DecryptString(encrypted string, key Key) (string, error) {
 	decoded, err := hex.DecodeString(encrypted)
 	if err != nil {
 		return "", err
@@ -68,9 +129,3 @@ func DecryptString(encrypted string, key string) (string, error) {
 
 	return string(data), nil
 }
-
-func hashKey(key string) []byte {
-	text := []byte(key)
-	hash := sha256.Sum256(text)
-	return hash[:]
-}
@@ -5,7 +5,7 @@ import "testing"
 func TestEncryptDecrypt(t *testing.T) {
 	t.Run("Encrypt and decrypt", func(t *testing.T) {
 		content := []byte("This is the payload")
-		key := "super_secure_testing_key"
+		key := LegacyKey("super_secure_testing_key")
 
 		enrypted, err := Encrypt(content, key)
 		if err != nil {
@@ -23,7 +23,7 @@ func TestEncryptDecrypt(t *testing.T) {
 
 	t.Run("Encrypt and decrypt string", func(t *testing.T) {
 		content := "This is the payload"
-		key := "super_secure_testing_key"
+		key := LegacyKey("super_secure_testing_key")
 
 		enrypted, err := EncryptString(content, key)
 		if err != nil {
@@ -38,4 +38,39 @@ func TestEncryptDecrypt(t *testing.T) {
 			t.Fatalf("Expected decrypted text to be %s, got %s", content, decrypted)
 		}
 	})
+
+	t.Run("Encrypt and decrypt file id", func(t *testing.T) {
+		fileId := "pages/Page1.md"
+		key := LegacyKey("super_secure_testing_key")
+
+		encrypted, err := EncryptFileId(fileId, key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		again, _ := EncryptFileId(fileId, key)
+		if encrypted != again {
+			t.Fatalf("Expected encrypted ids to be equal, got %s and %s", encrypted, again)
+		}
+
+		decrypted, legacy, err := DecryptFileId(encrypted, key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if decrypted != fileId || legacy {
+			t.Fatalf("Expected %s, got %s (legacy: %v)", fileId, decrypted, legacy)
+		}
+	})
+
+	t.Run("Decrypt legacy file id", func(t *testing.T) {
+		key := LegacyKey("super_secure_testing_key")
+		encrypted, _ := EncryptString("pages___Page1.md", key)
+
+		decrypted, legacy, err := DecryptFileId(encrypted, key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if decrypted != "pages___Page1.md" || !legacy {
+			t.Fatalf("Expected legacy id, got %s (legacy: %v)", decrypted, legacy)
+		}
+	})
 }
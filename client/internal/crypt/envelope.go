@@ -0,0 +1,179 @@
+package crypt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KDF identifies how the key was derived from the passphrase
+type KDF byte
+
+const (
+	// KDFLegacy is the sha256 of the passphrase of LegacyKey
+	KDFLegacy KDF = 0
+	// KDFArgon2id is the derivation of DeriveKey
+	KDFArgon2id KDF = 1
+	// KDFMembers is a random data key of a shared graph, see WrapKey
+	KDFMembers KDF = 2
+)
+
+// CipherAESGCM is AES-256 in GCM mode, the only cipher so far
+const CipherAESGCM byte = 1
+
+// Compression of the content before it is encrypted
+type Compression byte
+
+const (
+	CompressionNone Compression = 0
+	CompressionGzip Compression = 1
+)
+
+// CompressionByName returns the compression of the sync.compression config
+func CompressionByName(name string) (Compression, error) {
+	switch name {
+	case "none":
+		return CompressionNone, nil
+	case "gzip":
+		return CompressionGzip, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %q, expected gzip or none", name)
+}
+
+const envelopeVersion byte = 1
+
+// envelopeMagic starts every envelope. Content encrypted by older versions starts with the nonce.
+var envelopeMagic = []byte("LSYN")
+
+// header: magic, version, kdf, cipher, compression and the key id
+const keyIdSize = 8
+const headerSize = 4 + 4 + keyIdSize
+
+var ErrUnsupportedEnvelope = errors.New("unsupported envelope")
+
+// Envelope is the place of the content in a graph. It is authenticated with the content,
+// so that the content of a file can't be swapped with the content of another file or graph.
+type Envelope struct {
+	KDF    KDF
+	Graph  string
+	FileId string
+	// Compression is applied before the content is encrypted, if it makes the content smaller
+	Compression Compression
+	// Legacy content without envelope is accepted. A server could swap it with any other legacy content,
+	// so it is only accepted until the graph is sealed again by a rotation.
+	Legacy bool
+}
+
+// Seal encrypts the content of a file. The result starts with a header containing the format version,
+// the kdf, the cipher, the compression and the id of the key, followed by the nonce and the encrypted content.
+func Seal(content []byte, key Key, envelope Envelope) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	compression := CompressionNone
+	if envelope.Compression == CompressionGzip {
+		compressed, err := compress(content)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(content) {
+			content = compressed
+			compression = CompressionGzip
+		}
+	}
+
+	header := make([]byte, 0, headerSize+gcm.NonceSize())
+	header = append(header, envelopeMagic...)
+	header = append(header, envelopeVersion, byte(envelope.KDF), CipherAESGCM, byte(compression))
+	header = append(header, keyId(key)...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := append(header, nonce...)
+	return gcm.Seal(sealed, nonce, content, envelope.associatedData(header)), nil
+}
+
+// Open decrypts content sealed by Seal. Content without envelope was encrypted by older versions
+// and is decrypted like Decrypt, if the envelope accepts legacy content.
+func Open(sealed []byte, key Key, envelope Envelope) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, envelopeMagic) {
+		if !envelope.Legacy {
+			return nil, fmt.Errorf("%w: content without envelope", ErrUnsupportedEnvelope)
+		}
+		return Decrypt(sealed, key)
+	}
+
+	if len(sealed) < headerSize {
+		return nil, errors.New("encrypted value is too short")
+	}
+	version, cipherId, compression := sealed[4], sealed[6], Compression(sealed[7])
+	if version != envelopeVersion || cipherId != CipherAESGCM || compression > CompressionGzip {
+		return nil, fmt.Errorf("%w: version %d, cipher %d, compression %d", ErrUnsupportedEnvelope, version, cipherId, compression)
+	}
+	header := sealed[:headerSize]
+	if !hmac.Equal(header[headerSize-keyIdSize:], keyId(key)) {
+		return nil, ErrWrongKey
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest := sealed[headerSize:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, cipheredText := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	content, err := gcm.Open(nil, nonce, cipheredText, envelope.associatedData(header))
+	if err != nil || compression == CompressionNone {
+		return content, err
+	}
+	return decompress(content)
+}
+
+func compress(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(content)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	return buf.Bytes(), err
+}
+
+func decompress(content []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// associatedData authenticates the header, the graph and the file id with the content
+func (e Envelope) associatedData(header []byte) []byte {
+	data := append([]byte{}, header...)
+	data = binary.AppendUvarint(data, uint64(len(e.Graph)))
+	data = append(data, e.Graph...)
+	data = binary.AppendUvarint(data, uint64(len(e.FileId)))
+	return append(data, e.FileId...)
+}
+
+func keyId(key Key) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("logsync key id"))
+	return mac.Sum(nil)[:keyIdSize]
+}
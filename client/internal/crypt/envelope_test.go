@@ -0,0 +1,94 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := LegacyKey("super_secure_testing_key")
+	envelope := Envelope{KDF: KDFLegacy, Graph: "Personal", FileId: "pages/a.md"}
+	content := []byte("This is the payload")
+
+	sealed, err := Seal(content, key, envelope)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.HasPrefix(sealed, envelopeMagic) || sealed[4] != envelopeVersion || sealed[6] != CipherAESGCM {
+		t.Fatalf("Expected the envelope header, got %x", sealed[:headerSize])
+	}
+
+	opened, err := Open(sealed, key, envelope)
+	if err != nil || !bytes.Equal(opened, content) {
+		t.Fatalf("Expected %s, got %s, %v", content, opened, err)
+	}
+
+	// the content of another file or graph is rejected
+	for _, other := range []Envelope{
+		{KDF: KDFLegacy, Graph: "Personal", FileId: "pages/b.md"},
+		{KDF: KDFLegacy, Graph: "Work", FileId: "pages/a.md"},
+	} {
+		_, err = Open(sealed, key, other)
+		if err == nil {
+			t.Fatalf("Expected the content of %v not to open in %v", envelope, other)
+		}
+	}
+
+	_, err = Open(sealed, LegacyKey("other"), envelope)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("Expected ErrWrongKey, got %v", err)
+	}
+
+	unsupported := bytes.Clone(sealed)
+	unsupported[4] = 3
+	_, err = Open(unsupported, key, envelope)
+	if !errors.Is(err, ErrUnsupportedEnvelope) {
+		t.Fatalf("Expected ErrUnsupportedEnvelope, got %v", err)
+	}
+}
+
+func TestOpenLegacy(t *testing.T) {
+	key := LegacyKey("super_secure_testing_key")
+	content := []byte("This is the payload")
+	encrypted, err := Encrypt(content, key)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	opened, err := Open(encrypted, key, Envelope{Graph: "Personal", FileId: "pages/a.md", Legacy: true})
+	if err != nil || !bytes.Equal(opened, content) {
+		t.Fatalf("Expected content without envelope to open, got %s, %v", opened, err)
+	}
+
+	// a server can't replace sealed content of a rotated graph with legacy content
+	_, err = Open(encrypted, key, Envelope{Graph: "Personal", FileId: "pages/a.md"})
+	if !errors.Is(err, ErrUnsupportedEnvelope) {
+		t.Fatalf("Expected ErrUnsupportedEnvelope, got %v", err)
+	}
+}
+
+func TestSealCompressed(t *testing.T) {
+	key := LegacyKey("super_secure_testing_key")
+	envelope := Envelope{KDF: KDFArgon2id, Graph: "Personal", FileId: "pages/a.md", Compression: CompressionGzip}
+	content := []byte(strings.Repeat("- This is a block\n", 100))
+
+	sealed, err := Seal(content, key, envelope)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if Compression(sealed[7]) != CompressionGzip || len(sealed) >= len(content) {
+		t.Fatalf("Expected the content to be compressed, got %d of %d bytes", len(sealed), len(content))
+	}
+	opened, err := Open(sealed, key, envelope)
+	if err != nil || !bytes.Equal(opened, content) {
+		t.Fatalf("Expected %s, got %s, %v", content, opened, err)
+	}
+
+	// content, that does not get smaller, is stored uncompressed
+	sealed, err = Seal([]byte("a"), key, envelope)
+	if err != nil || Compression(sealed[7]) != CompressionNone {
+		t.Fatalf("Expected short content to be uncompressed, got %v", err)
+	}
+}
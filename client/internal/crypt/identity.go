@@ -0,0 +1,109 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"io"
+	"strings"
+)
+
+// MembersKeyParams are the key params of shared graphs. Their key is a random data key,
+// that is wrapped for the public key of every member.
+const MembersKeyParams = "members"
+
+const identityPrefix = "logsync-identity-1:"
+
+var ErrInvalidIdentity = errors.New("invalid identity")
+
+// Identity is the X25519 key pair of a user. Shared graphs wrap their data key for the public key.
+type Identity struct {
+	publicKey  [32]byte
+	privateKey [32]byte
+}
+
+// NewIdentity creates a random key pair
+func NewIdentity() (Identity, error) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{publicKey: *publicKey, privateKey: *privateKey}, nil
+}
+
+// ParseIdentity reads an identity encoded by Identity.Encode
+func ParseIdentity(encoded string) (Identity, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(encoded), identityPrefix)
+	if !ok {
+		return Identity{}, ErrInvalidIdentity
+	}
+	privateKey, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(privateKey) != 32 {
+		return Identity{}, ErrInvalidIdentity
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidIdentity, err)
+	}
+
+	var identity Identity
+	copy(identity.privateKey[:], privateKey)
+	copy(identity.publicKey[:], publicKey)
+	return identity, nil
+}
+
+// Encode returns the private key, that is stored in the identity file
+func (i Identity) Encode() string {
+	return identityPrefix + base64.RawURLEncoding.EncodeToString(i.privateKey[:])
+}
+
+// PublicKey returns the public key, that is shared with the owners of graphs
+func (i Identity) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(i.publicKey[:])
+}
+
+// NewDataKey returns a random key for a shared graph
+func NewDataKey() (Key, error) {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	return key, err
+}
+
+// WrapKey encrypts the key for the public key, only the owner of the private key can unwrap it
+func WrapKey(key Key, publicKey string) (string, error) {
+	recipient, err := parsePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := box.SealAnonymous(nil, key, recipient, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(wrapped), nil
+}
+
+// UnwrapKey decrypts a key wrapped for the public key of the identity
+func (i Identity) UnwrapKey(wrapped string) (Key, error) {
+	sealed, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	key, ok := box.OpenAnonymous(nil, sealed, &i.publicKey, &i.privateKey)
+	if !ok {
+		return nil, ErrWrongKey
+	}
+	return key, nil
+}
+
+func parsePublicKey(publicKey string) (*[32]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(publicKey)
+	if err != nil || len(decoded) != 32 {
+		return nil, fmt.Errorf("invalid public key %q", publicKey)
+	}
+	var key [32]byte
+	copy(key[:], decoded)
+	return &key, nil
+}
@@ -0,0 +1,41 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWrapKey(t *testing.T) {
+	identity, err := NewIdentity()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	parsed, err := ParseIdentity(identity.Encode() + "\n")
+	if err != nil || parsed.PublicKey() != identity.PublicKey() {
+		t.Fatalf("Expected the identity to be parsed, got %v", err)
+	}
+
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	wrapped, err := WrapKey(key, identity.PublicKey())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	unwrapped, err := parsed.UnwrapKey(wrapped)
+	if err != nil || !bytes.Equal(unwrapped, key) {
+		t.Fatalf("Expected the data key, got %v", err)
+	}
+
+	other, _ := NewIdentity()
+	_, err = other.UnwrapKey(wrapped)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("Expected ErrWrongKey, got %v", err)
+	}
+	_, err = ParseIdentity("not an identity")
+	if !errors.Is(err, ErrInvalidIdentity) {
+		t.Fatalf("Expected ErrInvalidIdentity, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"io"
+)
+
+// Key is the aes key, that encrypts the content and the file ids of a graph
+type Key []byte
+
+var (
+	ErrWrongKey         = errors.New("the encryption key does not match the key of the graph")
+	ErrInvalidKeyParams = errors.New("invalid key derivation parameters")
+)
+
+// keyCheckValue is encrypted with the key of a graph. Clients, that can't decrypt it, use another key.
+const keyCheckValue = "logsync key check"
+
+// LegacyKey is the key of graphs encrypted by older versions, the sha256 of the passphrase without salt
+func LegacyKey(passphrase string) Key {
+	hash := sha256.Sum256([]byte(passphrase))
+	return hash[:]
+}
+
+// KeyParams are the salt and the cost of the argon2id key derivation.
+// They are stored on the server, so that all clients of a graph derive the same key.
+type KeyParams struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory in KiB
+	Memory  uint32
+	Threads uint8
+	Salt    []byte
+}
+
+// NewKeyParams returns the parameters for a new graph with a random salt
+func NewKeyParams() (KeyParams, error) {
+	salt := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return KeyParams{}, err
+	}
+	return KeyParams{Time: 3, Memory: 64 * 1024, Threads: 4, Salt: salt}, nil
+}
+
+// String encodes the parameters like the hashes of the reference implementation, without the hash
+func (p KeyParams) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, base64.RawStdEncoding.EncodeToString(p.Salt))
+}
+
+func ParseKeyParams(encoded string) (KeyParams, error) {
+	var version int
+	var params KeyParams
+	var salt string
+	_, err := fmt.Sscanf(encoded, "$argon2id$v=%d$m=%d,t=%d,p=%d$%s",
+		&version, &params.Memory, &params.Time, &params.Threads, &salt)
+	if err != nil {
+		return KeyParams{}, fmt.Errorf("%w: %v", ErrInvalidKeyParams, err)
+	}
+	if version != argon2.Version {
+		return KeyParams{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidKeyParams, version)
+	}
+	if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+		return KeyParams{}, fmt.Errorf("%w: %s", ErrInvalidKeyParams, encoded)
+	}
+	params.Salt, err = base64.RawStdEncoding.DecodeString(salt)
+	if err != nil || len(params.Salt) == 0 {
+		return KeyParams{}, fmt.Errorf("%w: invalid salt", ErrInvalidKeyParams)
+	}
+	return params, nil
+}
+
+// DeriveKey derives the key of a graph from the passphrase with argon2id
+func DeriveKey(passphrase string, params KeyParams) Key {
+	return argon2.IDKey([]byte(passphrase), params.Salt, params.Time, params.Memory, params.Threads, 32)
+}
+
+// NewKeyCheck returns the value, that lets other clients verify their key with VerifyKeyCheck
+func NewKeyCheck(key Key) (string, error) {
+	return EncryptString(keyCheckValue, key)
+}
+
+// VerifyKeyCheck returns ErrWrongKey, if the key check was created with another key
+func VerifyKeyCheck(key Key, check string) error {
+	value, err := DecryptString(check, key)
+	if err != nil || value != keyCheckValue {
+		return ErrWrongKey
+	}
+	return nil
+}
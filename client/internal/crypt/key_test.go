@@ -0,0 +1,58 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeyParams(t *testing.T) {
+	params, err := NewKeyParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseKeyParams(params.String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if parsed.Time != params.Time || parsed.Memory != params.Memory || parsed.Threads != params.Threads ||
+		!bytes.Equal(parsed.Salt, params.Salt) {
+		t.Fatalf("Expected %v, got %v", params, parsed)
+	}
+
+	for _, invalid := range []string{"", "$argon2i$v=19$m=8,t=1,p=1$c2FsdA", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA", "$argon2id$v=19$m=8,t=1,p=1$"} {
+		_, err = ParseKeyParams(invalid)
+		if !errors.Is(err, ErrInvalidKeyParams) {
+			t.Fatalf("Expected %q to be invalid, got %v", invalid, err)
+		}
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	params := KeyParams{Time: 1, Memory: 1024, Threads: 1, Salt: []byte("salt of a graph")}
+	key := DeriveKey("passphrase", params)
+	if len(key) != 32 || !bytes.Equal(key, DeriveKey("passphrase", params)) {
+		t.Fatal("Expected the same 32 byte key for the same passphrase and salt")
+	}
+
+	params.Salt = []byte("salt of another graph")
+	if bytes.Equal(key, DeriveKey("passphrase", params)) {
+		t.Fatal("Expected another key for another salt")
+	}
+}
+
+func TestKeyCheck(t *testing.T) {
+	key := LegacyKey("passphrase")
+	check, err := NewKeyCheck(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = VerifyKeyCheck(key, check); err != nil {
+		t.Fatalf("Expected the key to match, got %v", err)
+	}
+	if err = VerifyKeyCheck(LegacyKey("another passphrase"), check); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("Expected ErrWrongKey, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around a change
+const context = 3
+
+// maxCells limits the size of the table used to find the longest common subsequence
+const maxCells = 16 << 20
+
+type opKind byte
+
+const (
+	equal  opKind = ' '
+	remove opKind = '-'
+	insert opKind = '+'
+)
+
+type op struct {
+	kind opKind
+	line string
+}
+
+// Unified returns the changes from old to new in the unified diff format.
+// The result is empty, if both contents are equal.
+func Unified(oldName, newName string, old, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	if isBinary(old) || isBinary(new) {
+		out.WriteString("Binary files differ\n")
+		return out.String()
+	}
+
+	ops, ok := lineOps(splitLines(old), splitLines(new))
+	if !ok {
+		out.WriteString("Files are too large to compare\n")
+		return out.String()
+	}
+	writeHunks(&out, ops)
+	return out.String()
+}
+
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.Split(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func lineOps(a, b []string) ([]op, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	n, m := len(midA), len(midB)
+	if (n+1)*(m+1) > maxCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max32(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{equal, line})
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && midA[i] == midB[j]:
+			ops = append(ops, op{equal, midA[i]})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{remove, midA[i]})
+			i++
+		default:
+			ops = append(ops, op{insert, midB[j]})
+			j++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{equal, line})
+	}
+	return ops, true
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func writeHunks(out *strings.Builder, ops []op) {
+	for start := 0; start < len(ops); {
+		first := nextChange(ops, start)
+		if first < 0 {
+			return
+		}
+
+		// extend the hunk, while the next change is close enough to share the context
+		last := first
+		for {
+			next := nextChange(ops, last+1)
+			if next < 0 || next-last > 2*context {
+				break
+			}
+			last = next
+		}
+
+		from := first - context
+		if from < start {
+			from = start
+		}
+		if from < 0 {
+			from = 0
+		}
+		to := last + context + 1
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		writeHunk(out, ops, from, to)
+		start = to
+	}
+}
+
+func nextChange(ops []op, from int) int {
+	for i := from; i < len(ops); i++ {
+		if ops[i].kind != equal {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeHunk(out *strings.Builder, ops []op, from, to int) {
+	// line numbers of the hunk start in both files
+	lineA, lineB := 1, 1
+	for _, o := range ops[:from] {
+		if o.kind != insert {
+			lineA++
+		}
+		if o.kind != remove {
+			lineB++
+		}
+	}
+
+	countA, countB := 0, 0
+	for _, o := range ops[from:to] {
+		if o.kind != insert {
+			countA++
+		}
+		if o.kind != remove {
+			countB++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(lineA, countA), hunkRange(lineB, countB))
+	for _, o := range ops[from:to] {
+		out.WriteByte(byte(o.kind))
+		out.WriteString(o.line)
+		out.WriteByte('\n')
+	}
+}
+
+// hunkRange empty ranges start at the line before the hunk
+func hunkRange(line, count int) string {
+	if count == 0 {
+		line--
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
@@ -0,0 +1,130 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrOutsideGraph = errors.New("path is outside of the graph")
+
+// confine makes sure, that p stays inside the graph directory, also when a parent directory of p is a symlink.
+func confine(graphPath, p string, symlinks SymlinkPolicy) error {
+	graphPath = filepath.Clean(graphPath)
+	if !within(graphPath, p) {
+		return fmt.Errorf("%w: %s", ErrOutsideGraph, p)
+	}
+	if symlinks == FollowSymlinks {
+		return confineFollowed(graphPath, p)
+	}
+
+	root, err := filepath.EvalSymlinks(graphPath)
+	if err != nil {
+		return err
+	}
+
+	// the deepest existing parent decides, where new directories and files end up
+	dir := filepath.Dir(p)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if !within(root, resolved) {
+				return fmt.Errorf("%w: %s resolves to %s", ErrOutsideGraph, dir, resolved)
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if !within(graphPath, dir) || dir == graphPath {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// confineFollowed resolves the elements of p like the walk, which doesn't follow broken symlinks and loops.
+func confineFollowed(graphPath, p string) error {
+	root, err := os.Stat(graphPath)
+	if err != nil {
+		return err
+	}
+	ancestors := []os.FileInfo{root}
+
+	rel, err := filepath.Rel(graphPath, p)
+	if err != nil {
+		return err
+	}
+	elems := strings.Split(rel, string(filepath.Separator))
+	current := graphPath
+	for i, elem := range elems {
+		current = filepath.Join(current, elem)
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(current)
+			if err != nil && i == len(elems)-1 {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %s is a broken symlink", ErrOutsideGraph, current)
+			}
+			if isLoop(ancestors, target) {
+				return fmt.Errorf("%w: %s points to one of its parent directories", ErrOutsideGraph, current)
+			}
+			info = target
+		}
+		if info.IsDir() {
+			ancestors = append(ancestors, info)
+		}
+	}
+	return nil
+}
+
+// replaceSymlink removes a symlink at p, writing to p would follow it otherwise.
+func replaceSymlink(p string, symlinks SymlinkPolicy, isDir bool) error {
+	info, err := os.Lstat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	if symlinks == FollowSymlinks {
+		target, err := os.Stat(p)
+		if err == nil && target.IsDir() == isDir {
+			return nil
+		}
+	}
+	return os.Remove(p)
+}
+
+func confineTarget(graphPath, p, target string) error {
+	resolved := target
+	if !filepath.IsAbs(target) {
+		resolved = filepath.Join(filepath.Dir(p), target)
+	}
+	if !within(graphPath, resolved) {
+		return fmt.Errorf("%w: symlink %s points to %s", ErrOutsideGraph, p, target)
+	}
+	return nil
+}
+
+func within(base, p string) bool {
+	rel, err := filepath.Rel(base, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var ErrInvalidFileId = errors.New("invalid file id")
+
+// legacySeparator was used by older versions to join the path segments of a file id.
+// It is ambiguous, because logseq uses it for namespaced pages as well.
+const legacySeparator = "___"
+
+// EncodeFileId builds the id of a file by its slash separated path relative to the graph root.
+// Every path segment is escaped, therefore the id can be decoded unambiguously.
+func EncodeFileId(relPath string) string {
+	parts := strings.Split(relPath, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// DecodeFileId returns the slash separated path relative to the graph root.
+// Ids that are not in the canonical encoding or that would escape the graph root are rejected.
+func DecodeFileId(fileId string) (string, error) {
+	if fileId == "" {
+		return "", fmt.Errorf("%w: id is empty", ErrInvalidFileId)
+	}
+
+	parts := strings.Split(fileId, "/")
+	for i, part := range parts {
+		decoded, err := url.PathUnescape(part)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrInvalidFileId, fileId, err)
+		}
+		if decoded == "" || decoded == "." || decoded == ".." {
+			return "", fmt.Errorf("%w: %s contains an empty or relative segment", ErrInvalidFileId, fileId)
+		}
+		if strings.ContainsAny(decoded, "/\\\x00") {
+			return "", fmt.Errorf("%w: %s contains a separator in a segment", ErrInvalidFileId, fileId)
+		}
+		parts[i] = decoded
+	}
+
+	relPath := path.Join(parts...)
+	if EncodeFileId(relPath) != fileId {
+		return "", fmt.Errorf("%w: %s is not canonical", ErrInvalidFileId, fileId)
+	}
+
+	return relPath, nil
+}
+
+// LegacyFileId converts an id of older versions, joined by "___", to the current encoding.
+// Older clients restored those ids by splitting at every separator, so the conversion does the same.
+func LegacyFileId(fileId string) string {
+	return EncodeFileId(strings.Join(strings.Split(fileId, legacySeparator), "/"))
+}
+
+func buildFileId(baseId, name string) string {
+	if len(baseId) == 0 {
+		return EncodeFileId(name)
+	}
+
+	return baseId + "/" + EncodeFileId(name)
+}
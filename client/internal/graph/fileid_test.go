@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncodeFileId(t *testing.T) {
+	tt := []struct {
+		path     string
+		expected string
+	}{
+		{path: "pages/Page1.md", expected: "pages/Page1.md"},
+		{path: "pages/a___b.md", expected: "pages/a___b.md"},
+		{path: "pages/My Page.md", expected: "pages/My%20Page.md"},
+		{path: "pages/100%.md", expected: "pages/100%25.md"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.path, func(t *testing.T) {
+			id := EncodeFileId(tc.path)
+			if id != tc.expected {
+				t.Fatalf("Expected %s, got %s", tc.expected, id)
+			}
+
+			decoded, err := DecodeFileId(id)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if decoded != tc.path {
+				t.Fatalf("Expected %s, got %s", tc.path, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeFileIdInvalid(t *testing.T) {
+	ids := []string{
+		"",
+		"../secret.md",
+		"pages/../../secret.md",
+		"/etc/passwd",
+		"pages//Page1.md",
+		"pages/%2E%2E/secret.md",
+		"pages%2F..%2F..%2Fsecret.md",
+		"pages/%41.md",
+		"pages/a\\..\\b.md",
+	}
+
+	for _, id := range ids {
+		t.Run(id, func(t *testing.T) {
+			_, err := DecodeFileId(id)
+			if !errors.Is(err, ErrInvalidFileId) {
+				t.Fatalf("Expected ErrInvalidFileId, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLegacyFileId(t *testing.T) {
+	id := LegacyFileId("journals___2024_03_02.md")
+	if id != "journals/2024_03_02.md" {
+		t.Fatalf("Expected journals/2024_03_02.md, got %s", id)
+	}
+}
@@ -4,26 +4,118 @@ import (
 	"errors"
 	"os"
 	"path"
-	"strings"
+	"time"
 )
 
-func StoreFile(graphPath, fileId string, content []byte) (string, error) {
-	p := getPathByFileId(fileId)
-	p = path.Join(graphPath, p)
+const (
+	defaultFileMode os.FileMode = 0644
+	defaultDirMode  os.FileMode = 0755
+)
 
-	err := ensureDirExists(p)
+// StoreFile writes the content of the file and applies the given mode and modification time.
+// The returned file reflects the state on the disk after storing.
+// With FollowSymlinks, the file may be written through a symlinked directory, like the walk reads it.
+func StoreFile(graphPath, fileId string, content []byte, mode os.FileMode, modTime time.Time, symlinks SymlinkPolicy) (File, error) {
+	p, err := resolvePath(graphPath, fileId, symlinks)
 	if err != nil {
-		return "", err
+		return File{}, err
+	}
+
+	err = ensureDirExists(p)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = replaceSymlink(p, symlinks, false)
+	if err != nil {
+		return File{}, err
 	}
 
 	file, err := os.Create(p)
 	if err != nil {
-		return "", err
+		return File{}, err
 	}
-	defer file.Close()
 
 	_, err = file.Write(content)
-	return p, err
+	closeErr := file.Close()
+	if err != nil {
+		return File{}, err
+	}
+	if closeErr != nil {
+		return File{}, closeErr
+	}
+
+	return applyMetadata(p, fileId, KindFile, orDefault(mode, defaultFileMode), modTime)
+}
+
+// StoreDir creates the directory, if it does not exist yet. Writing the children of the directory changes its
+// modification time, so the mode and modification time are applied by StoreDirMetadata afterwards.
+func StoreDir(graphPath, fileId string, symlinks SymlinkPolicy) (File, error) {
+	p, err := resolvePath(graphPath, fileId, symlinks)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = replaceSymlink(p, symlinks, true)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = os.MkdirAll(p, os.ModePerm)
+	if err != nil {
+		return File{}, err
+	}
+
+	return statFile(p, fileId, KindDir)
+}
+
+// StoreDirMetadata applies the given mode and modification time to the directory,
+// after its children were written. Subdirectories have to be updated before their parents.
+func StoreDirMetadata(graphPath, fileId string, mode os.FileMode, modTime time.Time, symlinks SymlinkPolicy) (File, error) {
+	p, err := resolvePath(graphPath, fileId, symlinks)
+	if err != nil {
+		return File{}, err
+	}
+
+	return applyMetadata(p, fileId, KindDir, orDefault(mode, defaultDirMode), modTime)
+}
+
+func applyMetadata(p, fileId string, kind Kind, mode os.FileMode, modTime time.Time) (File, error) {
+	err := os.Chmod(p, mode.Perm())
+	if err != nil {
+		return File{}, err
+	}
+
+	if !modTime.IsZero() {
+		err = os.Chtimes(p, modTime, modTime)
+		if err != nil {
+			return File{}, err
+		}
+	}
+
+	return statFile(p, fileId, kind)
+}
+
+func statFile(p, fileId string, kind Kind) (File, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Id:         fileId,
+		Path:       p,
+		Kind:       kind,
+		Mode:       info.Mode().Perm(),
+		LastChange: info.ModTime(),
+	}, nil
+}
+
+func orDefault(mode os.FileMode, defaultMode os.FileMode) os.FileMode {
+	if mode.Perm() == 0 {
+		return defaultMode
+	}
+	return mode
 }
 
 func ensureDirExists(p string) error {
@@ -37,14 +129,27 @@ func ensureDirExists(p string) error {
 }
 
 // RemoveFile TODO: maybe introduce some kind of trash bin
-func RemoveFile(graphPath, fileId string) error {
-	p := getPathByFileId(fileId)
-	p = path.Join(graphPath, p)
+// Directories are only removed if they are empty.
+func RemoveFile(graphPath, fileId string, symlinks SymlinkPolicy) error {
+	p, err := resolvePath(graphPath, fileId, symlinks)
+	if err != nil {
+		return err
+	}
 
 	return os.Remove(p)
 }
 
-func getPathByFileId(fileId string) string {
-	parts := strings.Split(fileId, Separator)
-	return path.Join(parts...)
+func resolvePath(graphPath, fileId string, symlinks SymlinkPolicy) (string, error) {
+	relPath, err := DecodeFileId(fileId)
+	if err != nil {
+		return "", err
+	}
+
+	p := path.Join(graphPath, relPath)
+	err = confine(graphPath, p, symlinks)
+	if err != nil {
+		return "", err
+	}
+
+	return p, nil
 }
@@ -1,13 +1,16 @@
 package graph
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestStoreFile(t *testing.T) {
 	t.Run("dir already exists", func(t *testing.T) {
-		err := StoreFile("testdata/graph", "journals___stored.md", []byte{1, 2, 3})
+		_, err := StoreFile("testdata/graph", "journals/stored.md", []byte{1, 2, 3}, 0, time.Time{}, SkipSymlinks)
 
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
@@ -22,7 +25,7 @@ func TestStoreFile(t *testing.T) {
 	})
 
 	t.Run("missing dir", func(t *testing.T) {
-		err := StoreFile("testdata/graph", "something___stored.md", []byte{1, 2, 3})
+		_, err := StoreFile("testdata/graph", "something/stored.md", []byte{1, 2, 3}, 0, time.Time{}, SkipSymlinks)
 
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
@@ -36,4 +39,138 @@ func TestStoreFile(t *testing.T) {
 		_ = os.Remove("testdata/graph/something/stored.md")
 		_ = os.Remove("testdata/graph/something")
 	})
+
+	t.Run("applies mode and modification time", func(t *testing.T) {
+		dir := t.TempDir()
+		modTime := time.UnixMilli(1710786299418)
+
+		stored, err := StoreFile(dir, "pages/stored.md", []byte{1, 2, 3}, 0600, modTime, SkipSymlinks)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		info, err := os.Stat(dir + "/pages/stored.md")
+		if err != nil {
+			t.Fatalf("File should exist, expected no error, got %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Fatalf("Expected mode 0600, got %o", info.Mode().Perm())
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Fatalf("Expected modification time %v, got %v", modTime, info.ModTime())
+		}
+		if !stored.LastChange.Equal(modTime) {
+			t.Fatalf("Expected last change %v, got %v", modTime, stored.LastChange)
+		}
+	})
+}
+
+func TestStoreDir(t *testing.T) {
+	dir := t.TempDir()
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, err := StoreDir(dir, "assets", SkipSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = StoreFile(dir, "assets/image.png", []byte{1}, 0, time.Time{}, SkipSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stored, err := StoreDirMetadata(dir, "assets", 0750, modTime, SkipSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	info, err := os.Stat(dir + "/assets")
+	if err != nil {
+		t.Fatalf("Dir should exist, expected no error, got %v", err)
+	}
+	if !info.IsDir() || !stored.IsDir() {
+		t.Fatalf("Expected a directory")
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Fatalf("Expected mode 0750, got %o", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modTime) || !stored.LastChange.Equal(modTime) {
+		t.Fatalf("Expected the modification time %v, got %v", modTime, info.ModTime())
+	}
+}
+
+func TestStoreFileOutsideGraph(t *testing.T) {
+	root := t.TempDir()
+	graphPath := filepath.Join(root, "graph")
+	outside := filepath.Join(root, "outside")
+	_ = os.MkdirAll(filepath.Join(graphPath, "pages"), os.ModePerm)
+	_ = os.MkdirAll(outside, os.ModePerm)
+	_ = os.Symlink(outside, filepath.Join(graphPath, "pages", "link"))
+
+	t.Run("relative id", func(t *testing.T) {
+		_, err := StoreFile(graphPath, "../outside/file.md", []byte{1}, 0, time.Time{}, SkipSymlinks)
+		if !errors.Is(err, ErrInvalidFileId) {
+			t.Fatalf("Expected ErrInvalidFileId, got %v", err)
+		}
+	})
+
+	t.Run("through symlinked directory", func(t *testing.T) {
+		_, err := StoreFile(graphPath, "pages/link/file.md", []byte{1}, 0, time.Time{}, SkipSymlinks)
+		if !errors.Is(err, ErrOutsideGraph) {
+			t.Fatalf("Expected ErrOutsideGraph, got %v", err)
+		}
+	})
+
+	t.Run("symlink target", func(t *testing.T) {
+		_, err := StoreSymlink(graphPath, "pages/escape", "../../outside")
+		if !errors.Is(err, ErrOutsideGraph) {
+			t.Fatalf("Expected ErrOutsideGraph, got %v", err)
+		}
+	})
+
+	t.Run("replaces symlinked file", func(t *testing.T) {
+		target := filepath.Join(outside, "target.md")
+		_ = os.WriteFile(target, []byte("original"), 0644)
+		_ = os.Symlink(target, filepath.Join(graphPath, "pages", "file.md"))
+
+		_, err := StoreFile(graphPath, "pages/file.md", []byte("replaced"), 0, time.Time{}, SkipSymlinks)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		content, _ := os.ReadFile(target)
+		if string(content) != "original" {
+			t.Fatalf("Expected target outside of the graph to be unchanged, got %s", content)
+		}
+	})
+
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 {
+		t.Fatalf("Expected only target.md outside of the graph, got %d entries", len(entries))
+	}
+}
+
+func FuzzStoreFile(f *testing.F) {
+	f.Add("pages/Page1.md")
+	f.Add("../outside.md")
+	f.Add("pages/../../outside.md")
+	f.Add("pages%2F..%2F..%2Foutside.md")
+	f.Add("/etc/passwd")
+	f.Add("pages/%2E%2E/%2E%2E/outside.md")
+
+	f.Fuzz(func(t *testing.T, fileId string) {
+		root := t.TempDir()
+		graphPath := filepath.Join(root, "graph")
+		_ = os.Mkdir(graphPath, os.ModePerm)
+
+		stored, err := StoreFile(graphPath, fileId, []byte("content"), 0, time.Time{}, SkipSymlinks)
+		if err == nil && !within(graphPath, stored.Path) {
+			t.Fatalf("Stored %s outside of the graph for id %q", stored.Path, fileId)
+		}
+
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("Created files outside of the graph for id %q", fileId)
+		}
+	})
 }
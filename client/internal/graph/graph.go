@@ -2,6 +2,7 @@ package graph
 
 import (
 	"errors"
+	"github.com/soerenchrist/logsync/client/internal/log"
 	"github.com/soerenchrist/logsync/client/internal/sanitize"
 	"os"
 	"path"
@@ -10,47 +11,102 @@ import (
 	"time"
 )
 
-var Separator = "___"
-
 var skipFolders = []string{"bak", ".recycle"}
 
+// CurrentVersion of the saved graph format.
+// Version 0 used "___" separated file ids.
+const CurrentVersion = 1
+
 type Graph struct {
+	Version  int       `json:"version"`
 	Name     string    `json:"name"`
 	LastSync time.Time `json:"lastSync"`
-	Files    []File    `json:"files"`
+	// LastRevision is the revision of the server, that the graph was synced to.
+	// Graphs synced with older servers have none, their changes are fetched by LastSync.
+	LastRevision int64 `json:"lastRevision,omitempty"`
+	// KeyEpoch is the number of rotations of the key of an encrypted graph, that the graph was synced with
+	KeyEpoch int64 `json:"keyEpoch,omitempty"`
+	// LegacyIdsChecked the file ids of the encrypted graph on the server were checked for ids of older versions,
+	// that were encrypted with random nonces
+	LegacyIdsChecked bool   `json:"legacyIdsChecked,omitempty"`
+	Files            []File `json:"files"`
 }
 
 func New(name string) Graph {
 	return Graph{
+		Version:  CurrentVersion,
 		Name:     name,
 		LastSync: time.Time{},
 		Files:    make([]File, 0),
 	}
 }
 
+type Kind string
+
+const (
+	KindFile    Kind = "file"
+	KindDir     Kind = "dir"
+	KindSymlink Kind = "symlink"
+)
+
 type File struct {
-	Id         string    `json:"id"`
-	Path       string    `json:"path"`
+	Id   string      `json:"id"`
+	Path string      `json:"path"`
+	Kind Kind        `json:"kind,omitempty"`
+	Mode os.FileMode `json:"mode,omitempty"`
+	// Target of a symlink
+	Target     string    `json:"target,omitempty"`
 	LastChange time.Time `json:"lastChange"`
+	// Revision of the server, that the file was synced with. Files synced with older servers have none.
+	Revision int64 `json:"revision,omitempty"`
 }
 
-func ReadGraph(baseDir string) (Graph, error) {
-	files := make([]File, 0)
-	errs := make([]error, 0)
-	traverseGraph(baseDir, "", &files, &errs)
+// IsDir graphs saved by older versions have no kind, those entries are always files
+func (f File) IsDir() bool {
+	return f.Kind == KindDir
+}
+
+func (f File) IsSymlink() bool {
+	return f.Kind == KindSymlink
+}
+
+type ReadOptions struct {
+	Profile  Profile
+	Symlinks SymlinkPolicy
+}
+
+// ReadGraph reads all files of the graph in baseDir, that are included by the profile
+func ReadGraph(baseDir string, options ReadOptions) (Graph, error) {
+	err := options.Profile.Validate(baseDir)
+	if err != nil {
+		return Graph{}, err
+	}
+
+	root, err := os.Stat(baseDir)
+	if err != nil {
+		return Graph{}, err
+	}
+
+	w := walker{
+		options:   options,
+		files:     make([]File, 0),
+		ancestors: []os.FileInfo{root},
+	}
+	w.walk(baseDir, "", "")
 
 	graphName, err := getGraphName(baseDir)
 	if err != nil {
 		return Graph{}, err
 	}
 
-	if len(errs) > 0 {
-		return Graph{}, errors.Join(errs...)
+	if len(w.errs) > 0 {
+		return Graph{}, errors.Join(w.errs...)
 	}
 
 	return Graph{
-		Files: files,
-		Name:  graphName,
+		Version: CurrentVersion,
+		Files:   w.files,
+		Name:    graphName,
 	}, nil
 }
 
@@ -66,7 +122,7 @@ func (g *Graph) AddOrUpdateFile(file File) {
 }
 
 func (g *Graph) RemoveFile(fileId string) {
-	slices.DeleteFunc(g.Files, func(file File) bool {
+	g.Files = slices.DeleteFunc(g.Files, func(file File) bool {
 		return file.Id == fileId
 	})
 }
@@ -86,50 +142,113 @@ func getGraphName(baseDir string) (string, error) {
 	return parts[len(parts)-1], nil
 }
 
-func traverseGraph(baseDir string, name string, files *[]File, errors *[]error) {
+type walker struct {
+	options ReadOptions
+	files   []File
+	errs    []error
+	// directories on the current path, used to detect symlink loops
+	ancestors []os.FileInfo
+}
+
+func (w *walker) walk(baseDir string, relDir string, name string) {
 	entries, err := os.ReadDir(baseDir)
 	if err != nil {
-		*errors = append(*errors, err)
+		w.errs = append(w.errs, err)
 		return
 	}
 
 	for _, entry := range entries {
 		fileId := buildFileId(name, entry.Name())
 		filePath := path.Join(baseDir, entry.Name())
+		relPath := path.Join(relDir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			w.errs = append(w.errs, err)
+			return
+		}
+
+		file := File{
+			Id:         fileId,
+			Path:       sanitize.Path(filePath),
+			Kind:       KindFile,
+			Mode:       info.Mode().Perm(),
+			LastChange: info.ModTime(),
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			w.symlink(file, relPath)
+			continue
+		}
+
+		if !w.options.Profile.Includes(relPath, entry.IsDir()) {
+			continue
+		}
 		if entry.IsDir() {
-			if slices.Contains(skipFolders, entry.Name()) {
-				continue
-			}
-			traverseGraph(filePath, fileId, files, errors)
+			w.dir(file, info, relPath)
 		} else {
-			info, err := entry.Info()
-			if err != nil {
-				*errors = append(*errors, err)
-				return
-			}
-			file := File{
-				Id:         fileId,
-				Path:       sanitize.Path(filePath),
-				LastChange: info.ModTime(),
-			}
-			*files = append(*files, file)
+			w.files = append(w.files, file)
 		}
 	}
 }
 
-func buildFileId(baseName, name string) string {
-	if len(baseName) == 0 {
-		return name
-	}
+func (w *walker) dir(file File, info os.FileInfo, relPath string) {
+	file.Kind = KindDir
+	w.files = append(w.files, file)
 
-	return baseName + Separator + name
+	w.ancestors = append(w.ancestors, info)
+	w.walk(file.Path, relPath, file.Id)
+	w.ancestors = w.ancestors[:len(w.ancestors)-1]
 }
 
+func (w *walker) symlink(file File, relPath string) {
+	switch w.options.Symlinks {
+	case LinkSymlinks:
+		if !w.options.Profile.Includes(relPath, false) {
+			return
+		}
+		target, err := os.Readlink(file.Path)
+		if err != nil {
+			w.errs = append(w.errs, err)
+			return
+		}
+		file.Kind = KindSymlink
+		file.Mode = 0
+		file.Target = target
+		w.files = append(w.files, file)
+	case FollowSymlinks:
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			log.Info("Skipping broken symlink %s", file.Path)
+			return
+		}
+		if !w.options.Profile.Includes(relPath, info.IsDir()) {
+			return
+		}
+		file.Mode = info.Mode().Perm()
+		file.LastChange = info.ModTime()
+		if !info.IsDir() {
+			w.files = append(w.files, file)
+			return
+		}
+		if isLoop(w.ancestors, info) {
+			log.Info("Skipping symlink %s, it points to one of its parent directories", file.Path)
+			return
+		}
+		w.dir(file, info, relPath)
+	}
+}
+
+// GetNameByPath returns the name of the graph, which is the name of the directory.
+// It has to be a valid graph name for the server.
 func GetNameByPath(graphPath string) (string, error) {
 	stat, err := os.Stat(graphPath)
 	if err != nil {
 		return "", err
 	}
 
+	err = sanitize.GraphName(stat.Name())
+	if err != nil {
+		return "", err
+	}
+
 	return stat.Name(), nil
 }
@@ -8,7 +8,7 @@ import (
 
 func TestReadGraph(t *testing.T) {
 	t.Run("graph exists", func(t *testing.T) {
-		graph, err := ReadGraph("testdata/graph")
+		graph, err := ReadGraph("testdata/graph", ReadOptions{Profile: Logseq})
 		if err != nil {
 			t.Fatalf("Should not fail with err: %v", err)
 		}
@@ -17,8 +17,8 @@ func TestReadGraph(t *testing.T) {
 			t.Fatalf("Expected Name %s, got %s", "graph", graph.Name)
 		}
 
-		if len(graph.Files) != 4 {
-			t.Fatalf("Should have length 4, has %d", len(graph.Files))
+		if len(graph.Files) != 9 {
+			t.Fatalf("Should have length 9, has %d", len(graph.Files))
 		}
 
 		tt := []struct {
@@ -26,19 +26,39 @@ func TestReadGraph(t *testing.T) {
 			path string
 		}{
 			{
-				id:   "journals|2024_03_02.md",
+				id:   "journals",
+				path: "testdata/graph/journals",
+			},
+			{
+				id:   "journals/2024_03_02.md",
 				path: "testdata/graph/journals/2024_03_02.md",
 			},
 			{
-				id:   "journals|2024_03_03.md",
+				id:   "journals/2024_03_03.md",
 				path: "testdata/graph/journals/2024_03_03.md",
 			},
 			{
-				id:   "pages|Page1.md",
+				id:   "logseq",
+				path: "testdata/graph/logseq",
+			},
+			{
+				id:   "logseq/config.edn",
+				path: "testdata/graph/logseq/config.edn",
+			},
+			{
+				id:   "logseq/custom.css",
+				path: "testdata/graph/logseq/custom.css",
+			},
+			{
+				id:   "pages",
+				path: "testdata/graph/pages",
+			},
+			{
+				id:   "pages/Page1.md",
 				path: "testdata/graph/pages/Page1.md",
 			},
 			{
-				id:   "pages|Page2.md",
+				id:   "pages/Page2.md",
 				path: "testdata/graph/pages/Page2.md",
 			},
 		}
@@ -56,7 +76,7 @@ func TestReadGraph(t *testing.T) {
 	})
 
 	t.Run("dir does not exist", func(t *testing.T) {
-		_, err := ReadGraph("testdata/doesNotExist")
+		_, err := ReadGraph("testdata/doesNotExist", ReadOptions{Profile: Logseq})
 		if err == nil {
 			t.Fatalf("expected error, got nil")
 		}
@@ -0,0 +1,124 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+var ErrNotAGraph = errors.New("directory is not a logseq graph")
+
+// Profile knows the layout of a graph directory and decides which
+// paths are user content that should be synced and which are device-local state.
+type Profile interface {
+	Name() string
+	// Validate checks if baseDir can be synced with this profile
+	Validate(baseDir string) error
+	// Includes reports if the slash separated path relative to the graph root should be synced
+	Includes(relPath string, isDir bool) bool
+}
+
+func ProfileByName(name string) (Profile, error) {
+	switch name {
+	case "", Logseq.Name():
+		return Logseq, nil
+	case Plain.Name():
+		return Plain, nil
+	default:
+		return nil, fmt.Errorf("unknown graph profile %s", name)
+	}
+}
+
+// Plain syncs every file of the directory, except the logseq backup folders
+var Plain Profile = plainProfile{}
+
+type plainProfile struct{}
+
+func (p plainProfile) Name() string {
+	return "plain"
+}
+
+func (p plainProfile) Validate(baseDir string) error {
+	stat, err := os.Stat(baseDir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", baseDir)
+	}
+	return nil
+}
+
+func (p plainProfile) Includes(relPath string, isDir bool) bool {
+	return !isDir || !slices.Contains(skipFolders, path.Base(relPath))
+}
+
+// Logseq only syncs the user content of a logseq graph
+var Logseq Profile = logseqProfile{}
+
+type logseqProfile struct{}
+
+const configFile = "logseq/config.edn"
+
+// files inside the logseq folder, that are user content. Everything else
+// in there (bak, .recycle, version-files, metadata) is local to the device
+var logseqContentFiles = []string{"config.edn", "custom.css", "custom.js", "export.css"}
+
+func (p logseqProfile) Name() string {
+	return "logseq"
+}
+
+// Validate accepts directories containing a logseq/config.edn and
+// empty directories, which will be populated by the first sync
+func (p logseqProfile) Validate(baseDir string) error {
+	err := Plain.Validate(baseDir)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(path.Join(baseDir, configFile))
+	if err == nil {
+		if stat.IsDir() {
+			return fmt.Errorf("%w: %s is a directory", ErrNotAGraph, configFile)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%w: %s is missing in %s", ErrNotAGraph, configFile, baseDir)
+	}
+	return nil
+}
+
+func (p logseqProfile) Includes(relPath string, isDir bool) bool {
+	parts := strings.Split(relPath, "/")
+	for _, part := range parts {
+		// .git, .recycle, .DS_Store and friends
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+
+	if parts[0] != "logseq" {
+		return true
+	}
+
+	switch len(parts) {
+	case 1:
+		return isDir
+	case 2:
+		return !isDir && slices.Contains(logseqContentFiles, parts[1])
+	default:
+		return false
+	}
+}
@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLogseqIncludes(t *testing.T) {
+	tt := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{path: "pages", isDir: true, expected: true},
+		{path: "pages/Page1.md", expected: true},
+		{path: "journals/2024_03_02.md", expected: true},
+		{path: "assets/image.png", expected: true},
+		{path: "whiteboards", isDir: true, expected: true},
+		{path: "logseq", isDir: true, expected: true},
+		{path: "logseq/config.edn", expected: true},
+		{path: "logseq/custom.css", expected: true},
+		{path: "logseq/bak", isDir: true, expected: false},
+		{path: "logseq/.recycle", isDir: true, expected: false},
+		{path: "logseq/version-files", isDir: true, expected: false},
+		{path: "logseq/pages-metadata.edn", expected: false},
+		{path: ".git", isDir: true, expected: false},
+		{path: "pages/.DS_Store", expected: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.path, func(t *testing.T) {
+			res := Logseq.Includes(tc.path, tc.isDir)
+			if res != tc.expected {
+				t.Fatalf("Expected %v, got %v", tc.expected, res)
+			}
+		})
+	}
+}
+
+func TestLogseqValidate(t *testing.T) {
+	t.Run("graph with config", func(t *testing.T) {
+		err := Logseq.Validate("testdata/graph")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("empty directory", func(t *testing.T) {
+		err := Logseq.Validate(t.TempDir())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("directory without config", func(t *testing.T) {
+		dir := t.TempDir()
+		_ = os.WriteFile(dir+"/notes.md", []byte("notes"), 0644)
+
+		err := Logseq.Validate(dir)
+		if !errors.Is(err, ErrNotAGraph) {
+			t.Fatalf("Expected ErrNotAGraph, got %v", err)
+		}
+	})
+}
@@ -40,9 +40,20 @@ func LoadGraph(inputFile io.Reader) (Graph, error) {
 		return Graph{}, err
 	}
 
+	migrate(&g)
 	return g, nil
 }
 
+func migrate(g *Graph) {
+	if g.Version < 1 {
+		for i, file := range g.Files {
+			g.Files[i].Id = LegacyFileId(file.Id)
+		}
+	}
+
+	g.Version = CurrentVersion
+}
+
 func LoadGraphFromFile(filePath string) (Graph, error) {
 	file, err := os.Open(filePath)
 
@@ -53,6 +64,10 @@ func LoadGraphFromFile(filePath string) (Graph, error) {
 		}
 		return New(name), nil
 	}
+	if err != nil {
+		return Graph{}, err
+	}
+	defer file.Close()
 
 	return LoadGraph(file)
 }
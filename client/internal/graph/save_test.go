@@ -27,6 +27,23 @@ func TestLoadGraph(t *testing.T) {
 	}
 }
 
+func TestLoadLegacyGraph(t *testing.T) {
+	buffer := bytes.NewBufferString(`{"name":"test","files":[{"id":"pages___My Page.md","path":"test/pages/My Page.md"}]}`)
+
+	g, err := LoadGraph(buffer)
+	if err != nil {
+		t.Fatalf("expected no err, got %v", err)
+	}
+
+	if g.Version != CurrentVersion {
+		t.Fatalf("expected version %d, got %d", CurrentVersion, g.Version)
+	}
+
+	if g.Files[0].Id != "pages/My%20Page.md" {
+		t.Fatalf("expected migrated id, got %s", g.Files[0].Id)
+	}
+}
+
 func TestSaveGraph(t *testing.T) {
 	graph := getTestGraph()
 
@@ -38,24 +55,25 @@ func TestSaveGraph(t *testing.T) {
 	}
 
 	result := writer.String()
-	if result != "{\"name\":\"test\",\"files\":[{\"id\":\"Id1\",\"path\":\"test/id1\",\"lastChange\":\"2024-03-18T19:24:59.418+01:00\"},{\"id\":\"Id2\",\"path\":\"test/id2\",\"lastChange\":\"2024-03-18T19:24:59.418+01:00\"}]}" {
+	if result != "{\"version\":1,\"name\":\"test\",\"lastSync\":\"0001-01-01T00:00:00Z\",\"files\":[{\"id\":\"Id1\",\"path\":\"test/id1\",\"lastChange\":\"2024-03-18T18:24:59.418Z\"},{\"id\":\"Id2\",\"path\":\"test/id2\",\"lastChange\":\"2024-03-18T18:24:59.418Z\"}]}" {
 		t.Fatalf("Got wrong json: %s", result)
 	}
 }
 
 func getTestGraph() Graph {
 	return Graph{
-		Name: "test",
+		Version: CurrentVersion,
+		Name:    "test",
 		Files: []File{
 			{
 				Id:         "Id1",
 				Path:       "test/id1",
-				LastChange: time.UnixMilli(1710786299418),
+				LastChange: time.UnixMilli(1710786299418).UTC(),
 			},
 			{
 				Id:         "Id2",
 				Path:       "test/id2",
-				LastChange: time.UnixMilli(1710786299418),
+				LastChange: time.UnixMilli(1710786299418).UTC(),
 			},
 		},
 	}
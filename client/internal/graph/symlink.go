@@ -0,0 +1,81 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// SymlinkPolicy defines how symlinks in the graph directory are handled
+type SymlinkPolicy string
+
+const (
+	// SkipSymlinks ignores all symlinks
+	SkipSymlinks SymlinkPolicy = "skip"
+	// FollowSymlinks syncs the files and directories the symlinks point to, as if they were part of the graph
+	FollowSymlinks SymlinkPolicy = "follow"
+	// LinkSymlinks syncs the symlinks themselves, so they can be recreated on other clients
+	LinkSymlinks SymlinkPolicy = "link"
+)
+
+func SymlinkPolicyByName(name string) (SymlinkPolicy, error) {
+	switch SymlinkPolicy(name) {
+	case "", SkipSymlinks:
+		return SkipSymlinks, nil
+	case FollowSymlinks:
+		return FollowSymlinks, nil
+	case LinkSymlinks:
+		return LinkSymlinks, nil
+	default:
+		return "", fmt.Errorf("unknown symlink policy %s", name)
+	}
+}
+
+func isLoop(ancestors []os.FileInfo, info os.FileInfo) bool {
+	return slices.ContainsFunc(ancestors, func(ancestor os.FileInfo) bool {
+		return os.SameFile(ancestor, info)
+	})
+}
+
+// StoreSymlink creates a symlink pointing to target, an existing file or symlink is replaced.
+// Only targets inside the graph directory are allowed.
+func StoreSymlink(graphPath, fileId string, target string) (File, error) {
+	p, err := resolvePath(graphPath, fileId, LinkSymlinks)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = confineTarget(graphPath, p, target)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = ensureDirExists(p)
+	if err != nil {
+		return File{}, err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return File{}, err
+	}
+
+	err = os.Symlink(target, p)
+	if err != nil {
+		return File{}, err
+	}
+
+	info, err := os.Lstat(p)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Id:         fileId,
+		Path:       p,
+		Kind:       KindSymlink,
+		Target:     target,
+		LastChange: info.ModTime(),
+	}, nil
+}
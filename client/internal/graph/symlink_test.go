@@ -0,0 +1,173 @@
+package graph
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReadGraphSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(dir+"/pages/sub", os.ModePerm)
+	_ = os.WriteFile(dir+"/pages/Page1.md", []byte("content"), 0644)
+	_ = os.Symlink("Page1.md", dir+"/pages/link.md")
+	_ = os.Symlink("..", dir+"/pages/sub/loop")
+
+	read := func(policy SymlinkPolicy) Graph {
+		g, err := ReadGraph(dir, ReadOptions{Profile: Plain, Symlinks: policy})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return g
+	}
+	find := func(g Graph, id string) *File {
+		for _, file := range g.Files {
+			if file.Id == id {
+				return &file
+			}
+		}
+		return nil
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		g := read(SkipSymlinks)
+		if find(g, "pages/link.md") != nil {
+			t.Fatalf("Expected symlink to be skipped")
+		}
+	})
+
+	t.Run("link", func(t *testing.T) {
+		g := read(LinkSymlinks)
+		link := find(g, "pages/link.md")
+		if link == nil || !link.IsSymlink() {
+			t.Fatalf("Expected symlink entry, got %v", link)
+		}
+		if link.Target != "Page1.md" {
+			t.Fatalf("Expected target Page1.md, got %s", link.Target)
+		}
+	})
+
+	t.Run("follow with loop", func(t *testing.T) {
+		g := read(FollowSymlinks)
+		link := find(g, "pages/link.md")
+		if link == nil || link.Kind != KindFile {
+			t.Fatalf("Expected followed file, got %v", link)
+		}
+		if find(g, "pages/sub/loop") != nil {
+			t.Fatalf("Expected loop to be skipped")
+		}
+	})
+}
+
+func TestStoreSymlink(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := StoreSymlink(dir, "pages/link.md", "Page1.md")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	target, err := os.Readlink(dir + "/pages/link.md")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if target != "Page1.md" {
+		t.Fatalf("Expected target Page1.md, got %s", target)
+	}
+}
+
+func TestFollowedSymlinkRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	graphPath := filepath.Join(root, "graph")
+	outside := filepath.Join(root, "outside")
+	_ = os.MkdirAll(filepath.Join(graphPath, "pages"), os.ModePerm)
+	_ = os.MkdirAll(outside, os.ModePerm)
+	_ = os.WriteFile(filepath.Join(outside, "shared.md"), []byte("original"), 0644)
+	_ = os.Symlink(outside, filepath.Join(graphPath, "pages", "link"))
+
+	g, err := ReadGraph(graphPath, ReadOptions{Profile: Plain, Symlinks: FollowSymlinks})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !slices.ContainsFunc(g.Files, func(file File) bool { return file.Id == "pages/link/shared.md" }) {
+		t.Fatalf("Expected the file in the symlinked directory to be read, got %v", g.Files)
+	}
+
+	_, err = StoreFile(graphPath, "pages/link/shared.md", []byte("downloaded"), 0, time.Time{}, FollowSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(outside, "shared.md"))
+	if string(content) != "downloaded" {
+		t.Fatalf("Expected the file to be written through the symlink, got %s", content)
+	}
+
+	_, err = StoreDir(graphPath, "pages/link/sub", FollowSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = RemoveFile(graphPath, "pages/link/sub", FollowSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	_, err = StoreFile(graphPath, "pages/link/shared.md", []byte("skipped"), 0, time.Time{}, SkipSymlinks)
+	if !errors.Is(err, ErrOutsideGraph) {
+		t.Fatalf("Expected ErrOutsideGraph without following symlinks, got %v", err)
+	}
+}
+
+func TestStoreDirOnFollowedSymlink(t *testing.T) {
+	root := t.TempDir()
+	graphPath := filepath.Join(root, "graph")
+	outside := filepath.Join(root, "outside")
+	_ = os.MkdirAll(filepath.Join(graphPath, "pages"), os.ModePerm)
+	_ = os.MkdirAll(outside, os.ModePerm)
+	_ = os.WriteFile(filepath.Join(outside, "shared.md"), []byte("shared"), 0644)
+	_ = os.WriteFile(filepath.Join(outside, "target.md"), []byte("target"), 0644)
+	_ = os.Symlink(outside, filepath.Join(graphPath, "pages", "link"))
+	_ = os.Symlink(filepath.Join(outside, "target.md"), filepath.Join(graphPath, "pages", "file.md"))
+
+	_, err := StoreDir(graphPath, "pages/link", FollowSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = StoreDirMetadata(graphPath, "pages/link", 0, time.Time{}, FollowSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	info, err := os.Lstat(filepath.Join(graphPath, "pages", "link"))
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected the symlink to be kept, got %v, %v", info, err)
+	}
+	content, err := os.ReadFile(filepath.Join(outside, "shared.md"))
+	if err != nil || string(content) != "shared" {
+		t.Fatalf("Expected the children of the target to be kept, got %q, %v", content, err)
+	}
+
+	_, err = StoreFile(graphPath, "pages/file.md", []byte("changed"), 0, time.Time{}, FollowSymlinks)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	info, _ = os.Lstat(filepath.Join(graphPath, "pages", "file.md"))
+	content, _ = os.ReadFile(filepath.Join(outside, "target.md"))
+	if info.Mode()&os.ModeSymlink == 0 || string(content) != "changed" {
+		t.Fatalf("Expected the file to be written through the symlink, got %q", content)
+	}
+
+	// the walk doesn't follow them, so nothing is stored through them
+	_ = os.Symlink(filepath.Join(root, "missing"), filepath.Join(graphPath, "pages", "broken"))
+	_ = os.Symlink(filepath.Join(graphPath, "pages"), filepath.Join(graphPath, "pages", "loop"))
+	for _, fileId := range []string{"pages/broken/file.md", "pages/loop/file.md"} {
+		_, err = StoreFile(graphPath, fileId, []byte("escaped"), 0, time.Time{}, FollowSymlinks)
+		if !errors.Is(err, ErrOutsideGraph) {
+			t.Fatalf("Expected ErrOutsideGraph for %s, got %v", fileId, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(root, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected nothing to be created through the broken symlink, got %v", err)
+	}
+}
@@ -2,12 +2,21 @@ package log
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
+var output io.Writer = os.Stdout
+
+// SetOutput redirects the log messages, e.g. to keep the output of commands parsable
+func SetOutput(w io.Writer) {
+	output = w
+}
+
 func Info(format string, a ...any) {
-	fmt.Printf(format+"\n", a...)
+	fmt.Fprintf(output, format+"\n", a...)
 }
 
 func Error(format string, a ...any) {
-	fmt.Printf("ERROR: "+format+"%v\n", a...)
+	fmt.Fprintf(output, "ERROR: "+format+"%v\n", a...)
 }
@@ -0,0 +1,79 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+// Device is a client of a graph and the revision it acknowledged
+type Device struct {
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	Revision     int64     `json:"revision"`
+	Acknowledged time.Time `json:"acknowledged"`
+	LastSeen     time.Time `json:"last_seen"`
+	Stale        bool      `json:"stale"`
+}
+
+type AckRequest struct {
+	config config.Config
+}
+
+type DevicesRequest struct {
+	config config.Config
+}
+
+func NewAckRequest(conf config.Config) AckRequest {
+	return AckRequest{config: conf}
+}
+
+func NewDevicesRequest(conf config.Config) DevicesRequest {
+	return DevicesRequest{config: conf}
+}
+
+// Send acknowledges, that the device applied all changes up to the revision.
+// Older servers don't track devices, their 404 is ignored.
+func (r AckRequest) Send(ctx context.Context, graphName string, revision int64) error {
+	body, err := json.Marshal(map[string]int64{"revision": revision})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/%s/ack", r.config.Server.Host, neturl.PathEscape(graphName))
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, err := send(ctx, r.config, "POST", url, body, header)
+	if err != nil {
+		return err
+	}
+
+	if resp.status == http.StatusNotFound || resp.status == http.StatusMethodNotAllowed {
+		return nil
+	}
+	if resp.status != http.StatusNoContent {
+		return newError(resp)
+	}
+	return nil
+}
+
+// Send returns the devices, that synced the graph, or ErrNotFound, if the server doesn't track devices
+func (r DevicesRequest) Send(ctx context.Context, graphName string) ([]Device, error) {
+	url := fmt.Sprintf("%s/%s/devices", r.config.Server.Host, neturl.PathEscape(graphName))
+	resp, err := send(ctx, r.config, "GET", url, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.status != http.StatusOK {
+		return nil, newError(resp)
+	}
+
+	var devices []Device
+	err = json.Unmarshal(resp.body, &devices)
+	return devices, err
+}
@@ -0,0 +1,88 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Errors of the server, responses are matched with errors.Is
+var (
+	ErrNotFound = errors.New("not found on the server")
+	// ErrGraphNotFound the graph is not registered on the server, it is also ErrNotFound
+	ErrGraphNotFound = errors.New("graph not found on the server")
+	ErrUnauthorized  = errors.New("unauthorized, check server.apitoken")
+	ErrGraphArchived = errors.New("the graph is archived on the server")
+	ErrConflict      = errors.New("conflict with the state of the server")
+	ErrTooLarge      = errors.New("too large for the server")
+	// ErrResyncRequired the server compacted changes, that were not fetched yet
+	ErrResyncRequired = errors.New("resync required, the server compacted the changes since the last sync")
+	// ErrKeyRotation the key of the graph is being rotated, changes are rejected until the rotation is committed
+	ErrKeyRotation = errors.New("the key of the graph is being rotated")
+	// ErrKeyRotated the change was encrypted with a key, that was rotated
+	ErrKeyRotated = errors.New("the key of the graph was rotated")
+	// ErrTooManyDeletes the transaction deleted more files, than the server allows without confirmation
+	ErrTooManyDeletes = errors.New("the server refuses to delete more files without confirmation")
+)
+
+// Error is an error response of the server
+type Error struct {
+	Status int
+	// Code is the machine readable error code, older servers send none
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("no success status code: %d", e.Status)
+	}
+	return fmt.Sprintf("%s (status code %d)", e.Message, e.Status)
+}
+
+// Is matches the error with the errors of the server. Errors of older servers are matched by their status code.
+func (e *Error) Is(target error) bool {
+	switch e.Code {
+	case "graph-not-found":
+		return target == ErrGraphNotFound || target == ErrNotFound
+	case "not-found":
+		return target == ErrNotFound
+	case "unauthorized":
+		return target == ErrUnauthorized
+	case "graph-archived":
+		return target == ErrGraphArchived
+	case "conflict":
+		return target == ErrConflict
+	case "too-large":
+		return target == ErrTooLarge
+	case "resync-required":
+		return target == ErrResyncRequired
+	case "key-rotation":
+		return target == ErrKeyRotation
+	case "key-rotated":
+		return target == ErrKeyRotated
+	case "too-many-deletes":
+		return target == ErrTooManyDeletes
+	case "":
+		return target == statusErrors[e.Status]
+	}
+	return false
+}
+
+var statusErrors = map[int]error{
+	401: ErrUnauthorized,
+	404: ErrNotFound,
+	409: ErrConflict,
+	410: ErrResyncRequired,
+	413: ErrTooLarge,
+}
+
+func newError(resp response) error {
+	var body struct {
+		ErrorCode string `json:"error_code"`
+		Error     string `json:"error"`
+	}
+	// older servers respond with plain text to some errors
+	_ = json.Unmarshal(resp.body, &body)
+	return &Error{Status: resp.status, Code: body.ErrorCode, Message: body.Error}
+}
@@ -0,0 +1,44 @@
+package remote
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	tests := []struct {
+		name   string
+		resp   response
+		target error
+	}{
+		{name: "code", resp: response{status: 401, body: []byte(`{"code":401,"error_code":"unauthorized","error":"Unauthorized"}`)}, target: ErrUnauthorized},
+		{name: "graph not found", resp: response{status: 404, body: []byte(`{"error_code":"graph-not-found"}`)}, target: ErrNotFound},
+		{name: "resync", resp: response{status: 410, body: []byte(`{"error_code":"resync-required"}`)}, target: ErrResyncRequired},
+		{name: "key rotated", resp: response{status: 409, body: []byte(`{"error_code":"key-rotated"}`)}, target: ErrKeyRotated},
+		{name: "too many deletes", resp: response{status: 409, body: []byte(`{"error_code":"too-many-deletes"}`)}, target: ErrTooManyDeletes},
+		{name: "older server", resp: response{status: 401, body: []byte("Unauthorized\n")}, target: ErrUnauthorized},
+		{name: "status of older server", resp: response{status: 413}, target: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newError(tt.resp)
+			if !errors.Is(err, tt.target) {
+				t.Fatalf("Expected %v to be %v", err, tt.target)
+			}
+		})
+	}
+
+	err := newError(response{status: 404, body: []byte(`{"error_code":"not-found","error":"Not found"}`)})
+	if errors.Is(err, ErrGraphNotFound) {
+		t.Fatal("Expected a missing file not to be a missing graph")
+	}
+	if err.Error() != "Not found (status code 404)" {
+		t.Fatalf("Expected the message of the server, got %s", err)
+	}
+
+	err = newError(response{status: 409, body: []byte(`{"error_code":"key-rotated"}`)})
+	if errors.Is(err, ErrConflict) {
+		t.Fatal("Expected a rotated key not to be a conflict of the file")
+	}
+}
@@ -0,0 +1,112 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"net/http"
+	neturl "net/url"
+	"slices"
+)
+
+// Graph is the metadata of a graph registered on the server
+type Graph struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`
+	Encrypted bool   `json:"encrypted"`
+	KeyCheck  string `json:"key_check"`
+	// KeyParams of the key derivation, graphs of older versions have none
+	KeyParams string `json:"key_params"`
+	// KeyEpoch counts the rotations of the key
+	KeyEpoch int64 `json:"key_epoch"`
+	Archived bool  `json:"archived"`
+	// UploadEncodings the server decodes in request bodies, older servers have none
+	UploadEncodings []string `json:"upload_encodings,omitempty"`
+}
+
+// GraphUpdate changes the fields, that are set
+type GraphUpdate struct {
+	KeyCheck  *string `json:"key_check,omitempty"`
+	KeyParams *string `json:"key_params,omitempty"`
+}
+
+type GraphRequest struct {
+	config config.Config
+}
+
+func NewGraphRequest(conf config.Config) GraphRequest {
+	return GraphRequest{config: conf}
+}
+
+// Get returns the graph or ErrNotFound, if it does not exist
+func (r GraphRequest) Get(ctx context.Context, graphName string) (Graph, error) {
+	url := fmt.Sprintf("%s/graphs/%s", r.config.Server.Host, neturl.PathEscape(graphName))
+	resp, err := send(ctx, r.config, "GET", url, nil, nil)
+	if err != nil {
+		return Graph{}, err
+	}
+
+	if resp.status != http.StatusOK {
+		return Graph{}, newError(resp)
+	}
+
+	var graph Graph
+	err = json.Unmarshal(resp.body, &graph)
+	if slices.Contains(graph.UploadEncodings, "gzip") {
+		gzipHosts.Store(r.config.Server.Host, true)
+	}
+	return graph, err
+}
+
+// Create registers the graph on the server
+func (r GraphRequest) Create(ctx context.Context, graph Graph) (Graph, error) {
+	body, err := json.Marshal(graph)
+	if err != nil {
+		return Graph{}, err
+	}
+
+	url := fmt.Sprintf("%s/graphs", r.config.Server.Host)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, err := send(ctx, r.config, "POST", url, body, header)
+	if err != nil {
+		return Graph{}, err
+	}
+
+	if resp.status == http.StatusNotFound || resp.status == http.StatusMethodNotAllowed {
+		return Graph{}, ErrNotFound
+	}
+	if resp.status != http.StatusCreated {
+		return Graph{}, newError(resp)
+	}
+
+	var created Graph
+	err = json.Unmarshal(resp.body, &created)
+	return created, err
+}
+
+// Update changes the graph on the server, older servers respond with ErrNotFound
+func (r GraphRequest) Update(ctx context.Context, graphName string, update GraphUpdate) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/graphs/%s", r.config.Server.Host, neturl.PathEscape(graphName))
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, err := send(ctx, r.config, "PATCH", url, body, header)
+	if err != nil {
+		return err
+	}
+
+	if resp.status == http.StatusMethodNotAllowed {
+		return ErrNotFound
+	}
+	if resp.status != http.StatusOK {
+		return newError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"time"
+)
+
+// Manifest is the current state of a graph on the server.
+// The changes after its revision are not included.
+type Manifest struct {
+	Graph    string          `json:"graph"`
+	Revision int64           `json:"revision"`
+	Files    []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is a file, that was not deleted
+type ManifestEntry struct {
+	FileId   string      `json:"file_id"`
+	Revision int64       `json:"revision"`
+	Size     int64       `json:"size"`
+	Hash     string      `json:"hash"`
+	Kind     string      `json:"kind"`
+	Mode     os.FileMode `json:"mode"`
+	ModTime  time.Time   `json:"mod_time"`
+}
+
+type ManifestRequest struct {
+	config config.Config
+}
+
+func NewManifestRequest(conf config.Config) ManifestRequest {
+	return ManifestRequest{config: conf}
+}
+
+// Send returns the manifest of the graph or ErrNotFound, if the graph does not exist
+// or the server is too old to provide manifests
+func (r ManifestRequest) Send(ctx context.Context, graphName string) (Manifest, error) {
+	url := fmt.Sprintf("%s/%s/manifest", r.config.Server.Host, neturl.PathEscape(graphName))
+	resp, err := send(ctx, r.config, "GET", url, nil, nil)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if resp.status != http.StatusOK {
+		return Manifest{}, newError(resp)
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(resp.body, &manifest)
+	return manifest, err
+}
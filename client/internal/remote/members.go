@@ -0,0 +1,118 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+// Member can decrypt a shared graph with the data key wrapped for the public key of the member
+type Member struct {
+	PublicKey  string `json:"public_key"`
+	Name       string `json:"name"`
+	WrappedKey string `json:"wrapped_key"`
+	// PendingKey is the new key of a running rotation wrapped for the member
+	PendingKey string    `json:"pending_key"`
+	Accepted   bool      `json:"accepted"`
+	InvitedAt  time.Time `json:"invited_at"`
+}
+
+// MemberUpdate is the wrapped key of a member, during a key rotation the new key is sent as PendingKey
+type MemberUpdate struct {
+	Name       string `json:"name,omitempty"`
+	WrappedKey string `json:"wrapped_key,omitempty"`
+	PendingKey string `json:"pending_key,omitempty"`
+}
+
+type MemberRequest struct {
+	config config.Config
+}
+
+func NewMemberRequest(conf config.Config) MemberRequest {
+	return MemberRequest{config: conf}
+}
+
+// List returns the members of the graph
+func (r MemberRequest) List(ctx context.Context, graphName string) ([]Member, error) {
+	resp, err := send(ctx, r.config, "GET", r.url(graphName, ""), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.status != http.StatusOK {
+		return nil, newError(resp)
+	}
+
+	var members []Member
+	err = json.Unmarshal(resp.body, &members)
+	return members, err
+}
+
+// Get returns the member with the public key or ErrNotFound
+func (r MemberRequest) Get(ctx context.Context, graphName, publicKey string) (Member, error) {
+	resp, err := send(ctx, r.config, "GET", r.url(graphName, "/"+publicKey), nil, nil)
+	if err != nil {
+		return Member{}, err
+	}
+
+	if resp.status != http.StatusOK {
+		return Member{}, newError(resp)
+	}
+
+	var member Member
+	err = json.Unmarshal(resp.body, &member)
+	return member, err
+}
+
+// Save invites the member or replaces the wrapped key of the member
+func (r MemberRequest) Save(ctx context.Context, graphName, publicKey string, update MemberUpdate) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, err := send(ctx, r.config, "PUT", r.url(graphName, "/"+publicKey), body, header)
+	if err != nil {
+		return err
+	}
+
+	if resp.status != http.StatusOK {
+		return newError(resp)
+	}
+	return nil
+}
+
+// Accept marks the invitation of the member as accepted
+func (r MemberRequest) Accept(ctx context.Context, graphName, publicKey string) error {
+	resp, err := send(ctx, r.config, "POST", r.url(graphName, "/"+publicKey+"/accept"), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.status != http.StatusNoContent {
+		return newError(resp)
+	}
+	return nil
+}
+
+// Remove removes the wrapped key of the member
+func (r MemberRequest) Remove(ctx context.Context, graphName, publicKey string) error {
+	resp, err := send(ctx, r.config, "DELETE", r.url(graphName, "/"+publicKey), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.status != http.StatusNoContent {
+		return newError(resp)
+	}
+	return nil
+}
+
+func (r MemberRequest) url(graphName, path string) string {
+	return fmt.Sprintf("%s/graphs/%s/members%s", r.config.Server.Host, neturl.PathEscape(graphName), path)
+}
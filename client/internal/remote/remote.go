@@ -2,18 +2,27 @@ package remote
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/soerenchrist/logsync/client/internal/config"
-	"io"
 	"mime/multipart"
 	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
 	"time"
 )
 
 const transactionHeader = "X-Transaction-Id"
 
+const keyEpochHeader = "X-Key-Epoch"
+
+const deviceIdHeader = "X-Device-Id"
+const deviceNameHeader = "X-Device-Name"
+
+const revisionHeader = "X-Graph-Revision"
+
 type ChangesRequest struct {
 	config config.Config
 }
@@ -22,12 +31,40 @@ type ContentRequest struct {
 	config config.Config
 }
 
+type HistoryRequest struct {
+	config config.Config
+}
+
 type ChangeLogEntry struct {
-	GraphName     string    `json:"graph_name"`
-	FileId        string    `json:"file_id"`
-	Timestamp     time.Time `json:"timestamp"`
-	TransactionId string    `json:"transaction_id"`
-	Operation     string    `json:"operation"`
+	GraphName     string      `json:"graph_name"`
+	FileId        string      `json:"file_id"`
+	Timestamp     time.Time   `json:"timestamp"`
+	TransactionId string      `json:"transaction_id"`
+	Operation     string      `json:"operation"`
+	Kind          string      `json:"kind"`
+	Mode          os.FileMode `json:"mode"`
+	ModTime       time.Time   `json:"mod_time"`
+	// Revision older servers don't number the changes
+	Revision int64 `json:"revision"`
+	// DeviceId of the client, that made the change
+	DeviceId string `json:"device_id"`
+}
+
+// Changes are the changes after a cursor and the revision of the graph, that includes them.
+// Older servers send no revision.
+type Changes struct {
+	Entries  []ChangeLogEntry
+	Revision int64
+}
+
+// UnknownRevision skips the check of the base revision, e.g. for files synced with older servers
+const UnknownRevision int64 = -1
+
+// Metadata of a file, that is transferred alongside the content
+type Metadata struct {
+	Kind    string
+	Mode    os.FileMode
+	ModTime time.Time
 }
 
 func NewChangesRequest(conf config.Config) ChangesRequest {
@@ -38,29 +75,48 @@ func NewContentRequest(conf config.Config) ContentRequest {
 	return ContentRequest{config: conf}
 }
 
-func (r ChangesRequest) Send(graphName string, since time.Time) ([]ChangeLogEntry, error) {
-	url := fmt.Sprintf("%s/%s/changes?since=%d", r.config.Server.Host, graphName, since.UnixMilli())
-	req, err := http.NewRequest("GET", url, nil)
+func NewHistoryRequest(conf config.Config) HistoryRequest {
+	return HistoryRequest{config: conf}
+}
+
+// Send returns the changes after the revision or, without a revision, the changes since the time
+func (r ChangesRequest) Send(ctx context.Context, graphName string, since time.Time, afterRevision int64) (Changes, error) {
+	url := fmt.Sprintf("%s/%s/changes?since=%d", r.config.Server.Host, neturl.PathEscape(graphName), since.UnixMilli())
+	if afterRevision > 0 {
+		url = fmt.Sprintf("%s&after_revision=%d", url, afterRevision)
+	}
+	resp, err := send(ctx, r.config, "GET", url, nil, nil)
 	if err != nil {
-		return nil, err
+		return Changes{}, err
 	}
-	addApiTokenIfExists(req, r.config)
-	resp, err := http.DefaultClient.Do(req)
+
+	if resp.status != http.StatusOK {
+		return Changes{}, newError(resp)
+	}
+
+	var entries []ChangeLogEntry
+	err = json.Unmarshal(resp.body, &entries)
 	if err != nil {
-		return nil, err
+		return Changes{}, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	return Changes{Entries: entries, Revision: readRevision(resp.header)}, nil
+}
+
+// Send returns the latest changes of the file, newest first
+func (r HistoryRequest) Send(ctx context.Context, graphName string, fileId string, limit int) ([]ChangeLogEntry, error) {
+	url := fmt.Sprintf("%s/%s/history/%s?size=%d", r.config.Server.Host, neturl.PathEscape(graphName), neturl.PathEscape(fileId), limit)
+	resp, err := send(ctx, r.config, "GET", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, errors.New("status code is no success")
+	if resp.status != http.StatusOK {
+		return nil, newError(resp)
 	}
 
 	var entries []ChangeLogEntry
-	err = json.Unmarshal(body, &entries)
+	err = json.Unmarshal(resp.body, &entries)
 	if err != nil {
 		return nil, err
 	}
@@ -68,24 +124,18 @@ func (r ChangesRequest) Send(graphName string, since time.Time) ([]ChangeLogEntr
 	return entries, nil
 }
 
-func (r ContentRequest) Send(graphName string, fileId string) ([]byte, error) {
-	url := fmt.Sprintf("%s/%s/content/%s", r.config.Server.Host, graphName, fileId)
-	req, err := http.NewRequest("GET", url, nil)
+func (r ContentRequest) Send(ctx context.Context, graphName string, fileId string) ([]byte, error) {
+	url := fmt.Sprintf("%s/%s/content/%s", r.config.Server.Host, neturl.PathEscape(graphName), neturl.PathEscape(fileId))
+	resp, err := send(ctx, r.config, "GET", url, nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	addApiTokenIfExists(req, r.config)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(fmt.Sprintf("no success status code: %d", resp.StatusCode))
+	if resp.status != http.StatusOK {
+		return nil, newError(resp)
 	}
 
-	return io.ReadAll(resp.Body)
+	return resp.body, nil
 }
 
 type request struct {
@@ -93,6 +143,7 @@ type request struct {
 	graphName   string
 	transaction string
 	operation   string
+	keyEpoch    int64
 }
 
 type UploadRequest struct {
@@ -103,101 +154,140 @@ type DeleteRequest struct {
 	request
 }
 
-func NewUploadRequest(conf config.Config, graphName, transaction, operation string) UploadRequest {
+// NewUploadRequest the key epoch is the number of rotations of the key, that encrypted the file
+func NewUploadRequest(conf config.Config, graphName, transaction, operation string, keyEpoch int64) UploadRequest {
 	return UploadRequest{
 		request: request{
 			config:      conf,
 			graphName:   graphName,
 			transaction: transaction,
 			operation:   operation,
+			keyEpoch:    keyEpoch,
 		},
 	}
 }
 
-func NewDeleteRequest(conf config.Config, graphName, transaction string) DeleteRequest {
+func NewDeleteRequest(conf config.Config, graphName, transaction string, keyEpoch int64) DeleteRequest {
 	return DeleteRequest{request: request{
 		config:      conf,
 		graphName:   graphName,
 		transaction: transaction,
 		operation:   "D",
+		keyEpoch:    keyEpoch,
 	},
 	}
 }
 
-func (r DeleteRequest) Send(filename string, modified time.Time) error {
-	url := fmt.Sprintf("%s/%s/delete/%s?&modified_date=%d", r.config.Server.Host, r.graphName, filename, modified.UnixMilli())
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return err
+// Send deletes the file and returns the revision of the deletion, older servers return none.
+// When the file was changed after the base revision, the server rejects the deletion with ErrConflict.
+// Deletions above the limit of the server are rejected with ErrTooManyDeletes, unless sync.ConfirmDeletes is set.
+func (r DeleteRequest) Send(ctx context.Context, filename string, modified time.Time, kind string, base int64) (int64, error) {
+	url := fmt.Sprintf("%s/%s/delete/%s?&modified_date=%d&kind=%s", r.config.Server.Host, neturl.PathEscape(r.graphName), neturl.PathEscape(filename), modified.UnixMilli(), kind)
+	if base != UnknownRevision {
+		url = fmt.Sprintf("%s&base_revision=%d", url, base)
 	}
-	req.Header.Add(transactionHeader, r.transaction)
-
-	addApiTokenIfExists(req, r.config)
-	resp, err := http.DefaultClient.Do(req)
+	if r.config.Sync.ConfirmDeletes {
+		url += "&confirm_deletes=true"
+	}
+	resp, err := send(ctx, r.config, "DELETE", url, nil, r.header())
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if resp.StatusCode != http.StatusNoContent {
-		return errors.New(fmt.Sprintf("no success status code: %d", resp.StatusCode))
+	if resp.status != http.StatusNoContent {
+		return 0, newError(resp)
 	}
 
-	return nil
+	return readRevision(resp.header), nil
 }
 
-func (u UploadRequest) Send(filename string, modified time.Time, body []byte) error {
-	return u.upload(filename, modified, body)
+// Send uploads the file and returns the revision of the change, older servers return none.
+// When the file was changed after the base revision, the server rejects the upload with ErrConflict.
+// The base revision of a new file is 0.
+func (u UploadRequest) Send(ctx context.Context, filename string, metadata Metadata, body []byte, base int64) (int64, error) {
+	return u.upload(ctx, filename, metadata, body, base)
 }
 
-func (r request) upload(filename string, modified time.Time, body []byte) error {
-	url := fmt.Sprintf("%s/%s/upload", r.config.Server.Host, r.graphName)
+func (r request) upload(ctx context.Context, filename string, metadata Metadata, body []byte, base int64) (int64, error) {
+	url := fmt.Sprintf("%s/%s/upload", r.config.Server.Host, neturl.PathEscape(r.graphName))
 	var buf bytes.Buffer
 	mw := multipart.NewWriter(&buf)
 
 	fileWriter, err := mw.CreateFormFile("file", filename)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	_, err = fileWriter.Write(body)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	// the file name of the form file is shortened to its base name, therefore the id is sent separately
+	err = addFormField(mw, "file_id", filename)
+	if err != nil {
+		return 0, err
 	}
 
 	err = addFormField(mw, "operation", r.operation)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	err = addFormField(mw, "modified-date", modified.Format(time.RFC3339))
+	err = addFormField(mw, "modified-date", metadata.ModTime.Format(time.RFC3339Nano))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	err = mw.Close()
+
+	err = addFormField(mw, "mode", strconv.FormatUint(uint64(metadata.Mode.Perm()), 8))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	req, err := http.NewRequest("POST", url, &buf)
+	err = addFormField(mw, "kind", metadata.Kind)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if base != UnknownRevision {
+		err = addFormField(mw, "base_revision", strconv.FormatInt(base, 10))
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = mw.Close()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	req.Header.Add(transactionHeader, r.transaction)
-	addApiTokenIfExists(req, r.config)
-	resp, err := http.DefaultClient.Do(req)
 
-	if resp.StatusCode != http.StatusCreated {
-		respBody, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Message: %s\n", respBody)
-		fmt.Printf("Status-code: %d\n", resp.StatusCode)
-		return errors.New(fmt.Sprintf("no success status code: %d", resp.StatusCode))
+	header := r.header()
+	header.Set("Content-Type", mw.FormDataContentType())
+	content := buf.Bytes()
+	if !r.config.Encryption.Enabled {
+		// encrypted content is compressed before it is encrypted
+		content, err = compressBody(r.config, content, header)
+		if err != nil {
+			return 0, err
+		}
 	}
-	return nil
+	resp, err := send(ctx, r.config, "POST", url, content, header)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.status != http.StatusCreated {
+		return 0, newError(resp)
+	}
+	return readRevision(resp.header), nil
+}
+
+func (r request) header() http.Header {
+	header := http.Header{}
+	header.Set(transactionHeader, r.transaction)
+	if r.config.Encryption.Enabled {
+		header.Set(keyEpochHeader, strconv.FormatInt(r.keyEpoch, 10))
+	}
+	return header
 }
 
 func addFormField(mw *multipart.Writer, fieldName, content string) error {
@@ -211,11 +301,3 @@ func addFormField(mw *multipart.Writer, fieldName, content string) error {
 	}
 	return nil
 }
-
-func addApiTokenIfExists(r *http.Request, conf config.Config) {
-	if conf.Server.ApiToken == "" {
-		return
-	}
-
-	r.Header.Set("X-Api-Token", conf.Server.ApiToken)
-}
@@ -0,0 +1,122 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+// Rotation is a running rotation of the key of a graph
+type Rotation struct {
+	KeyParams string        `json:"key_params"`
+	KeyCheck  string        `json:"key_check"`
+	StartedAt time.Time     `json:"started_at"`
+	Rotated   int64         `json:"rotated"`
+	Pending   []PendingFile `json:"pending"`
+}
+
+// PendingFile is a file, that was not encrypted with the new key yet.
+// Deleted files and directories have no content.
+type PendingFile struct {
+	FileId  string `json:"file_id"`
+	Content bool   `json:"content"`
+}
+
+type RotationRequest struct {
+	config config.Config
+}
+
+func NewRotationRequest(conf config.Config) RotationRequest {
+	return RotationRequest{config: conf}
+}
+
+// Get returns the running rotation of the graph or ErrNotFound
+func (r RotationRequest) Get(ctx context.Context, graphName string) (Rotation, error) {
+	resp, err := send(ctx, r.config, "GET", r.url(graphName, ""), nil, nil)
+	if err != nil {
+		return Rotation{}, err
+	}
+
+	if resp.status != http.StatusOK {
+		return Rotation{}, newError(resp)
+	}
+
+	var rotation Rotation
+	err = json.Unmarshal(resp.body, &rotation)
+	return rotation, err
+}
+
+// Start starts the rotation to the new key. A rotation with the same key check is resumed,
+// another running rotation fails with ErrKeyRotation.
+func (r RotationRequest) Start(ctx context.Context, graphName, keyParams, keyCheck string) (Rotation, error) {
+	body, err := json.Marshal(map[string]string{"key_params": keyParams, "key_check": keyCheck})
+	if err != nil {
+		return Rotation{}, err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, err := send(ctx, r.config, "POST", r.url(graphName, ""), body, header)
+	if err != nil {
+		return Rotation{}, err
+	}
+
+	if resp.status != http.StatusOK {
+		return Rotation{}, newError(resp)
+	}
+
+	var rotation Rotation
+	err = json.Unmarshal(resp.body, &rotation)
+	return rotation, err
+}
+
+// RotateFile uploads the file encrypted with the new key, files without content have no body
+func (r RotationRequest) RotateFile(ctx context.Context, graphName, fileId, newFileId string, content []byte) error {
+	url := fmt.Sprintf("%s?new_file_id=%s", r.url(graphName, "/files/"+neturl.PathEscape(fileId)), neturl.QueryEscape(newFileId))
+	resp, err := send(ctx, r.config, "PUT", url, content, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.status != http.StatusNoContent {
+		return newError(resp)
+	}
+	return nil
+}
+
+// Commit replaces the files of the graph with the rotated files and returns the graph with the new key
+func (r RotationRequest) Commit(ctx context.Context, graphName string) (Graph, error) {
+	resp, err := send(ctx, r.config, "POST", r.url(graphName, "/commit"), nil, nil)
+	if err != nil {
+		return Graph{}, err
+	}
+
+	if resp.status != http.StatusOK {
+		return Graph{}, newError(resp)
+	}
+
+	var graph Graph
+	err = json.Unmarshal(resp.body, &graph)
+	return graph, err
+}
+
+// Cancel discards the running rotation
+func (r RotationRequest) Cancel(ctx context.Context, graphName string) error {
+	resp, err := send(ctx, r.config, "DELETE", r.url(graphName, ""), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.status != http.StatusNoContent {
+		return newError(resp)
+	}
+	return nil
+}
+
+func (r RotationRequest) url(graphName, path string) string {
+	return fmt.Sprintf("%s/graphs/%s/rotation%s", r.config.Server.Host, neturl.PathEscape(graphName), path)
+}
@@ -0,0 +1,176 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+	// maxRetryAfter limits how long the client follows the Retry-After header of the server
+	maxRetryAfter = 5 * time.Minute
+)
+
+var httpClient = &http.Client{}
+
+// gzipHosts are the servers, that advertised gzip in the upload_encodings of a graph
+var gzipHosts sync.Map
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// send retries unreachable servers, server errors and rate limits with exponential backoff.
+func send(ctx context.Context, conf config.Config, method, url string, body []byte, header http.Header) (response, error) {
+	attempt := 0
+	for {
+		resp, err := sendOnce(ctx, conf, method, url, body, header)
+		if !retryable(ctx, resp, err) || attempt >= conf.Server.Retries {
+			return resp, err
+		}
+
+		wait := backoff(attempt, resp.header.Get("Retry-After"))
+		log.Info("Request to %s failed, retrying in %v", url, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return resp, ctx.Err()
+		case <-time.After(wait):
+		}
+		attempt++
+	}
+}
+
+func sendOnce(ctx context.Context, conf config.Config, method, url string, body []byte, header http.Header) (response, error) {
+	if conf.Server.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(conf.Server.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	if err != nil {
+		return response{}, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	addHeaders(req, conf)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return response{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response{}, err
+	}
+	return response{status: resp.StatusCode, header: resp.Header, body: respBody}, nil
+}
+
+func compressBody(conf config.Config, body []byte, header http.Header) ([]byte, error) {
+	if conf.Sync.Compression != "gzip" {
+		return body, nil
+	}
+	if _, ok := gzipHosts.Load(conf.Server.Host); !ok {
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Encoding", "gzip")
+	return buf.Bytes(), nil
+}
+
+func retryable(ctx context.Context, resp response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.status >= http.StatusInternalServerError || resp.status == http.StatusTooManyRequests
+}
+
+// backoff follows Retry-After, otherwise the randomized delay spreads the retries of the clients.
+func backoff(attempt int, retryAfter string) time.Duration {
+	if wait, ok := parseRetryAfter(retryAfter); ok {
+		return min(wait, maxRetryAfter)
+	}
+
+	wait := maxBackoff
+	if attempt < 16 {
+		wait = min(minBackoff<<attempt, maxBackoff)
+	}
+	// jitter between half and the full time
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func parseRetryAfter(retryAfter string) (time.Duration, bool) {
+	if retryAfter == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(retryAfter)
+	if err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	date, err := http.ParseTime(retryAfter)
+	if err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func readRevision(header http.Header) int64 {
+	revision, err := strconv.ParseInt(header.Get(revisionHeader), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+type withoutDeviceKey struct{}
+
+// WithoutDevice leaves the device out of the requests with the context. Read-only requests, like the ones of a
+// dry run, don't register the device on the server and don't count as a sync of it.
+func WithoutDevice(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutDeviceKey{}, true)
+}
+
+func addHeaders(r *http.Request, conf config.Config) {
+	if conf.Device.Id != "" && r.Context().Value(withoutDeviceKey{}) == nil {
+		r.Header.Set(deviceIdHeader, conf.Device.Id)
+		r.Header.Set(deviceNameHeader, conf.Device.Name)
+	}
+	if conf.Server.ApiToken == "" {
+		return
+	}
+
+	r.Header.Set("X-Api-Token", conf.Server.ApiToken)
+}
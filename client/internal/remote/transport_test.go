@@ -0,0 +1,186 @@
+package remote
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig(host string) config.Config {
+	return config.Config{Server: config.ServerConfig{Host: host, Timeout: 5, Retries: 3}}
+}
+
+func TestSendRetriesServerErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	resp, err := send(context.Background(), testConfig(server.URL), "GET", server.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.status != http.StatusOK || string(resp.body) != "ok" || requests != 3 {
+		t.Fatalf("Expected success after 3 requests, got %d after %d", resp.status, requests)
+	}
+}
+
+func TestSendDoesNotRetryClientErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	resp, err := send(context.Background(), testConfig(server.URL), "GET", server.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.status != http.StatusNotFound || requests != 1 {
+		t.Fatalf("Expected a single request, got %d requests", requests)
+	}
+}
+
+func TestSendStopsWhenCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := send(ctx, testConfig(server.URL), "GET", server.URL, nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected the deadline to stop the retries, got %v", err)
+	}
+}
+
+func TestCompressBody(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`{"name": "Personal", "upload_encodings": ["gzip"]}`))
+			return
+		}
+		received = ""
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(reader)
+			received = string(body)
+		}
+	}))
+	defer server.Close()
+
+	conf := testConfig(server.URL)
+	conf.Sync.Compression = "gzip"
+	header := http.Header{}
+	body, err := compressBody(conf, []byte("content"), header)
+	if err != nil || string(body) != "content" || header.Get("Content-Encoding") != "" {
+		t.Fatalf("Expected no compression before the server advertised it, got %v", err)
+	}
+
+	_, err = NewGraphRequest(conf).Get(context.Background(), "Personal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = compressBody(conf, []byte("content"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = send(context.Background(), conf, "POST", server.URL, body, header)
+	if err != nil || received != "content" {
+		t.Fatalf("Expected the server to decode the compressed body, got %q, %v", received, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 20; attempt++ {
+		limit := maxBackoff
+		if attempt < 6 {
+			limit = minBackoff << attempt
+		}
+		wait := backoff(attempt, "")
+		if wait < limit/2 || wait > limit {
+			t.Fatalf("Expected attempt %d to wait between %v and %v, got %v", attempt, limit/2, limit, wait)
+		}
+	}
+
+	if wait := backoff(0, "7"); wait != 7*time.Second {
+		t.Fatalf("Expected to follow Retry-After, got %v", wait)
+	}
+	if wait := backoff(0, "3600"); wait != maxRetryAfter {
+		t.Fatalf("Expected Retry-After to be limited, got %v", wait)
+	}
+	if wait := backoff(0, "soon"); wait > minBackoff {
+		t.Fatalf("Expected an invalid Retry-After to be ignored, got %v", wait)
+	}
+}
+
+func TestSendWithoutDevice(t *testing.T) {
+	var devices []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		devices = append(devices, r.Header.Get(deviceIdHeader))
+	}))
+	defer server.Close()
+
+	conf := testConfig(server.URL)
+	conf.Device.Id = "laptop"
+	_, err := send(context.Background(), conf, "GET", server.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = send(WithoutDevice(context.Background()), conf, "GET", server.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 || devices[0] != "laptop" || devices[1] != "" {
+		t.Fatalf("Expected the device only without WithoutDevice, got %v", devices)
+	}
+}
+
+func TestGraphNameIsEscaped(t *testing.T) {
+	graphName := "Notes #1 100%"
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	conf := testConfig(server.URL)
+	ctx := context.Background()
+	_ = NewAckRequest(conf).Send(ctx, graphName, 1)
+	_, _ = NewDevicesRequest(conf).Send(ctx, graphName)
+	_, _ = NewManifestRequest(conf).Send(ctx, graphName)
+	_, _ = NewChangesRequest(conf).Send(ctx, graphName, time.Time{}, 0)
+	_, _ = NewHistoryRequest(conf).Send(ctx, graphName, "pages/a.md", 1)
+	_, _ = NewContentRequest(conf).Send(ctx, graphName, "pages/a.md")
+	_, _ = NewDeleteRequest(conf, graphName, "", 0).Send(ctx, "pages/a.md", time.Now(), "file", 0)
+
+	if len(paths) != 7 {
+		t.Fatalf("Expected 7 requests, got %v", paths)
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/"+graphName+"/") {
+			t.Fatalf("Expected the path to start with the graph name, got %s", p)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidGraphName = errors.New("invalid graph name")
+
+const maxGraphNameLength = 128
+
+// GraphName checks the name against the rules of the server: letters, digits, spaces, "-", "_"
+// and ".", not starting with a "." and not ending with a space or "."
+func GraphName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidGraphName)
+	}
+	if len(name) > maxGraphNameLength {
+		return fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidGraphName, maxGraphNameLength)
+	}
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return fmt.Errorf("%w: %q must not start with a dot or end with a dot or space", ErrInvalidGraphName, name)
+	}
+
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" -_.", r) {
+			continue
+		}
+		return fmt.Errorf("%w: %q contains the invalid character %q", ErrInvalidGraphName, name, r)
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package sanitize
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGraphName(t *testing.T) {
+	t.Run("valid names", func(t *testing.T) {
+		for _, name := range []string{"Personal", "My Graph", "graph-1_2.0", "Notizen Ä"} {
+			err := GraphName(name)
+			if err != nil {
+				t.Fatalf("Expected no error for %s, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("invalid names", func(t *testing.T) {
+		for _, name := range []string{"", "..", "../etc", "a/b", ".hidden", "trailing "} {
+			err := GraphName(name)
+			if !errors.Is(err, ErrInvalidGraphName) {
+				t.Fatalf("Expected ErrInvalidGraphName for %q, got %v", name, err)
+			}
+		}
+	})
+}
@@ -2,8 +2,11 @@ package sync
 
 import (
 	"errors"
+	"github.com/google/uuid"
+	"github.com/soerenchrist/logsync/client/internal/config"
 	"os"
 	"path"
+	"strings"
 )
 
 func getLoadFilePath(graphName string) (string, error) {
@@ -12,19 +15,17 @@ func getLoadFilePath(graphName string) (string, error) {
 		return "", err
 	}
 
-	configDir := path.Join(dirName, ".config")
-	err = ensureCreated(configDir)
-	if err != nil {
-		return "", err
-	}
+	return path.Join(dirName, ".config", "logsync", graphName+".json"), nil
+}
 
-	logsyncDir := path.Join(configDir, "logsync")
-	err = ensureCreated(logsyncDir)
+func ensureLoadFileDir(loadFilePath string) error {
+	logsyncDir := path.Dir(loadFilePath)
+	err := ensureCreated(path.Dir(logsyncDir))
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return path.Join(dirName, ".config", "logsync", graphName+".json"), nil
+	return ensureCreated(logsyncDir)
 }
 
 func ensureCreated(dir string) error {
@@ -38,3 +39,55 @@ func ensureCreated(dir string) error {
 
 	return nil
 }
+
+func getDeviceIdPath() (string, error) {
+	dirName, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dirName, ".config", "logsync", "device-id"), nil
+}
+
+// DeviceId returns the configured id of the device or the id generated on the first sync.
+// Before the first sync, the device has no id.
+func DeviceId(conf config.Config) string {
+	if conf.Device.Id != "" {
+		return conf.Device.Id
+	}
+	idPath, err := getDeviceIdPath()
+	if err != nil {
+		return ""
+	}
+	content, err := os.ReadFile(idPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func ensureDeviceId(conf config.Config) (config.Config, error) {
+	conf.Device.Id = DeviceId(conf)
+	if conf.Device.Id != "" {
+		return conf, nil
+	}
+
+	idPath, err := getDeviceIdPath()
+	if err != nil {
+		return conf, err
+	}
+	err = ensureLoadFileDir(idPath)
+	if err != nil {
+		return conf, err
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return conf, err
+	}
+	err = os.WriteFile(idPath, []byte(id.String()+"\n"), 0600)
+	if err != nil {
+		return conf, err
+	}
+	conf.Device.Id = id.String()
+	return conf, nil
+}
@@ -3,11 +3,10 @@ package sync
 import (
 	"github.com/soerenchrist/logsync/client/internal/compare"
 	"github.com/soerenchrist/logsync/client/internal/graph"
-	"github.com/soerenchrist/logsync/client/internal/remote"
 	"slices"
 )
 
-func checkForConflicts(remoteChanges []remote.ChangeLogEntry, localChanges compare.Result) []string {
+func checkForConflicts(remoteChanges []change, localChanges compare.Result) []string {
 	if len(remoteChanges) == 0 {
 		return []string{}
 	}
@@ -20,21 +19,21 @@ func checkForConflicts(remoteChanges []remote.ChangeLogEntry, localChanges compa
 
 	for _, remoteChange := range remoteChanges {
 		if slices.ContainsFunc(localChanges.Changed, func(file graph.File) bool {
-			return file.Id == remoteChange.FileId
+			return file.Id == remoteChange.localId
 		}) {
-			conflicts = append(conflicts, remoteChange.FileId)
+			conflicts = append(conflicts, remoteChange.localId)
 		}
 
 		if slices.ContainsFunc(localChanges.Created, func(file graph.File) bool {
-			return file.Id == remoteChange.FileId
+			return file.Id == remoteChange.localId
 		}) {
-			conflicts = append(conflicts, remoteChange.FileId)
+			conflicts = append(conflicts, remoteChange.localId)
 		}
 
 		if slices.ContainsFunc(localChanges.Deleted, func(file graph.File) bool {
-			return file.Id == remoteChange.FileId
+			return file.Id == remoteChange.localId
 		}) {
-			conflicts = append(conflicts, remoteChange.FileId)
+			conflicts = append(conflicts, remoteChange.localId)
 		}
 	}
 
@@ -0,0 +1,51 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrTooManyDeletes = errors.New("too many files were deleted")
+
+const minDeleteLimit = 10
+
+// DeleteLimit is the number of deletions, above which a sync is refused. It follows the rules of
+// deletes.limit of the server, the percentage is based on the synced files.
+type DeleteLimit struct {
+	Count   int
+	Percent int
+}
+
+// ParseDeleteLimit parses the sync.deletelimit config
+func ParseDeleteLimit(value string) (DeleteLimit, error) {
+	number, isPercent := strings.CutSuffix(strings.TrimSpace(value), "%")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 || isPercent && n > 100 {
+		return DeleteLimit{}, fmt.Errorf("invalid delete limit %q, expected a number of files or a percentage like 50%%", value)
+	}
+	if isPercent {
+		return DeleteLimit{Percent: n}, nil
+	}
+	return DeleteLimit{Count: n}, nil
+}
+
+func (l DeleteLimit) Exceeded(deletions, files int) bool {
+	switch {
+	case l.Count > 0:
+		return deletions > l.Count
+	case l.Percent > 0:
+		return deletions > max(files*l.Percent/100, minDeleteLimit)
+	}
+	return false
+}
+
+// checkDeletes protects the server from an empty or unmounted graph directory.
+func (s graphSyncer) checkDeletes(deleted int) error {
+	if s.config.Sync.ConfirmDeletes || !s.deleteLimit.Exceeded(deleted, len(s.savedGraph.Files)) {
+		return nil
+	}
+	return fmt.Errorf("%w: refusing to delete %d of %d files of %s on the server, confirm with logsync sync --confirm-deletes",
+		ErrTooManyDeletes, deleted, len(s.savedGraph.Files), s.name)
+}
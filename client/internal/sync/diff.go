@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/diff"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"os"
+	"path"
+)
+
+const missingFile = "/dev/null"
+
+// FileDiff compares the version of a file on the server with the local file in the unified diff format.
+// The result is empty, if both versions are equal.
+func FileDiff(conf config.Config, graphPath, fileId string) (string, error) {
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return "", err
+	}
+
+	relPath, err := graph.DecodeFileId(fileId)
+	if err != nil {
+		return "", err
+	}
+
+	remoteContent, remoteFound, err := syncer.remoteContent(fileId)
+	if err != nil {
+		return "", err
+	}
+	localContent, localFound, err := readLocalContent(path.Join(graphPath, relPath))
+	if err != nil {
+		return "", err
+	}
+	if !remoteFound && !localFound {
+		return "", fmt.Errorf("%s exists neither locally nor on the server", relPath)
+	}
+
+	remoteName, localName := "server/"+relPath, "local/"+relPath
+	if !remoteFound {
+		remoteName = missingFile
+	}
+	if !localFound {
+		localName = missingFile
+	}
+	return diff.Unified(remoteName, localName, remoteContent, localContent), nil
+}
+
+func (s graphSyncer) remoteContent(fileId string) ([]byte, bool, error) {
+	remoteId, err := s.remoteId(fileId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	request := remote.NewContentRequest(s.config)
+	content, err := request.Send(s.ctx, s.name, remoteId)
+	if errors.Is(err, remote.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if s.config.Encryption.Enabled {
+		content, err = s.open(content, fileId)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return content, true, nil
+}
+
+func readLocalContent(p string) ([]byte, bool, error) {
+	info, err := os.Lstat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if info.IsDir() {
+		return nil, false, fmt.Errorf("%s is a directory", p)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		return []byte(target), true, err
+	}
+
+	content, err := os.ReadFile(p)
+	return content, true, err
+}
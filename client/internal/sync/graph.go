@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/crypt"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+)
+
+var ErrEncryptionMismatch = errors.New("encryption setting does not match the graph on the server")
+
+func (s graphSyncer) checkGraph() (bool, error) {
+	request := remote.NewGraphRequest(s.config)
+	graph, err := request.Get(s.ctx, s.name)
+	if errors.Is(err, remote.ErrNotFound) {
+		return false, s.checkKey(graph, errors.Is(err, remote.ErrGraphNotFound))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if graph.Encrypted != s.config.Encryption.Enabled {
+		return true, fmt.Errorf("%w: encrypted on the server: %v, encryption.enabled: %v",
+			ErrEncryptionMismatch, graph.Encrypted, s.config.Encryption.Enabled)
+	}
+	if graph.Archived {
+		log.Info("Graph %s is archived, changes can't be uploaded", s.name)
+	}
+	return true, s.checkKey(graph, false)
+}
+
+func (s graphSyncer) checkKey(graph remote.Graph, isNew bool) error {
+	if !s.config.Encryption.Enabled || s.key.key != nil {
+		return nil
+	}
+	return s.loadKey(graph, isNew)
+}
+
+func (s graphSyncer) createGraph() error {
+	log.Info("Creating graph %s on the server", s.name)
+	graph := remote.Graph{
+		Name:      s.name,
+		Encrypted: s.config.Encryption.Enabled,
+	}
+	if s.config.Encryption.Enabled {
+		key, err := s.cryptKey()
+		if err != nil {
+			return err
+		}
+		graph.KeyParams = s.key.params
+		graph.KeyCheck, err = crypt.NewKeyCheck(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	request := remote.NewGraphRequest(s.config)
+	created, err := request.Create(s.ctx, graph)
+	if errors.Is(err, remote.ErrNotFound) {
+		log.Info("Server does not manage graphs, the graph is created by the first upload")
+		s.useLegacyKey()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.config.Encryption.Enabled && created.KeyParams != graph.KeyParams {
+		// older servers don't store the parameters, the other clients couldn't derive the key
+		s.useLegacyKey()
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JoinPolicy decides, which version of a file is kept, when a graph directory with files is synced for the
+// first time and the file has another content than on the server
+type JoinPolicy string
+
+const (
+	// JoinAsk refuses the first sync, until a policy is chosen with logsync join
+	JoinAsk JoinPolicy = "ask"
+	// JoinServer replaces the local files with the version of the server
+	JoinServer JoinPolicy = "server"
+	// JoinLocal uploads the local files as new version of the files on the server
+	JoinLocal JoinPolicy = "local"
+	// JoinKeepBoth replaces the local files with the version of the server and keeps a copy of the local files,
+	// that is uploaded as new file
+	JoinKeepBoth JoinPolicy = "keep-both"
+)
+
+var joinPolicies = []JoinPolicy{JoinAsk, JoinServer, JoinLocal, JoinKeepBoth}
+
+var ErrJoinRequired = errors.New("files of the graph directory differ from the server")
+
+// JoinPolicyByName returns the policy of sync.join
+func JoinPolicyByName(name string) (JoinPolicy, error) {
+	policy := JoinPolicy(name)
+	if !slices.Contains(joinPolicies, policy) {
+		names := make([]string, len(joinPolicies))
+		for i, policy := range joinPolicies {
+			names[i] = string(policy)
+		}
+		return "", fmt.Errorf("unknown join policy %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return policy, nil
+}
+
+type localCopy struct {
+	file graph.File
+	id   string
+}
+
+func (s graphSyncer) firstSync() bool {
+	return s.savedGraph.LastRevision == 0 && len(s.savedGraph.Files) == 0
+}
+
+// joinGraph adopts identical files without transferring them and resolves the others by the join policy.
+// With ask, they stay in the local and remote changes and are reported as unresolved.
+func (s graphSyncer) joinGraph(p *pending) error {
+	latest := make(map[string]change, len(p.remote))
+	for _, change := range p.remote {
+		latest[change.localId] = change
+	}
+	policy := s.joinPolicy
+	if s.mode == ModeMirror {
+		// mirrors discard the local version anyway
+		policy = JoinServer
+	}
+
+	created := make([]graph.File, 0, len(p.local.Created))
+	for _, file := range p.local.Created {
+		change, ok := latest[file.Id]
+		if !ok || change.Operation == "D" {
+			created = append(created, file)
+			continue
+		}
+		same, err := s.sameContent(file, change)
+		if stopsSync(err) {
+			return err
+		}
+		if err != nil {
+			log.Error("Could not compare file with the server", err)
+		}
+
+		switch {
+		case same:
+			log.Info("Adopting %s, it is identical on the server", file.Id)
+			file.Revision = change.Revision
+			s.savedGraph.AddOrUpdateFile(file)
+			p.remote = withoutFile(p.remote, file.Id)
+		case policy == JoinServer:
+			log.Info("Replacing %s with the version of the server", file.Id)
+		case policy == JoinLocal:
+			log.Info("Replacing the version of the server with %s", file.Id)
+			p.remote = withoutFile(p.remote, file.Id)
+			// the upload is based on the revision of the server, a failed upload is retried on the next sync
+			synced := file
+			synced.Revision = change.Revision
+			synced.LastChange = time.Time{}
+			s.savedGraph.AddOrUpdateFile(synced)
+			p.local.Changed = append(p.local.Changed, file)
+		case policy == JoinKeepBoth:
+			if file.Kind != graph.KindFile {
+				log.Info("Replacing %s with the version of the server, only files are copied", file.Id)
+				continue
+			}
+			copyId, err := s.copyId(file.Id)
+			if err != nil {
+				return err
+			}
+			log.Info("Keeping %s as %s", file.Id, copyId)
+			p.copies = append(p.copies, localCopy{file: file, id: copyId})
+		default:
+			created = append(created, file)
+			p.unresolved = append(p.unresolved, file.Id)
+		}
+	}
+	p.local.Created = created
+	return nil
+}
+
+// sameContent downloads the files of encrypted graphs, the hashes of the manifest are hashes of the encrypted content.
+func (s graphSyncer) sameContent(file graph.File, change change) (bool, error) {
+	if file.IsDir() || change.isDir() {
+		return file.IsDir() && change.isDir(), nil
+	}
+	if change.Kind != "" && change.Kind != string(file.Kind) {
+		return false, nil
+	}
+
+	content, err := readContent(file)
+	if err != nil {
+		return false, err
+	}
+	if !s.config.Encryption.Enabled && change.hash != "" {
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:]) == change.hash, nil
+	}
+	remoteContent, err := s.downloadContent(change)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(content, remoteContent), nil
+}
+
+// copyId keeps pages/a.md as pages/a (conflict laptop).md
+func (s graphSyncer) copyId(fileId string) (string, error) {
+	relPath, err := graph.DecodeFileId(fileId)
+	if err != nil {
+		return "", err
+	}
+	device := strings.ReplaceAll(s.config.Device.Name, "/", "-")
+	if device == "" {
+		device = "local"
+	}
+	ext := path.Ext(relPath)
+	return graph.EncodeFileId(fmt.Sprintf("%s (conflict %s)%s", strings.TrimSuffix(relPath, ext), device, ext)), nil
+}
+
+func (s graphSyncer) keepLocalCopies(p *pending) error {
+	for _, copied := range p.copies {
+		content, err := readContent(copied.file)
+		if err != nil {
+			return err
+		}
+		stored, err := graph.StoreFile(s.basePath, copied.id, content, copied.file.Mode, copied.file.LastChange, s.options.Symlinks)
+		if err != nil {
+			return err
+		}
+		if s.uploadsCopy(copied) {
+			p.local.Created = append(p.local.Created, stored)
+		}
+	}
+	return nil
+}
+
+func (s graphSyncer) uploadsCopy(copied localCopy) bool {
+	relPath, err := graph.DecodeFileId(copied.id)
+	return err == nil && s.options.Profile.Includes(relPath, false)
+}
+
+func withoutFile(changes []change, fileId string) []change {
+	return slices.DeleteFunc(changes, func(change change) bool {
+		return change.localId == fileId
+	})
+}
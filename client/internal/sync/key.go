@@ -0,0 +1,205 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/crypt"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+)
+
+type graphKey struct {
+	key crypt.Key
+	// params of the key derivation, empty for graphs using the legacy key
+	params string
+	// kdf derived the key, it is stored in the envelope of the content
+	kdf crypt.KDF
+	// epoch is the number of rotations of the key
+	epoch int64
+	// missingCheck the key was verified, but the graph has no key check yet
+	missingCheck bool
+}
+
+func (s graphSyncer) cryptKey() (crypt.Key, error) {
+	if s.key.key != nil {
+		return s.key.key, nil
+	}
+
+	request := remote.NewGraphRequest(s.config)
+	graph, err := request.Get(s.ctx, s.name)
+	if err != nil && !errors.Is(err, remote.ErrNotFound) {
+		return nil, err
+	}
+	err = s.loadKey(graph, errors.Is(err, remote.ErrGraphNotFound))
+	if err != nil {
+		return nil, err
+	}
+	return s.key.key, nil
+}
+
+func (s graphSyncer) loadKey(graph remote.Graph, isNew bool) error {
+	passphrase := s.config.Encryption.Key
+	if passphrase == "" && graph.KeyParams != crypt.MembersKeyParams {
+		return fmt.Errorf("graph %s is not shared, encryption.key is required", s.name)
+	}
+	if isNew {
+		params, err := crypt.NewKeyParams()
+		if err != nil {
+			return err
+		}
+		s.key.key = crypt.DeriveKey(passphrase, params)
+		s.key.kdf = crypt.KDFArgon2id
+		s.key.params = params.String()
+		return nil
+	}
+
+	s.key.epoch = graph.KeyEpoch
+	switch graph.KeyParams {
+	case "":
+		log.Info("Graph %s uses the legacy key derivation without salt, logsync rotate-key derives the key with argon2id", s.name)
+		s.key.key = crypt.LegacyKey(passphrase)
+		s.key.kdf = crypt.KDFLegacy
+	case crypt.MembersKeyParams:
+		key, err := s.memberKey()
+		if err != nil {
+			return err
+		}
+		s.key.key = key
+		s.key.kdf = crypt.KDFMembers
+		s.key.params = graph.KeyParams
+	default:
+		params, err := crypt.ParseKeyParams(graph.KeyParams)
+		if err != nil {
+			return err
+		}
+		s.key.key = crypt.DeriveKey(passphrase, params)
+		s.key.kdf = crypt.KDFArgon2id
+		s.key.params = graph.KeyParams
+	}
+
+	err := s.verifyKey(graph)
+	rotated := graph.KeyEpoch != s.savedGraph.KeyEpoch && len(s.savedGraph.Files) > 0
+	if err != nil && rotated {
+		return fmt.Errorf("%w to epoch %d since the last sync of %s, set encryption.key to the new passphrase",
+			remote.ErrKeyRotated, graph.KeyEpoch, s.name)
+	}
+	if err != nil {
+		return err
+	}
+	if rotated {
+		log.Info("The key of graph %s was rotated to epoch %d", s.name, graph.KeyEpoch)
+	}
+	s.savedGraph.KeyEpoch = graph.KeyEpoch
+	return nil
+}
+
+func (s graphSyncer) memberKey() (crypt.Key, error) {
+	identity, err := loadIdentity(s.config)
+	if err != nil {
+		return nil, err
+	}
+	member, err := remote.NewMemberRequest(s.config).Get(s.ctx, s.name, identity.PublicKey())
+	if errors.Is(err, remote.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotMember, s.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !member.Accepted {
+		return nil, fmt.Errorf("%w: accept the invitation to %s with logsync members accept", ErrNotMember, s.name)
+	}
+	return identity.UnwrapKey(member.WrappedKey)
+}
+
+// verifyKey decrypts a file of the manifest, when the graph has no key check yet.
+func (s graphSyncer) verifyKey(graph remote.Graph) error {
+	if graph.KeyCheck != "" {
+		err := crypt.VerifyKeyCheck(s.key.key, graph.KeyCheck)
+		if err != nil {
+			s.key.key = nil
+			return fmt.Errorf("%w: %s", err, s.name)
+		}
+		return nil
+	}
+	if graph.Name == "" {
+		// the server does not manage graphs
+		return nil
+	}
+
+	manifest, err := remote.NewManifestRequest(s.config).Send(s.ctx, s.name)
+	if errors.Is(err, remote.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(manifest.Files) > 0 {
+		_, _, err = crypt.DecryptFileId(manifest.Files[0].FileId, s.key.key)
+		if err != nil {
+			s.key.key = nil
+			return fmt.Errorf("%w: %s", crypt.ErrWrongKey, s.name)
+		}
+	}
+	s.key.missingCheck = true
+	return nil
+}
+
+func (s graphSyncer) addKeyCheck() {
+	if !s.key.missingCheck {
+		return
+	}
+	s.key.missingCheck = false
+	check, err := crypt.NewKeyCheck(s.key.key)
+	if err != nil {
+		log.Error("Failed to create the key check", err)
+		return
+	}
+	request := remote.NewGraphRequest(s.config)
+	err = request.Update(s.ctx, s.name, remote.GraphUpdate{KeyCheck: &check})
+	if err != nil && !errors.Is(err, remote.ErrNotFound) {
+		log.Error("Failed to store the key check", err)
+	}
+}
+
+// useLegacyKey is the fallback for servers, that can't store the key parameters, the report warns about the weaker key.
+func (s graphSyncer) useLegacyKey() {
+	if !s.config.Encryption.Enabled {
+		return
+	}
+	warning := fmt.Sprintf("the server does not store key parameters, graph %s is encrypted with the legacy key "+
+		"derivation without salt. Update the server and run logsync rotate-key to derive the key with argon2id", s.name)
+	log.Info("Warning: %s", warning)
+	s.report.Warnings = append(s.report.Warnings, warning)
+	s.key.key = crypt.LegacyKey(s.config.Encryption.Key)
+	s.key.kdf = crypt.KDFLegacy
+	s.key.params = ""
+	// the key check was created with the derived key
+	s.key.missingCheck = true
+}
+
+func (s graphSyncer) seal(content []byte, fileId string) ([]byte, error) {
+	key, err := s.cryptKey()
+	if err != nil {
+		return nil, err
+	}
+	return crypt.Seal(content, key, s.envelope(fileId))
+}
+
+func (s graphSyncer) open(content []byte, fileId string) ([]byte, error) {
+	key, err := s.cryptKey()
+	if err != nil {
+		return nil, err
+	}
+	return crypt.Open(content, key, s.envelope(fileId))
+}
+
+func (s graphSyncer) envelope(fileId string) crypt.Envelope {
+	return crypt.Envelope{
+		KDF:         s.key.kdf,
+		Graph:       s.name,
+		FileId:      fileId,
+		Compression: s.compression,
+		// rotations seal all content, graphs of other kdfs were never encrypted by older versions
+		Legacy: s.key.kdf == crypt.KDFLegacy && s.key.epoch == 0,
+	}
+}
@@ -0,0 +1,264 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/crypt"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrNotMember = errors.New("the identity is not a member of the shared graph")
+	ErrNotShared = errors.New("the graph is not shared")
+)
+
+func loadIdentity(conf config.Config) (crypt.Identity, error) {
+	content, err := os.ReadFile(conf.Encryption.Identity)
+	if errors.Is(err, os.ErrNotExist) {
+		return crypt.Identity{}, fmt.Errorf("no identity at %s, create it with logsync identity", conf.Encryption.Identity)
+	}
+	if err != nil {
+		return crypt.Identity{}, err
+	}
+	return crypt.ParseIdentity(string(content))
+}
+
+// PublicKey returns the public key of the identity of the user, it is empty without identity
+func PublicKey(conf config.Config) string {
+	identity, err := loadIdentity(conf)
+	if err != nil {
+		return ""
+	}
+	return identity.PublicKey()
+}
+
+// CreateIdentity creates the identity of the user in the file, an existing identity is returned as it is
+func CreateIdentity(path string) (crypt.Identity, bool, error) {
+	identity, err := loadIdentity(config.Config{Encryption: config.EncryptionConfig{Identity: path}})
+	if err == nil {
+		return identity, false, nil
+	}
+	if _, statErr := os.Stat(path); statErr == nil {
+		return crypt.Identity{}, false, err
+	}
+
+	identity, err = crypt.NewIdentity()
+	if err != nil {
+		return crypt.Identity{}, false, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return crypt.Identity{}, false, err
+	}
+	err = os.WriteFile(path, []byte(identity.Encode()+"\n"), 0600)
+	if err != nil {
+		return crypt.Identity{}, false, err
+	}
+	return identity, true, nil
+}
+
+// Members returns the members of the shared graph
+func Members(ctx context.Context, conf config.Config, graphPath string) ([]remote.Member, error) {
+	name, err := graph.GetNameByPath(graphPath)
+	if err != nil {
+		return nil, err
+	}
+	return remote.NewMemberRequest(conf).List(ctx, name)
+}
+
+// InviteMember shares the graph with the owner of the public key. A graph encrypted with a passphrase is
+// encrypted with a random data key first, that is wrapped for the identity of the user and the new member.
+// The returned rotation is empty, if the graph was already shared.
+func InviteMember(ctx context.Context, conf config.Config, graphPath, publicKey, name string) (Rotation, error) {
+	syncer, err := openEncryptedGraph(ctx, conf, graphPath)
+	if err != nil {
+		return Rotation{}, err
+	}
+	identity, err := loadIdentity(conf)
+	if err != nil {
+		return Rotation{}, err
+	}
+
+	if syncer.key.params != crypt.MembersKeyParams {
+		log.Info("Converting graph %s to a shared graph", syncer.name)
+		return syncer.rotateMembers(identity, []remote.Member{{PublicKey: publicKey, Name: name}})
+	}
+
+	key, err := syncer.cryptKey()
+	if err != nil {
+		return Rotation{}, err
+	}
+	wrapped, err := crypt.WrapKey(key, publicKey)
+	if err != nil {
+		return Rotation{}, err
+	}
+	request := remote.NewMemberRequest(conf)
+	return Rotation{}, request.Save(ctx, syncer.name, publicKey, remote.MemberUpdate{Name: name, WrappedKey: wrapped})
+}
+
+// AcceptInvitation unwraps the key of the shared graph with the identity of the user and verifies it,
+// the graph is synced afterwards
+func AcceptInvitation(ctx context.Context, conf config.Config, graphPath string) error {
+	name, err := graph.GetNameByPath(graphPath)
+	if err != nil {
+		return err
+	}
+	identity, err := loadIdentity(conf)
+	if err != nil {
+		return err
+	}
+
+	info, err := remote.NewGraphRequest(conf).Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.KeyParams != crypt.MembersKeyParams {
+		return fmt.Errorf("%w: %s", ErrNotShared, name)
+	}
+	request := remote.NewMemberRequest(conf)
+	member, err := request.Get(ctx, name, identity.PublicKey())
+	if errors.Is(err, remote.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotMember, name)
+	}
+	if err != nil {
+		return err
+	}
+	if member.WrappedKey == "" {
+		return fmt.Errorf("the invitation to %s is pending, until the key rotation of the graph is committed", name)
+	}
+
+	key, err := identity.UnwrapKey(member.WrappedKey)
+	if err != nil {
+		return err
+	}
+	err = crypt.VerifyKeyCheck(key, info.KeyCheck)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, name)
+	}
+	return request.Accept(ctx, name, identity.PublicKey())
+}
+
+// RemoveMember removes the member from the shared graph and encrypts the graph with a new data key,
+// that is wrapped for the remaining members, so that the removed member can't decrypt new changes
+func RemoveMember(ctx context.Context, conf config.Config, graphPath, publicKey string) (Rotation, error) {
+	syncer, identity, err := openSharedGraph(ctx, conf, graphPath)
+	if err != nil {
+		return Rotation{}, err
+	}
+	if publicKey == identity.PublicKey() {
+		return Rotation{}, errors.New("the own identity can't be removed, let another member remove it")
+	}
+
+	err = remote.NewMemberRequest(conf).Remove(ctx, syncer.name, publicKey)
+	if err != nil {
+		return Rotation{}, err
+	}
+	return syncer.rotateMembers(identity, nil)
+}
+
+// RotateMembers encrypts the shared graph with a new data key for the current members
+// or resumes an interrupted rotation
+func RotateMembers(ctx context.Context, conf config.Config, graphPath string) (Rotation, error) {
+	syncer, identity, err := openSharedGraph(ctx, conf, graphPath)
+	if err != nil {
+		return Rotation{}, err
+	}
+	return syncer.rotateMembers(identity, nil)
+}
+
+func openSharedGraph(ctx context.Context, conf config.Config, graphPath string) (graphSyncer, crypt.Identity, error) {
+	syncer, err := openEncryptedGraph(ctx, conf, graphPath)
+	if err != nil {
+		return graphSyncer{}, crypt.Identity{}, err
+	}
+	if syncer.key.params != crypt.MembersKeyParams {
+		return graphSyncer{}, crypt.Identity{}, fmt.Errorf("%w: %s", ErrNotShared, syncer.name)
+	}
+	identity, err := loadIdentity(conf)
+	return syncer, identity, err
+}
+
+// rotateMembers wraps the new data key for the user and the members, members without it lose their access with the commit.
+func (s graphSyncer) rotateMembers(identity crypt.Identity, invited []remote.Member) (Rotation, error) {
+	request := remote.NewMemberRequest(s.config)
+	members, err := request.List(s.ctx, s.name)
+	if err != nil {
+		return Rotation{}, err
+	}
+	members = append(members, invited...)
+
+	newKey, rotation, err := s.startMembersRotation(identity)
+	if err != nil {
+		return Rotation{}, err
+	}
+	for _, member := range members {
+		if member.PublicKey == identity.PublicKey() {
+			continue
+		}
+		wrapped, err := crypt.WrapKey(newKey, member.PublicKey)
+		if err != nil {
+			return Rotation{}, err
+		}
+		err = request.Save(s.ctx, s.name, member.PublicKey, remote.MemberUpdate{Name: member.Name, PendingKey: wrapped})
+		if err != nil {
+			return Rotation{}, fmt.Errorf("failed to wrap the key for %s: %w", member.PublicKey, err)
+		}
+	}
+
+	result, err := s.rotate(newKey, crypt.KDFMembers, rotation)
+	if err != nil {
+		return Rotation{}, err
+	}
+	return result, request.Accept(s.ctx, s.name, identity.PublicKey())
+}
+
+// startMembersRotation wraps the new key for the user first, so that an interrupted rotation resumes with the same key.
+func (s graphSyncer) startMembersRotation(identity crypt.Identity) (crypt.Key, remote.Rotation, error) {
+	rotations := remote.NewRotationRequest(s.config)
+	members := remote.NewMemberRequest(s.config)
+	running, err := rotations.Get(s.ctx, s.name)
+	if err != nil && !errors.Is(err, remote.ErrNotFound) {
+		return nil, remote.Rotation{}, err
+	}
+
+	var newKey crypt.Key
+	var check string
+	if err == nil {
+		log.Info("Resuming the key rotation started at %v", running.StartedAt)
+		own, err := members.Get(s.ctx, s.name, identity.PublicKey())
+		if err != nil || own.PendingKey == "" || running.KeyParams != crypt.MembersKeyParams {
+			return nil, remote.Rotation{}, fmt.Errorf("%w: another rotation is running, cancel it first", remote.ErrKeyRotation)
+		}
+		newKey, err = identity.UnwrapKey(own.PendingKey)
+		if err != nil {
+			return nil, remote.Rotation{}, err
+		}
+		check = running.KeyCheck
+	} else {
+		newKey, err = crypt.NewDataKey()
+		if err != nil {
+			return nil, remote.Rotation{}, err
+		}
+		check, err = crypt.NewKeyCheck(newKey)
+		if err != nil {
+			return nil, remote.Rotation{}, err
+		}
+	}
+
+	rotation, err := rotations.Start(s.ctx, s.name, crypt.MembersKeyParams, check)
+	if err != nil {
+		return nil, remote.Rotation{}, err
+	}
+	wrapped, err := crypt.WrapKey(newKey, identity.PublicKey())
+	if err != nil {
+		return nil, remote.Rotation{}, err
+	}
+	err = members.Save(s.ctx, s.name, identity.PublicKey(), remote.MemberUpdate{Name: s.config.Device.Name, PendingKey: wrapped})
+	return newKey, rotation, err
+}
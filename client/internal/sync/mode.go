@@ -0,0 +1,104 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/compare"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"slices"
+	"strings"
+)
+
+// Mode decides, in which directions a graph is synced
+type Mode string
+
+const (
+	// ModeBidirectional downloads the remote changes and uploads the local changes
+	ModeBidirectional Mode = "bidirectional"
+	// ModePullOnly downloads the remote changes, local changes stay pending and are never uploaded
+	ModePullOnly Mode = "pull-only"
+	// ModePushOnly uploads the local changes and skips the changes of other devices
+	ModePushOnly Mode = "push-only"
+	// ModeMirror forces the state of the server onto the local graph, local changes are discarded
+	ModeMirror Mode = "mirror"
+)
+
+var modes = []Mode{ModeBidirectional, ModePullOnly, ModePushOnly, ModeMirror}
+
+// ModeByName returns the mode of sync.mode or sync.graphs
+func ModeByName(name string) (Mode, error) {
+	if name == "" {
+		return ModeBidirectional, nil
+	}
+	mode := Mode(name)
+	if !slices.Contains(modes, mode) {
+		names := make([]string, len(modes))
+		for i, mode := range modes {
+			names[i] = string(mode)
+		}
+		return "", fmt.Errorf("unknown sync mode %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return mode, nil
+}
+
+func (m Mode) downloads() bool {
+	return m != ModePushOnly
+}
+
+func (m Mode) uploads() bool {
+	return m == ModeBidirectional || m == ModePushOnly
+}
+
+// discardLocalChanges only restores the files, that the remote changes didn't replace already.
+func (s graphSyncer) discardLocalChanges(local compare.Result, remoteChanges []change) error {
+	replaced := make(map[string]bool, len(remoteChanges))
+	for _, change := range remoteChanges {
+		replaced[change.localId] = true
+	}
+
+	// directories are restored before their files and removed after them
+	restore := append(slices.Clone(local.Changed), local.Deleted...)
+	slices.SortFunc(restore, func(a, b graph.File) int {
+		return len(a.Id) - len(b.Id)
+	})
+	for _, file := range restore {
+		if replaced[file.Id] {
+			continue
+		}
+		log.Info("Discarding local change of %s", file.Id)
+		err := s.restoreFile(file.Id)
+		if errors.Is(err, ErrNotOnServer) {
+			local.Created = append(local.Created, file)
+			continue
+		}
+		if stopsSync(err) {
+			return err
+		}
+		if err != nil {
+			log.Error("Failed to restore file", err)
+			s.report.Failed = append(s.report.Failed, file.Id)
+			continue
+		}
+		s.report.Downloaded = append(s.report.Downloaded, file.Id)
+	}
+
+	slices.SortFunc(local.Created, func(a, b graph.File) int {
+		return len(b.Id) - len(a.Id)
+	})
+	for _, file := range local.Created {
+		if replaced[file.Id] {
+			continue
+		}
+		log.Info("Removing local file %s, it is not on the server", file.Id)
+		err := graph.RemoveFile(s.basePath, file.Id, s.options.Symlinks)
+		if err != nil {
+			log.Error("Failed to remove file", err)
+			s.report.Failed = append(s.report.Failed, file.Id)
+			continue
+		}
+		s.savedGraph.RemoveFile(file.Id)
+		s.report.Removed = append(s.report.Removed, file.Id)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/compare"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"slices"
+	"time"
+)
+
+// Plan lists the ids of the files, that a sync would transfer
+type Plan struct {
+	Graph    string    `json:"graph"`
+	LastSync time.Time `json:"last_sync"`
+	// Download files are created or changed in the local graph
+	Download []string `json:"download"`
+	// Remove files are removed from the local graph
+	Remove []string `json:"remove"`
+	// Upload files are created or changed on the server
+	Upload []string `json:"upload"`
+	// Delete files are deleted on the server
+	Delete    []string `json:"delete"`
+	Conflicts []string `json:"conflicts"`
+	// Skipped remote changes are not applied, e.g. because of the profile
+	Skipped []string `json:"skipped"`
+	// Invalid remote changes have file ids, that can't be decrypted or decoded
+	Invalid []string `json:"invalid"`
+}
+
+// Empty reports if the sync would not transfer anything
+func (p Plan) Empty() bool {
+	return len(p.Download) == 0 && len(p.Remove) == 0 && len(p.Upload) == 0 && len(p.Delete) == 0
+}
+
+// GetPlan collects the changes like a sync, but neither writes to the graph, the server nor the saved state.
+// The first plan of an encrypted graph downloads the files, that exist locally and on the server, to compare them.
+func GetPlan(conf config.Config, graphPath string) (Plan, error) {
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return Plan{}, err
+	}
+	// joinGraph adopts identical files into the saved state
+	saved := *syncer.savedGraph
+	saved.Files = slices.Clone(saved.Files)
+	syncer.savedGraph = &saved
+	// the own changes are left out by the device id in the config
+	syncer.ctx = remote.WithoutDevice(syncer.ctx)
+
+	p, err := syncer.prepare()
+	if err != nil {
+		return Plan{}, err
+	}
+	if syncer.mode == ModeMirror {
+		p.conflicts = []string{}
+	}
+
+	plan := Plan{
+		Graph:     syncer.name,
+		LastSync:  syncer.savedGraph.LastSync,
+		Download:  make([]string, 0),
+		Remove:    make([]string, 0),
+		Upload:    make([]string, 0),
+		Delete:    make([]string, 0),
+		Conflicts: p.conflicts,
+		Skipped:   make([]string, 0),
+		Invalid:   syncer.report.Failed,
+	}
+
+	// the same rules as in downloadChanges and uploadChanges, every file is listed once
+	for _, change := range orderChanges(p.remote) {
+		if slices.Contains(p.conflicts, change.localId) {
+			continue
+		}
+		if syncer.skipReason(change) != "" {
+			plan.Skipped = appendOnce(plan.Skipped, change.localId)
+			continue
+		}
+		if change.Operation == "D" {
+			plan.Remove = appendOnce(plan.Remove, change.localId)
+		} else {
+			plan.Download = appendOnce(plan.Download, change.localId)
+		}
+	}
+
+	for _, file := range p.local.Created {
+		if !slices.Contains(p.conflicts, file.Id) {
+			plan.Upload = append(plan.Upload, file.Id)
+		}
+	}
+	for _, file := range p.local.Changed {
+		if !slices.Contains(p.conflicts, file.Id) {
+			plan.Upload = append(plan.Upload, file.Id)
+		}
+	}
+	for _, file := range p.local.Deleted {
+		if !slices.Contains(p.conflicts, file.Id) {
+			plan.Delete = append(plan.Delete, file.Id)
+		}
+	}
+	for _, copied := range p.copies {
+		if syncer.uploadsCopy(copied) {
+			plan.Upload = append(plan.Upload, copied.id)
+		}
+	}
+
+	return plan.forMode(syncer.mode, p.local), nil
+}
+
+func (p Plan) forMode(mode Mode, local compare.Result) Plan {
+	if !mode.downloads() {
+		p.Download, p.Remove, p.Skipped = []string{}, []string{}, []string{}
+	}
+	if mode.uploads() {
+		return p
+	}
+	p.Upload, p.Delete = []string{}, []string{}
+	if mode != ModeMirror {
+		return p
+	}
+	// mirrors replace the local changes with the version of the server
+	for _, file := range local.Created {
+		if !slices.Contains(p.Download, file.Id) {
+			p.Remove = appendOnce(p.Remove, file.Id)
+		}
+	}
+	for _, file := range append(slices.Clone(local.Changed), local.Deleted...) {
+		if !slices.Contains(p.Remove, file.Id) {
+			p.Download = appendOnce(p.Download, file.Id)
+		}
+	}
+	return p
+}
+
+func appendOnce(ids []string, id string) []string {
+	if slices.Contains(ids, id) {
+		return ids
+	}
+	return append(ids, id)
+}
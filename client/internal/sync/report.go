@@ -0,0 +1,31 @@
+package sync
+
+// Report lists the ids of the files, that were transferred during a sync
+type Report struct {
+	Graph string `json:"graph"`
+	// Downloaded files were created or changed in the local graph
+	Downloaded []string `json:"downloaded"`
+	// Removed files were removed from the local graph
+	Removed []string `json:"removed"`
+	// Uploaded files were created or changed on the server
+	Uploaded []string `json:"uploaded"`
+	// Deleted files were deleted on the server
+	Deleted   []string `json:"deleted"`
+	Conflicts []string `json:"conflicts"`
+	Failed    []string `json:"failed"`
+	// Warnings need an action of the user, although the sync succeeded
+	Warnings []string `json:"warnings"`
+}
+
+func newReport(graphName string) *Report {
+	return &Report{
+		Graph:      graphName,
+		Downloaded: make([]string, 0),
+		Removed:    make([]string, 0),
+		Uploaded:   make([]string, 0),
+		Deleted:    make([]string, 0),
+		Conflicts:  make([]string, 0),
+		Failed:     make([]string, 0),
+		Warnings:   make([]string, 0),
+	}
+}
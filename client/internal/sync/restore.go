@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+)
+
+var ErrNotOnServer = errors.New("file does not exist on the server")
+
+// History returns the latest changes of a file on the server, newest first.
+// The file ids of the entries are the local ids.
+func History(conf config.Config, graphPath, fileId string, limit int) ([]remote.ChangeLogEntry, error) {
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := syncer.fetchHistory(fileId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]remote.ChangeLogEntry, len(changes))
+	for i, change := range changes {
+		entries[i] = change.ChangeLogEntry
+		entries[i].FileId = change.localId
+	}
+	return entries, nil
+}
+
+// Restore overwrites the local files with the version of the server. Local changes to those files are lost.
+func Restore(conf config.Config, graphPath string, fileIds []string) (Report, error) {
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var errs []error
+	for _, fileId := range fileIds {
+		err := syncer.restoreFile(fileId)
+		if err != nil {
+			syncer.report.Failed = append(syncer.report.Failed, fileId)
+			errs = append(errs, fmt.Errorf("%s: %w", fileId, err))
+			continue
+		}
+		syncer.report.Downloaded = append(syncer.report.Downloaded, fileId)
+	}
+
+	err = syncer.saveFiles()
+	if err != nil {
+		return *syncer.report, err
+	}
+	return *syncer.report, errors.Join(errs...)
+}
+
+func (s graphSyncer) restoreFile(fileId string) error {
+	changes, err := s.fetchHistory(fileId, 1)
+	if err != nil {
+		return err
+	}
+
+	// the latest change contains the kind and metadata of the current version
+	if len(changes) == 0 || changes[0].Operation == "D" {
+		return ErrNotOnServer
+	}
+
+	err = s.downloadFile(changes[0])
+	if err != nil || !changes[0].isDir() {
+		return err
+	}
+	return s.storeDirMetadata(changes[:1])
+}
+
+func (s graphSyncer) fetchHistory(fileId string, limit int) ([]change, error) {
+	remoteId, err := s.remoteId(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	request := remote.NewHistoryRequest(s.config)
+	entries, err := request.Send(s.ctx, s.name, remoteId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]change, 0, len(entries))
+	for _, entry := range entries {
+		localId, relPath, err := s.localId(entry.FileId)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change{
+			ChangeLogEntry: entry,
+			localId:        localId,
+			relPath:        relPath,
+		})
+	}
+	return changes, nil
+}
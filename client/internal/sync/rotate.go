@@ -0,0 +1,259 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/crypt"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"time"
+)
+
+var ErrNotEncrypted = errors.New("encryption is not enabled")
+
+// Rotation is the result of a key rotation
+type Rotation struct {
+	Graph string `json:"graph"`
+	// Epoch is the number of rotations of the key after the rotation
+	Epoch int64 `json:"epoch"`
+	// Files were encrypted with the new key by this run, resumed rotations count only the remaining files
+	Files int `json:"files"`
+}
+
+// RotateKey encrypts all files and file ids of the graph with the key derived from the new passphrase.
+// The files are staged on the server and replace the files of the graph at once, when all are rotated.
+// An interrupted rotation is resumed with the same passphrase. Other clients can't change the graph during the
+// rotation and refuse to sync afterwards, until they use the new passphrase.
+// The members of a shared graph lose their access, the graph is encrypted with the passphrase again.
+func RotateKey(ctx context.Context, conf config.Config, graphPath string, passphrase string) (Rotation, error) {
+	syncer, err := openEncryptedGraph(ctx, conf, graphPath)
+	if err != nil {
+		return Rotation{}, err
+	}
+
+	newKey, rotation, err := syncer.startRotation(passphrase)
+	if err != nil {
+		return Rotation{}, err
+	}
+	return syncer.rotate(newKey, crypt.KDFArgon2id, rotation)
+}
+
+func openEncryptedGraph(ctx context.Context, conf config.Config, graphPath string) (graphSyncer, error) {
+	if !conf.Encryption.Enabled {
+		return graphSyncer{}, ErrNotEncrypted
+	}
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return graphSyncer{}, err
+	}
+	syncer.ctx = ctx
+
+	exists, err := syncer.checkGraph()
+	if err != nil {
+		return graphSyncer{}, err
+	}
+	if !exists {
+		return graphSyncer{}, fmt.Errorf("graph %s is not registered on the server", syncer.name)
+	}
+	return syncer, nil
+}
+
+func (s graphSyncer) rotate(newKey crypt.Key, kdf crypt.KDF, rotation remote.Rotation) (Rotation, error) {
+	log.Info("Rotating %d files of graph %s", len(rotation.Pending), s.name)
+
+	request := remote.NewRotationRequest(s.config)
+	for _, file := range rotation.Pending {
+		err := s.rotateFile(request, file, newKey, kdf)
+		if err != nil {
+			return Rotation{}, fmt.Errorf("failed to rotate %s: %w", file.FileId, err)
+		}
+	}
+
+	committed, err := request.Commit(s.ctx, s.name)
+	if err != nil {
+		return Rotation{}, err
+	}
+	log.Info("Rotated the key of graph %s to epoch %d", s.name, committed.KeyEpoch)
+
+	// the local state has no encrypted ids, it stays valid with the new key
+	s.savedGraph.KeyEpoch = committed.KeyEpoch
+	err = s.saveFiles()
+	if err != nil {
+		return Rotation{}, err
+	}
+	return Rotation{Graph: s.name, Epoch: committed.KeyEpoch, Files: len(rotation.Pending)}, nil
+}
+
+func (s graphSyncer) startRotation(passphrase string) (crypt.Key, remote.Rotation, error) {
+	request := remote.NewRotationRequest(s.config)
+	running, err := request.Get(s.ctx, s.name)
+	if err != nil && !errors.Is(err, remote.ErrNotFound) {
+		return nil, remote.Rotation{}, err
+	}
+
+	if running.KeyParams == crypt.MembersKeyParams {
+		return nil, remote.Rotation{}, fmt.Errorf("%w: a rotation of the members key is running, resume it with logsync members rotate", remote.ErrKeyRotation)
+	}
+
+	var params crypt.KeyParams
+	if err == nil {
+		log.Info("Resuming the key rotation started at %v", running.StartedAt)
+		params, err = crypt.ParseKeyParams(running.KeyParams)
+	} else {
+		params, err = crypt.NewKeyParams()
+	}
+	if err != nil {
+		return nil, remote.Rotation{}, err
+	}
+
+	key := crypt.DeriveKey(passphrase, params)
+	check, err := crypt.NewKeyCheck(key)
+	if err != nil {
+		return nil, remote.Rotation{}, err
+	}
+	if running.KeyCheck != "" && crypt.VerifyKeyCheck(key, running.KeyCheck) != nil {
+		return nil, remote.Rotation{}, fmt.Errorf("%w: a rotation to another passphrase is running, cancel it first", remote.ErrKeyRotation)
+	}
+	if running.KeyCheck != "" {
+		// the server resumes the rotation with the same key check
+		check = running.KeyCheck
+	}
+
+	rotation, err := request.Start(s.ctx, s.name, params.String(), check)
+	return key, rotation, err
+}
+
+func (s graphSyncer) rotateFile(request remote.RotationRequest, file remote.PendingFile, newKey crypt.Key, kdf crypt.KDF) error {
+	oldKey, err := s.cryptKey()
+	if err != nil {
+		return err
+	}
+	fileId, legacy, err := crypt.DecryptFileId(file.FileId, oldKey)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		fileId = graph.LegacyFileId(fileId)
+	}
+	newFileId, err := crypt.EncryptFileId(fileId, newKey)
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	if file.Content {
+		encrypted, err := remote.NewContentRequest(s.config).Send(s.ctx, s.name, file.FileId)
+		if err != nil {
+			return err
+		}
+		plain, err := crypt.Open(encrypted, oldKey, s.envelope(fileId))
+		if err != nil {
+			return err
+		}
+		content, err = crypt.Seal(plain, newKey, crypt.Envelope{
+			KDF:         kdf,
+			Graph:       s.name,
+			FileId:      fileId,
+			Compression: s.compression,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return request.RotateFile(s.ctx, s.name, file.FileId, newFileId, content)
+}
+
+// rekeyLegacyIds replaces the ids, that older versions encrypted with random nonces, by a rotation to the same key.
+// Changes of those files would get new ids otherwise and never replace or delete them on the server.
+func (s graphSyncer) rekeyLegacyIds() error {
+	if !s.config.Encryption.Enabled || !s.mode.uploads() || s.savedGraph.LegacyIdsChecked {
+		return nil
+	}
+	exists, err := s.checkGraph()
+	if err != nil {
+		return err
+	}
+	if !exists || s.key.kdf == crypt.KDFMembers {
+		s.savedGraph.LegacyIdsChecked = true
+		return nil
+	}
+
+	legacy, err := s.hasLegacyIds()
+	if err != nil {
+		return err
+	}
+	if !legacy {
+		s.savedGraph.LegacyIdsChecked = true
+		return nil
+	}
+
+	log.Info("Replacing the file ids of graph %s, that were encrypted by an older version", s.name)
+	rotation, err := s.startRekey()
+	if errors.Is(err, remote.ErrNotFound) {
+		log.Info("The server can't rotate keys, the file ids of graph %s are kept", s.name)
+		s.savedGraph.LegacyIdsChecked = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rotated, err := s.rotate(s.key.key, s.key.kdf, rotation)
+	if err != nil {
+		return err
+	}
+	// the changes of this sync are encrypted with the same key in the new epoch
+	s.key.epoch = rotated.Epoch
+	s.key.missingCheck = false
+	s.savedGraph.LegacyIdsChecked = true
+	return nil
+}
+
+func (s graphSyncer) hasLegacyIds() (bool, error) {
+	changes, err := remote.NewChangesRequest(s.config).Send(s.ctx, s.name, time.Time{}, 0)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range changes.Entries {
+		_, legacy, err := crypt.DecryptFileId(entry.FileId, s.key.key)
+		if err != nil {
+			log.Error("Skipping change with invalid file id", err)
+			continue
+		}
+		if legacy {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s graphSyncer) startRekey() (remote.Rotation, error) {
+	request := remote.NewRotationRequest(s.config)
+	running, err := request.Get(s.ctx, s.name)
+	if err != nil && !errors.Is(err, remote.ErrNotFound) {
+		return remote.Rotation{}, err
+	}
+
+	check := running.KeyCheck
+	if err == nil && (running.KeyParams != s.key.params || crypt.VerifyKeyCheck(s.key.key, check) != nil) {
+		return remote.Rotation{}, fmt.Errorf("%w: finish or cancel it with logsync rotate-key", remote.ErrKeyRotation)
+	}
+	if check == "" {
+		check, err = crypt.NewKeyCheck(s.key.key)
+		if err != nil {
+			return remote.Rotation{}, err
+		}
+	}
+	return request.Start(s.ctx, s.name, s.key.params, check)
+}
+
+// CancelRotation discards a running rotation of the key of the graph
+func CancelRotation(ctx context.Context, conf config.Config, graphPath string) error {
+	name, err := graph.GetNameByPath(graphPath)
+	if err != nil {
+		return err
+	}
+	return remote.NewRotationRequest(conf).Cancel(ctx, name)
+}
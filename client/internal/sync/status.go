@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"time"
+)
+
+// Status describes the changes of a graph since the last successful sync
+type Status struct {
+	Graph    string    `json:"graph"`
+	Path     string    `json:"path"`
+	LastSync time.Time `json:"last_sync"`
+	Mode     Mode      `json:"mode"`
+	// Created, Changed and Deleted are the local changes, that are not uploaded yet
+	Created []string `json:"created"`
+	Changed []string `json:"changed"`
+	Deleted []string `json:"deleted"`
+	// Remote are the files changed on the server, that are not downloaded yet
+	Remote    []string `json:"remote"`
+	Conflicts []string `json:"conflicts"`
+	Reachable bool     `json:"reachable"`
+	// ServerError is the reason, why the server could not be reached
+	ServerError string `json:"server_error,omitempty"`
+}
+
+// InSync reports if there are no changes to upload or download
+func (s Status) InSync() bool {
+	return s.Reachable && !s.HasLocalChanges() && len(s.Remote) == 0 && len(s.Conflicts) == 0
+}
+
+func (s Status) HasLocalChanges() bool {
+	return len(s.Created) > 0 || len(s.Changed) > 0 || len(s.Deleted) > 0
+}
+
+// GetStatus compares the graph in graphPath with the state of the last sync and requests the remote changes.
+// An unreachable server is reported in the status, the local changes are listed anyway.
+func GetStatus(conf config.Config, graphPath string) (Status, error) {
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return Status{}, err
+	}
+	syncer.ctx = remote.WithoutDevice(syncer.ctx)
+
+	localChanges, err := syncer.getLocalChanges()
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{
+		Graph:     syncer.name,
+		Path:      graphPath,
+		LastSync:  syncer.savedGraph.LastSync,
+		Mode:      syncer.mode,
+		Created:   fileIds(localChanges.Created),
+		Changed:   fileIds(localChanges.Changed),
+		Deleted:   fileIds(localChanges.Deleted),
+		Remote:    make([]string, 0),
+		Conflicts: make([]string, 0),
+	}
+
+	remoteChanges, _, err := syncer.fetchRemoteChanges()
+	if err != nil {
+		status.ServerError = err.Error()
+		return status, nil
+	}
+	status.Reachable = true
+
+	for _, change := range remoteChanges {
+		if syncer.skipReason(change) == "" {
+			status.Remote = appendOnce(status.Remote, change.localId)
+		}
+	}
+	status.Conflicts = checkForConflicts(remoteChanges, localChanges)
+	return status, nil
+}
+
+// Conflicts returns the ids of the files, that were changed locally and on the server since the last sync
+func Conflicts(conf config.Config, graphPath string) ([]string, error) {
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return nil, err
+	}
+	syncer.ctx = remote.WithoutDevice(syncer.ctx)
+
+	p, err := syncer.prepare()
+	if err != nil {
+		return nil, err
+	}
+	return p.conflicts, nil
+}
+
+func fileIds(files []graph.File) []string {
+	ids := make([]string, len(files))
+	for i, file := range files {
+		ids[i] = file.Id
+	}
+	return ids
+}
@@ -1,6 +1,9 @@
 package sync
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/soerenchrist/logsync/client/internal/compare"
 	"github.com/soerenchrist/logsync/client/internal/config"
@@ -15,11 +18,33 @@ import (
 )
 
 type graphSyncer struct {
+	// ctx cancels the requests to the server
+	ctx         context.Context
 	config      config.Config
 	savedGraph  *graph.Graph
 	basePath    string
 	transaction string
 	name        string
+	options     graph.ReadOptions
+	report      *Report
+	key         *graphKey
+	// compression of the content before it is encrypted
+	compression crypt.Compression
+	mode        Mode
+	joinPolicy  JoinPolicy
+	deleteLimit DeleteLimit
+}
+
+type change struct {
+	remote.ChangeLogEntry
+	localId string
+	relPath string
+	// hash of the content in the manifest, changes have none
+	hash string
+}
+
+func (c change) isDir() bool {
+	return c.Kind == string(graph.KindDir)
 }
 
 func newSyncer(graphPath string, conf config.Config) (graphSyncer, error) {
@@ -27,8 +52,33 @@ func newSyncer(graphPath string, conf config.Config) (graphSyncer, error) {
 	if err != nil {
 		return graphSyncer{}, err
 	}
+	profile, err := graph.ProfileByName(conf.Sync.Profile)
+	if err != nil {
+		return graphSyncer{}, err
+	}
+	symlinks, err := graph.SymlinkPolicyByName(conf.Sync.Symlinks)
+	if err != nil {
+		return graphSyncer{}, err
+	}
+	compression, err := crypt.CompressionByName(conf.Sync.Compression)
+	if err != nil {
+		return graphSyncer{}, err
+	}
+	mode, err := ModeByName(conf.Sync.ModeOf(graphPath))
+	if err != nil {
+		return graphSyncer{}, err
+	}
+	joinPolicy, err := JoinPolicyByName(conf.Sync.Join)
+	if err != nil {
+		return graphSyncer{}, err
+	}
+	deleteLimit, err := ParseDeleteLimit(conf.Sync.DeleteLimit)
+	if err != nil {
+		return graphSyncer{}, err
+	}
 	transaction, _ := uuid.NewUUID()
 	log.Info("Graph name: %s", name)
+	conf.Device.Id = DeviceId(conf)
 
 	loadFilePath, err := getLoadFilePath(name)
 	if err != nil {
@@ -39,154 +89,530 @@ func newSyncer(graphPath string, conf config.Config) (graphSyncer, error) {
 		return graphSyncer{}, err
 	}
 	return graphSyncer{
+		ctx:         context.Background(),
 		config:      conf,
 		transaction: transaction.String(),
 		basePath:    graphPath,
 		savedGraph:  &savedGraph,
 		name:        name,
+		options: graph.ReadOptions{
+			Profile:  profile,
+			Symlinks: symlinks,
+		},
+		report:      newReport(name),
+		key:         &graphKey{},
+		compression: compression,
+		mode:        mode,
+		joinPolicy:  joinPolicy,
+		deleteLimit: deleteLimit,
 	}, nil
 }
 
-func Start(conf config.Config) {
+// Start syncs the graphs once or periodically, until the context is canceled
+func Start(ctx context.Context, conf config.Config) {
 	if conf.Sync.Once {
 		log.Info("Syncing graphs once")
-		syncGraphs(conf)
+		syncGraphs(ctx, conf)
 		return
 	}
 
-	ticker := time.Tick(time.Duration(conf.Sync.Interval) * time.Second)
-	for range ticker {
+	ticker := time.NewTicker(time.Duration(conf.Sync.Interval) * time.Second)
+	defer ticker.Stop()
+	syncs := 0
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopped syncing graphs")
+			return
+		case <-ticker.C:
+		}
+
 		log.Info("Starting sync of graphs")
-		syncGraphs(conf)
+		syncGraphs(ctx, conf)
+		syncs++
+		if conf.Sync.Verify > 0 && syncs%conf.Sync.Verify == 0 && ctx.Err() == nil {
+			verifyGraphs(conf)
+		}
 	}
 }
 
-func syncGraphs(conf config.Config) {
+// verifyGraphs skips graphs, that don't download, the repair would download the version of the server.
+func verifyGraphs(conf config.Config) {
 	for _, graphPath := range conf.Sync.Graphs {
-		syncer, err := newSyncer(graphPath, conf)
+		mode, err := ModeByName(conf.Sync.ModeOf(graphPath))
+		if err != nil || !mode.downloads() {
+			continue
+		}
+		drift, err := Verify(conf, graphPath, true)
 		if err != nil {
-			log.Error("Could not create syncer", err)
+			log.Error("Failed to verify", err)
 			continue
 		}
-		err = syncer.syncGraph()
+		if !drift.None() {
+			log.Info("Repaired drift of %s: %d missing, %d extra, %d mismatched files",
+				drift.Graph, len(drift.Missing), len(drift.Extra), len(drift.Mismatched))
+		}
+	}
+}
+
+func syncGraphs(ctx context.Context, conf config.Config) {
+	for _, graphPath := range conf.Sync.Graphs {
+		if ctx.Err() != nil {
+			return
+		}
+		_, err := syncOnce(ctx, conf, graphPath)
+		if errors.Is(err, remote.ErrUnauthorized) {
+			// the other graphs are synced with the same api token
+			log.Error("Stopping the sync of all graphs", err)
+			return
+		}
 		if err != nil {
 			log.Error("Failed to sync", err)
 		}
 	}
 }
 
-func (s graphSyncer) syncGraph() error {
+// Graph syncs the graph in graphPath once and reports what was done
+func Graph(conf config.Config, graphPath string) (Report, error) {
+	return syncOnce(context.Background(), conf, graphPath)
+}
+
+func syncOnce(ctx context.Context, conf config.Config, graphPath string) (Report, error) {
+	conf, err := ensureDeviceId(conf)
+	if err != nil {
+		log.Error("Could not create device id", err)
+		return Report{}, err
+	}
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		log.Error("Could not create syncer", err)
+		return Report{}, err
+	}
+	syncer.ctx = ctx
+
+	err = syncer.syncGraph()
+	return *syncer.report, err
+}
+
+type pending struct {
+	remote    []change
+	local     compare.Result
+	conflicts []string
+	// graphExists is false, when the graph is not registered on the server yet
+	graphExists bool
+	// revision of the server, that includes the remote changes
+	revision int64
+	// unresolved files differ from the server on the first sync and the join policy is ask
+	unresolved []string
+	// copies of local files, that the join policy keep-both creates
+	copies []localCopy
+}
+
+func (s graphSyncer) prepare() (pending, error) {
 	log.Info("Last sync was %v", s.savedGraph.LastSync)
 
-	changesRequest := remote.NewChangesRequest(s.config)
-	remoteChanges, err := changesRequest.Send(s.name, s.savedGraph.LastSync)
+	graphExists, err := s.checkGraph()
 	if err != nil {
-		return err
+		return pending{}, err
 	}
-	log.Info("Found %d remote changes", len(remoteChanges))
 
-	readGraph, err := graph.ReadGraph(s.basePath)
+	remoteChanges, revision, err := s.fetchRemoteChanges()
 	if err != nil {
-		return err
+		return pending{}, err
 	}
+	log.Info("Found %d remote changes", len(remoteChanges))
 
-	localChanges, err := s.getLocalChanges(readGraph)
+	localChanges, err := s.getLocalChanges()
 	if err != nil {
-		return err
+		return pending{}, err
+	}
+
+	p := pending{
+		remote:      remoteChanges,
+		local:       localChanges,
+		graphExists: graphExists,
+		revision:    revision,
+	}
+	if graphExists && s.firstSync() && len(localChanges.Created) > 0 {
+		err = s.joinGraph(&p)
+		if err != nil {
+			return pending{}, err
+		}
 	}
 
 	// TODO: handle conflicts
-	conflicts := checkForConflicts(remoteChanges, localChanges)
-	log.Info("Found %d conflicts", len(conflicts))
+	p.conflicts = checkForConflicts(p.remote, p.local)
+	log.Info("Found %d conflicts", len(p.conflicts))
+	return p, nil
+}
 
-	err = s.downloadChanges(remoteChanges, conflicts)
+func (s graphSyncer) syncGraph() error {
+	err := s.rekeyLegacyIds()
 	if err != nil {
 		return err
 	}
-	err = s.uploadChanges(localChanges, conflicts)
+	p, err := s.prepare()
 	if err != nil {
 		return err
 	}
-
-	savePath, err := getLoadFilePath(readGraph.Name)
+	if s.mode == ModeMirror {
+		// the server wins every conflict
+		p.conflicts = []string{}
+	}
+	s.report.Conflicts = p.conflicts
+	if len(p.unresolved) > 0 {
+		return fmt.Errorf("%w: %d files of %s, choose the version to keep with logsync join --policy server, local or keep-both",
+			ErrJoinRequired, len(p.unresolved), s.name)
+	}
+	if s.mode.uploads() {
+		err = s.checkDeletes(len(p.local.Deleted))
+		if err != nil {
+			return err
+		}
+	}
+	err = s.keepLocalCopies(&p)
 	if err != nil {
 		return err
 	}
 
-	s.savedGraph.LastSync = time.Now()
-	err = graph.SaveGraphToFile(*s.savedGraph, savePath)
+	if !p.graphExists {
+		if !s.mode.uploads() {
+			return fmt.Errorf("graph %s does not exist on the server, %s graphs are not created", s.name, s.mode)
+		}
+		err = s.createGraph()
+		if err != nil {
+			return err
+		}
+	}
+	s.addKeyCheck()
+
+	// push-only graphs skip the remote changes, uploads of files changed remotely are rejected as conflicts
+	revision := p.revision
+	if s.mode.downloads() {
+		err = s.downloadChanges(p.remote, p.conflicts)
+		if err != nil {
+			return err
+		}
+		revision = p.cursor(s.report.Failed)
+	}
+
+	var uploaded []int64
+	switch {
+	case s.mode.uploads():
+		uploaded, err = s.uploadChanges(p.local, p.conflicts)
+	case s.mode == ModeMirror:
+		err = s.discardLocalChanges(p.local, p.remote)
+	default:
+		log.Info("Keeping %d local changes of the %s graph %s", len(p.local.Created)+len(p.local.Changed)+len(p.local.Deleted), s.mode, s.name)
+	}
 	if err != nil {
 		return err
 	}
 
+	revision = advance(revision, uploaded)
+	err = s.save(revision)
+	if err != nil {
+		return err
+	}
+	s.acknowledge(revision)
 	return nil
 }
 
-func (s graphSyncer) deleteFile(file graph.File) error {
-	var fileId string
-	var err error
-	if s.config.Encryption.Enabled {
-		log.Info("Encrypting filename")
-		fileId, err = crypt.EncryptString(file.Id, s.config.Encryption.Key)
-		if err != nil {
-			return err
+// acknowledge doesn't fail the sync, the server only keeps the deletions longer.
+func (s graphSyncer) acknowledge(revision int64) {
+	if revision == 0 || s.config.Device.Id == "" {
+		return
+	}
+	err := remote.NewAckRequest(s.config).Send(s.ctx, s.name, revision)
+	if err != nil {
+		log.Error("Could not acknowledge revision", err)
+	}
+}
+
+// cursor returns the revision, that the next sync continues after.
+// Remote changes, that were not applied because of conflicts or failures, are fetched again.
+func (p pending) cursor(failed []string) int64 {
+	cursor := p.revision
+	for _, change := range p.remote {
+		if change.Revision == 0 {
+			continue
+		}
+		if slices.Contains(p.conflicts, change.localId) || slices.Contains(failed, change.localId) {
+			cursor = min(cursor, change.Revision-1)
 		}
-	} else {
-		fileId = file.Id
 	}
+	return cursor
+}
 
-	request := remote.NewDeleteRequest(s.config, s.name, s.transaction)
-	return request.Send(fileId, file.LastChange)
+// advance moves the cursor past the own uploads, as long as no other client changed the graph in between
+func advance(cursor int64, uploaded []int64) int64 {
+	if cursor == 0 {
+		return cursor
+	}
+	sorted := slices.Clone(uploaded)
+	slices.Sort(sorted)
+	for _, revision := range sorted {
+		if revision == cursor+1 {
+			cursor = revision
+		}
+	}
+	return cursor
 }
 
-func (s graphSyncer) uploadFile(file graph.File, operation string) error {
-	request := remote.NewUploadRequest(s.config, s.name, s.transaction, operation)
+func (s graphSyncer) save(revision int64) error {
+	s.savedGraph.LastSync = time.Now()
+	s.savedGraph.LastRevision = revision
+	return s.saveFiles()
+}
 
-	f, err := os.Open(file.Path)
+// saveFiles keeps the time of the last sync, so the next sync still fetches the changes since then.
+func (s graphSyncer) saveFiles() error {
+	savePath, err := getLoadFilePath(s.name)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	contents, err := io.ReadAll(f)
+	err = ensureLoadFileDir(savePath)
 	if err != nil {
 		return err
 	}
 
-	var body []byte
-	var fileId string
+	return graph.SaveGraphToFile(*s.savedGraph, savePath)
+}
+
+func (s graphSyncer) fetchRemoteChanges() ([]change, int64, error) {
+	if s.firstSync() {
+		manifest, err := remote.NewManifestRequest(s.config).Send(s.ctx, s.name)
+		if err == nil {
+			log.Info("Cloning %d files of revision %d", len(manifest.Files), manifest.Revision)
+			return withHashes(s.toChanges(manifestEntries(manifest)), manifest), manifest.Revision, nil
+		}
+		// older servers don't provide the manifest
+		if !errors.Is(err, remote.ErrNotFound) {
+			return nil, 0, err
+		}
+	}
+
+	changesRequest := remote.NewChangesRequest(s.config)
+	changes, err := changesRequest.Send(s.ctx, s.name, s.savedGraph.LastSync, s.savedGraph.LastRevision)
+	if errors.Is(err, remote.ErrNotFound) {
+		// the graph does not exist on the server yet
+		return []change{}, 0, nil
+	}
+	if errors.Is(err, remote.ErrResyncRequired) {
+		return s.resyncChanges()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return s.toChanges(s.withoutOwnChanges(changes.Entries)), changes.Revision, nil
+}
+
+// withoutOwnChanges skips the uploads of this device, servers, that track devices, leave them out already.
+func (s graphSyncer) withoutOwnChanges(entries []remote.ChangeLogEntry) []remote.ChangeLogEntry {
+	if s.config.Device.Id == "" {
+		return entries
+	}
+	return slices.DeleteFunc(entries, func(entry remote.ChangeLogEntry) bool {
+		return entry.DeviceId == s.config.Device.Id
+	})
+}
+
+// resyncChanges derives the changes from the manifest, when the server compacted them: files with a newer
+// revision were changed, synced files missing in the manifest were deleted.
+func (s graphSyncer) resyncChanges() ([]change, int64, error) {
+	manifest, err := remote.NewManifestRequest(s.config).Send(s.ctx, s.name)
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Info("Resyncing with revision %d", manifest.Revision)
+
+	changes := make([]change, 0)
+	onServer := make([]string, 0, len(manifest.Files))
+	for _, change := range s.toChanges(manifestEntries(manifest)) {
+		onServer = append(onServer, change.localId)
+		if change.Revision > s.savedGraph.LastRevision {
+			changes = append(changes, change)
+		}
+	}
+	for _, file := range s.savedGraph.Files {
+		if slices.Contains(onServer, file.Id) {
+			continue
+		}
+		remoteId, err := s.remoteId(file.Id)
+		if err != nil {
+			return nil, 0, err
+		}
+		relPath, err := graph.DecodeFileId(file.Id)
+		if err != nil {
+			return nil, 0, err
+		}
+		changes = append(changes, change{
+			ChangeLogEntry: remote.ChangeLogEntry{
+				GraphName: s.name,
+				FileId:    remoteId,
+				Timestamp: file.LastChange,
+				Operation: "D",
+				Kind:      string(file.Kind),
+			},
+			localId: file.Id,
+			relPath: relPath,
+		})
+	}
+	return changes, manifest.Revision, nil
+}
+
+func (s graphSyncer) toChanges(entries []remote.ChangeLogEntry) []change {
+	changes := make([]change, 0, len(entries))
+	for _, entry := range entries {
+		fileId, relPath, err := s.localId(entry.FileId)
+		if err != nil {
+			log.Error("Skipping change with invalid file id", err)
+			s.report.Failed = append(s.report.Failed, entry.FileId)
+			continue
+		}
+		changes = append(changes, change{
+			ChangeLogEntry: entry,
+			localId:        fileId,
+			relPath:        relPath,
+		})
+	}
+	return changes
+}
+
+func (s graphSyncer) remoteId(fileId string) (string, error) {
+	if !s.config.Encryption.Enabled {
+		return fileId, nil
+	}
+
+	key, err := s.cryptKey()
+	if err != nil {
+		return "", err
+	}
+	log.Info("Encrypting filename")
+	return crypt.EncryptFileId(fileId, key)
+}
+
+func (s graphSyncer) localId(remoteId string) (string, string, error) {
+	fileId := remoteId
 	if s.config.Encryption.Enabled {
-		log.Info("Encrypting content")
-		body, err = crypt.Encrypt(contents, s.config.Encryption.Key)
+		key, err := s.cryptKey()
 		if err != nil {
-			return err
+			return "", "", err
 		}
-		fileId, err = crypt.EncryptString(file.Id, s.config.Encryption.Key)
+		decrypted, legacy, err := crypt.DecryptFileId(remoteId, key)
 		if err != nil {
-			return err
+			return "", "", err
 		}
-	} else {
-		body = contents
-		fileId = file.Id
+		fileId = decrypted
+		if legacy {
+			fileId = graph.LegacyFileId(decrypted)
+		}
+	}
+
+	relPath, err := graph.DecodeFileId(fileId)
+	if err != nil {
+		return "", "", err
+	}
+	return fileId, relPath, nil
+}
+
+func (s graphSyncer) deleteFile(file graph.File) (int64, error) {
+	fileId, err := s.remoteId(file.Id)
+	if err != nil {
+		return 0, err
 	}
 
-	return request.Send(fileId, file.LastChange, body)
+	request := remote.NewDeleteRequest(s.config, s.name, s.transaction, s.key.epoch)
+	return request.Send(s.ctx, fileId, file.LastChange, string(file.Kind), s.baseRevision(file.Id))
 }
 
-func (s graphSyncer) uploadChanges(changes compare.Result, conflicts []string) error {
+func (s graphSyncer) baseRevision(fileId string) int64 {
+	index := slices.IndexFunc(s.savedGraph.Files, func(file graph.File) bool {
+		return file.Id == fileId
+	})
+	if index < 0 {
+		return 0
+	}
+	revision := s.savedGraph.Files[index].Revision
+	if revision == 0 {
+		return remote.UnknownRevision
+	}
+	return revision
+}
+
+func (s graphSyncer) uploadFile(file graph.File, operation string) (int64, error) {
+	contents, err := readContent(file)
+	if err != nil {
+		return 0, err
+	}
+
+	body := contents
+	if s.config.Encryption.Enabled && !file.IsDir() {
+		log.Info("Encrypting content")
+		body, err = s.seal(contents, file.Id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	fileId, err := s.remoteId(file.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	request := remote.NewUploadRequest(s.config, s.name, s.transaction, operation, s.key.epoch)
+	return request.Send(s.ctx, fileId, remote.Metadata{
+		Kind:    string(file.Kind),
+		Mode:    file.Mode,
+		ModTime: file.LastChange,
+	}, body, s.baseRevision(file.Id))
+}
+
+func readContent(file graph.File) ([]byte, error) {
+	if file.IsDir() {
+		return []byte{}, nil
+	}
+	if file.IsSymlink() {
+		return []byte(file.Target), nil
+	}
+
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+func (s graphSyncer) uploadChanges(changes compare.Result, conflicts []string) ([]int64, error) {
 	log.Info("Uploading changes to server")
+	revisions := make([]int64, 0)
 	for _, created := range changes.Created {
 		if slices.Contains(conflicts, created.Id) {
 			log.Info("Skipping upload for conflict file %s", created.Id)
 			continue
 		}
 		log.Info("Uploading created file: %s", created.Id)
-		err := s.uploadFile(created, "C")
+		revision, err := s.uploadFile(created, "C")
+		if stopsSync(err) {
+			return revisions, err
+		}
+		if errors.Is(err, remote.ErrConflict) {
+			log.Info("Skipping created file %s, it was changed on the server", created.Id)
+			s.report.Conflicts = append(s.report.Conflicts, created.Id)
+			continue
+		}
 		if err != nil {
 			log.Error("Failed to upload", err)
+			s.report.Failed = append(s.report.Failed, created.Id)
 			continue
 		}
+		revisions = append(revisions, revision)
+		created.Revision = revision
 		s.savedGraph.AddOrUpdateFile(created)
+		s.report.Uploaded = append(s.report.Uploaded, created.Id)
 	}
 
 	for _, changed := range changes.Changed {
@@ -195,70 +621,131 @@ func (s graphSyncer) uploadChanges(changes compare.Result, conflicts []string) e
 			continue
 		}
 		log.Info("Uploading changed file: %s", changed.Id)
-		err := s.uploadFile(changed, "M")
+		revision, err := s.uploadFile(changed, "M")
+		if stopsSync(err) {
+			return revisions, err
+		}
+		if errors.Is(err, remote.ErrConflict) {
+			log.Info("Skipping changed file %s, it was changed on the server", changed.Id)
+			s.report.Conflicts = append(s.report.Conflicts, changed.Id)
+			continue
+		}
 		if err != nil {
 			log.Error("Failed to upload change", err)
+			s.report.Failed = append(s.report.Failed, changed.Id)
+			continue
 		}
+		revisions = append(revisions, revision)
+		changed.Revision = revision
 		s.savedGraph.AddOrUpdateFile(changed)
+		s.report.Uploaded = append(s.report.Uploaded, changed.Id)
 	}
 
-	for _, deleted := range changes.Deleted {
+	for i, deleted := range changes.Deleted {
 		if slices.Contains(conflicts, deleted.Id) {
 			log.Info("Skipping deletion for conflict file %s", deleted.Id)
 			continue
 		}
 		log.Info("Deleting file: %s", deleted.Id)
-		err := s.deleteFile(deleted)
+		revision, err := s.deleteFile(deleted)
+		if stopsSync(err) {
+			return revisions, err
+		}
+		if errors.Is(err, remote.ErrConflict) {
+			log.Info("Skipping deleted file %s, it was changed on the server", deleted.Id)
+			s.report.Conflicts = append(s.report.Conflicts, deleted.Id)
+			continue
+		}
+		if errors.Is(err, remote.ErrTooManyDeletes) {
+			// the server refuses the other deletions of the transaction as well
+			log.Error("The server refused to delete more files", err)
+			s.report.Failed = append(s.report.Failed, fileIds(changes.Deleted[i:])...)
+			break
+		}
 		if err != nil {
 			log.Error("Failed to delete", err)
+			s.report.Failed = append(s.report.Failed, deleted.Id)
+			continue
 		}
+		revisions = append(revisions, revision)
 		s.savedGraph.RemoveFile(deleted.Id)
+		s.report.Deleted = append(s.report.Deleted, deleted.Id)
 	}
 
-	return nil
+	return revisions, nil
 }
 
-func (s graphSyncer) downloadFile(fileId string) error {
-	request := remote.NewContentRequest(s.config)
-	content, err := request.Send(s.name, fileId)
-	if err != nil {
-		log.Error("Failed to download content", err)
-		return err
+func stopsSync(err error) bool {
+	return errors.Is(err, remote.ErrUnauthorized) || errors.Is(err, remote.ErrGraphArchived) ||
+		errors.Is(err, remote.ErrKeyRotation) || errors.Is(err, remote.ErrKeyRotated) || errors.Is(err, context.Canceled)
+}
+
+func (s graphSyncer) skipReason(change change) string {
+	if !s.options.Profile.Includes(change.relPath, change.isDir()) {
+		return "device-local file"
+	}
+	if change.Operation != "D" && change.Kind == string(graph.KindSymlink) && s.options.Symlinks != graph.LinkSymlinks {
+		return "symlinks are not synced as links"
+	}
+	return ""
+}
+
+func (s graphSyncer) downloadFile(change change) error {
+	fileId := change.localId
+	isDir := change.isDir()
+	if reason := s.skipReason(change); reason != "" {
+		log.Info("Skipping %s: %s", fileId, reason)
+		return nil
 	}
-	if s.config.Encryption.Enabled {
-		content, err = crypt.Decrypt(content, s.config.Encryption.Key)
-		if err != nil {
-			return err
-		}
 
-		fileId, err = crypt.DecryptString(fileId, s.config.Encryption.Key)
+	var content []byte
+	var err error
+	if !isDir {
+		content, err = s.downloadContent(change)
 		if err != nil {
 			return err
 		}
 	}
-	path, err := graph.StoreFile(s.basePath, fileId, content)
+
+	var stored graph.File
+	if isDir {
+		// the metadata is stored by storeDirMetadata after the children
+		stored, err = graph.StoreDir(s.basePath, fileId, s.options.Symlinks)
+	} else if change.Kind == string(graph.KindSymlink) {
+		stored, err = graph.StoreSymlink(s.basePath, fileId, string(content))
+	} else {
+		stored, err = graph.StoreFile(s.basePath, fileId, content, change.Mode, change.ModTime, s.options.Symlinks)
+	}
 	if err != nil {
 		log.Error("Failed to store file in local graph", err)
 		return err
 	}
-	s.savedGraph.AddOrUpdateFile(graph.File{
-		Id:         fileId,
-		Path:       path,
-		LastChange: time.Now(),
-	})
+	stored.Revision = change.Revision
+	s.savedGraph.AddOrUpdateFile(stored)
 
 	return nil
 }
 
-func (s graphSyncer) removeFile(fileId string) error {
-	var err error
+func (s graphSyncer) downloadContent(change change) ([]byte, error) {
+	request := remote.NewContentRequest(s.config)
+	content, err := request.Send(s.ctx, s.name, change.FileId)
+	if err != nil {
+		log.Error("Failed to download content", err)
+		return nil, err
+	}
 	if s.config.Encryption.Enabled {
-		fileId, err = crypt.DecryptString(fileId, s.config.Encryption.Key)
-		if err != nil {
-			return err
-		}
+		return s.open(content, change.localId)
 	}
-	err = graph.RemoveFile(s.basePath, fileId)
+	return content, nil
+}
+
+func (s graphSyncer) removeFile(change change) error {
+	fileId := change.localId
+	if reason := s.skipReason(change); reason != "" {
+		log.Info("Skipping %s: %s", fileId, reason)
+		return nil
+	}
+	err := graph.RemoveFile(s.basePath, fileId, s.options.Symlinks)
 	if err != nil {
 		return err
 	}
@@ -266,32 +753,103 @@ func (s graphSyncer) removeFile(fileId string) error {
 	return nil
 }
 
-func (s graphSyncer) downloadChanges(changes []remote.ChangeLogEntry, conflicts []string) error {
+// storeDirMetadata applies the mode and modification time of the downloaded directories, after their children
+// were written and removed. Subdirectories are updated before their parents.
+func (s graphSyncer) storeDirMetadata(dirs []change) error {
+	slices.SortStableFunc(dirs, func(a, b change) int {
+		return len(b.localId) - len(a.localId)
+	})
+	var errs []error
+	for _, dir := range dirs {
+		stored, err := graph.StoreDirMetadata(s.basePath, dir.localId, dir.Mode, dir.ModTime, s.options.Symlinks)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir.localId, err))
+			continue
+		}
+		stored.Revision = dir.Revision
+		s.savedGraph.AddOrUpdateFile(stored)
+	}
+	return errors.Join(errs...)
+}
+
+func (s graphSyncer) downloadChanges(changes []change, conflicts []string) error {
 	log.Info("Downloading changes from server")
-	for _, change := range changes {
-		if slices.Contains(conflicts, change.FileId) {
-			log.Info("Skipping download of file %s", change.FileId)
+	dirs := make([]change, 0)
+	defer func() {
+		err := s.storeDirMetadata(dirs)
+		if err != nil {
+			log.Error("Failed to store the metadata of directories", err)
+		}
+	}()
+	for _, change := range orderChanges(changes) {
+		if slices.Contains(conflicts, change.localId) {
+			log.Info("Skipping download of file %s", change.localId)
 			continue
 		}
-		log.Info("Found change with transaction %s for file %s", change.TransactionId, change.FileId)
+		if reason := s.skipReason(change); reason != "" {
+			log.Info("Skipping %s: %s", change.localId, reason)
+			continue
+		}
+		log.Info("Found change with transaction %s for file %s", change.TransactionId, change.localId)
 		if change.Operation == "C" || change.Operation == "M" {
-			err := s.downloadFile(change.FileId)
+			err := s.downloadFile(change)
+			if stopsSync(err) {
+				return err
+			}
 			if err != nil {
 				log.Error("Failed to store file in local graph", err)
+				s.report.Failed = append(s.report.Failed, change.localId)
 				continue
 			}
+			if change.isDir() {
+				dirs = append(dirs, change)
+			}
+			s.report.Downloaded = append(s.report.Downloaded, change.localId)
 		} else if change.Operation == "D" {
-			err := s.removeFile(change.FileId)
+			err := s.removeFile(change)
+			if stopsSync(err) {
+				return err
+			}
 			if err != nil {
 				log.Error("Failed to remove file in local graph", err)
+				s.report.Failed = append(s.report.Failed, change.localId)
 				continue
 			}
+			s.report.Removed = append(s.report.Removed, change.localId)
 		}
 	}
 	return nil
 }
 
-func (s graphSyncer) getLocalChanges(g graph.Graph) (compare.Result, error) {
-	compResult := compare.Graphs(*s.savedGraph, g)
+// orderChanges moves the deletions of directories to the end, so that
+// they are already empty, when they are removed. Subdirectories have longer
+// paths, therefore they are removed before their parents.
+func orderChanges(changes []change) []change {
+	ordered := slices.Clone(changes)
+	isDirDeletion := func(change change) bool {
+		return change.Operation == "D" && change.isDir()
+	}
+	slices.SortStableFunc(ordered, func(a, b change) int {
+		if isDirDeletion(a) == isDirDeletion(b) {
+			if isDirDeletion(a) {
+				return len(b.relPath) - len(a.relPath)
+			}
+			return 0
+		}
+		if isDirDeletion(a) {
+			return 1
+		}
+		return -1
+	})
+	return ordered
+}
+
+func (s graphSyncer) getLocalChanges() (compare.Result, error) {
+	readGraph, err := graph.ReadGraph(s.basePath, s.options)
+	if err != nil {
+		return compare.Result{}, err
+	}
+
+	compResult := compare.Graphs(*s.savedGraph, readGraph)
 	return compResult, nil
 }
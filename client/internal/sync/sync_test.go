@@ -0,0 +1,485 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/soerenchrist/logsync/client/internal/compare"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/crypt"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func remoteChange(fileId string, revision int64) change {
+	return change{
+		ChangeLogEntry: remote.ChangeLogEntry{FileId: fileId, Operation: "M", Revision: revision},
+		localId:        fileId,
+	}
+}
+
+func TestCursor(t *testing.T) {
+	p := pending{
+		remote: []change{
+			remoteChange("pages/a.md", 4),
+			remoteChange("pages/b.md", 5),
+			remoteChange("pages/c.md", 6),
+		},
+		revision: 6,
+	}
+
+	t.Run("all changes applied", func(t *testing.T) {
+		if cursor := p.cursor(nil); cursor != 6 {
+			t.Fatalf("Expected 6, got %d", cursor)
+		}
+	})
+
+	t.Run("conflicts and failures are fetched again", func(t *testing.T) {
+		p := p
+		p.conflicts = []string{"pages/c.md"}
+		if cursor := p.cursor([]string{"pages/b.md"}); cursor != 4 {
+			t.Fatalf("Expected 4, got %d", cursor)
+		}
+	})
+}
+
+func TestAdvance(t *testing.T) {
+	if cursor := advance(6, []int64{8, 7}); cursor != 8 {
+		t.Fatalf("Expected own uploads to advance to 8, got %d", cursor)
+	}
+	if cursor := advance(6, []int64{7, 9}); cursor != 7 {
+		t.Fatalf("Expected to stop before the change of another client, got %d", cursor)
+	}
+	if cursor := advance(0, []int64{1}); cursor != 0 {
+		t.Fatalf("Expected graphs without revision to stay at 0, got %d", cursor)
+	}
+}
+
+func TestWithoutOwnChanges(t *testing.T) {
+	s := graphSyncer{}
+	s.config.Device.Id = "own"
+	entries := []remote.ChangeLogEntry{
+		{FileId: "pages/a.md", DeviceId: "own"},
+		{FileId: "pages/b.md", DeviceId: "other"},
+		{FileId: "pages/c.md"},
+	}
+
+	foreign := s.withoutOwnChanges(entries)
+	if len(foreign) != 2 || foreign[0].FileId != "pages/b.md" || foreign[1].FileId != "pages/c.md" {
+		t.Fatalf("Expected the changes of other devices, got %v", foreign)
+	}
+}
+
+func TestBaseRevision(t *testing.T) {
+	saved := graph.New("test")
+	saved.Files = []graph.File{
+		{Id: "pages/a.md", Revision: 4},
+		{Id: "pages/old.md"},
+	}
+	s := graphSyncer{savedGraph: &saved}
+
+	if base := s.baseRevision("pages/a.md"); base != 4 {
+		t.Fatalf("Expected the synced revision, got %d", base)
+	}
+	if base := s.baseRevision("pages/new.md"); base != 0 {
+		t.Fatalf("Expected new files to be based on no revision, got %d", base)
+	}
+	if base := s.baseRevision("pages/old.md"); base != remote.UnknownRevision {
+		t.Fatalf("Expected files synced with older servers to skip the check, got %d", base)
+	}
+}
+
+func TestPlanForMode(t *testing.T) {
+	plan := Plan{
+		Download: []string{"pages/remote.md"},
+		Remove:   []string{},
+		Upload:   []string{"pages/new.md", "pages/changed.md"},
+		Delete:   []string{"pages/deleted.md"},
+		Skipped:  []string{},
+	}
+	local := compare.Result{
+		Created: []graph.File{{Id: "pages/new.md"}},
+		Changed: []graph.File{{Id: "pages/changed.md"}},
+		Deleted: []graph.File{{Id: "pages/deleted.md"}},
+	}
+
+	pull := plan.forMode(ModePullOnly, local)
+	if len(pull.Download) != 1 || len(pull.Upload) != 0 || len(pull.Delete) != 0 {
+		t.Fatalf("Expected only the download, got %+v", pull)
+	}
+	push := plan.forMode(ModePushOnly, local)
+	if len(push.Download) != 0 || len(push.Upload) != 2 || len(push.Delete) != 1 {
+		t.Fatalf("Expected only the uploads, got %+v", push)
+	}
+	mirror := plan.forMode(ModeMirror, local)
+	if !slices.Equal(mirror.Download, []string{"pages/remote.md", "pages/changed.md", "pages/deleted.md"}) ||
+		!slices.Equal(mirror.Remove, []string{"pages/new.md"}) || len(mirror.Upload) != 0 {
+		t.Fatalf("Expected the local changes to be replaced, got %+v", mirror)
+	}
+
+	_, err := ModeByName("backup")
+	if err == nil {
+		t.Fatalf("Expected an unknown mode to fail")
+	}
+}
+
+func TestJoinGraph(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"pages/same.md": "- same", "pages/differs.md": "- local", "pages/new.md": "- new"}
+	created := make([]graph.File, 0)
+	for fileId, content := range files {
+		file, err := graph.StoreFile(dir, fileId, []byte(content), 0, time.Now(), graph.SkipSymlinks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, file)
+	}
+	hash := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	serverFile := func(fileId, content string) change {
+		c := remoteChange(fileId, 3)
+		c.Operation, c.Kind, c.hash = "C", string(graph.KindFile), hash(content)
+		return c
+	}
+
+	join := func(policy JoinPolicy) (graphSyncer, pending) {
+		s := graphSyncer{savedGraph: &graph.Graph{}, joinPolicy: policy, mode: ModeBidirectional}
+		s.config.Device.Name = "laptop"
+		p := pending{
+			remote: []change{serverFile("pages/same.md", "- same"), serverFile("pages/differs.md", "- server")},
+			local:  compare.Result{Created: slices.Clone(created)},
+		}
+		err := s.joinGraph(&p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s, p
+	}
+
+	s, p := join(JoinAsk)
+	if len(s.savedGraph.Files) != 1 || s.savedGraph.Files[0].Id != "pages/same.md" || s.savedGraph.Files[0].Revision != 3 {
+		t.Fatalf("Expected the identical file to be adopted, got %+v", s.savedGraph.Files)
+	}
+	if !slices.Equal(p.unresolved, []string{"pages/differs.md"}) || len(p.remote) != 1 || len(p.local.Created) != 2 {
+		t.Fatalf("Expected the differing file to be unresolved, got %+v", p)
+	}
+
+	_, p = join(JoinServer)
+	if len(p.unresolved) != 0 || len(p.remote) != 1 || slices.ContainsFunc(p.local.Created, func(file graph.File) bool {
+		return file.Id == "pages/differs.md"
+	}) {
+		t.Fatalf("Expected the differing file to be downloaded, got %+v", p)
+	}
+
+	s, p = join(JoinLocal)
+	if len(p.remote) != 0 || len(p.local.Changed) != 1 || p.local.Changed[0].Id != "pages/differs.md" ||
+		s.baseRevision("pages/differs.md") != 3 {
+		t.Fatalf("Expected the differing file to be uploaded, got %+v", p)
+	}
+
+	_, p = join(JoinKeepBoth)
+	if len(p.copies) != 1 || p.copies[0].id != "pages/differs%20%28conflict%20laptop%29.md" || len(p.remote) != 1 {
+		t.Fatalf("Expected a copy of the differing file, got %+v", p.copies)
+	}
+
+	_, err := JoinPolicyByName("merge")
+	if err == nil {
+		t.Fatalf("Expected an unknown policy to fail")
+	}
+}
+
+func TestDeleteLimit(t *testing.T) {
+	tests := []struct {
+		value     string
+		deletions int
+		files     int
+		exceeded  bool
+	}{
+		{"50%", 60, 100, true},
+		{"50%", 50, 100, false},
+		{"50%", 10, 12, false},
+		{"100", 101, 1000, true},
+		{"0", 1000, 1000, false},
+	}
+	for _, test := range tests {
+		limit, err := ParseDeleteLimit(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limit.Exceeded(test.deletions, test.files) != test.exceeded {
+			t.Fatalf("Expected %d of %d deletions to exceed %s: %v", test.deletions, test.files, test.value, test.exceeded)
+		}
+	}
+	for _, value := range []string{"", "many", "-1", "150%"} {
+		_, err := ParseDeleteLimit(value)
+		if err == nil {
+			t.Fatalf("Expected %q to be invalid", value)
+		}
+	}
+
+	files := make([]graph.File, 20)
+	s := graphSyncer{savedGraph: &graph.Graph{Files: files}, deleteLimit: DeleteLimit{Count: 5}}
+	if !errors.Is(s.checkDeletes(6), ErrTooManyDeletes) {
+		t.Fatalf("Expected the deletions to be refused")
+	}
+	s.config.Sync.ConfirmDeletes = true
+	if s.checkDeletes(20) != nil {
+		t.Fatalf("Expected confirmed deletions to be allowed")
+	}
+}
+
+func TestRekeyLegacyIds(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key := crypt.LegacyKey("secret")
+	check, _ := crypt.NewKeyCheck(key)
+	// ids of older versions were encrypted with random nonces
+	legacyPage, _ := crypt.EncryptString("pages/a.md", key)
+	legacyDeleted, _ := crypt.EncryptString("pages/b.md", key)
+	content, _ := crypt.Encrypt([]byte("- a"), key)
+
+	rotated := make(map[string]string)
+	var staged []byte
+	var committed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/graphs/Personal":
+			json.NewEncoder(w).Encode(remote.Graph{Name: "Personal", Encrypted: true, KeyCheck: check})
+		case r.Method == "GET" && r.URL.Path == "/Personal/changes":
+			json.NewEncoder(w).Encode([]remote.ChangeLogEntry{
+				{FileId: legacyPage, Operation: "C", Revision: 1},
+				{FileId: legacyDeleted, Operation: "D", Revision: 2},
+			})
+		case r.Method == "GET" && r.URL.Path == "/graphs/Personal/rotation":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code": "not-found"}`))
+		case r.Method == "POST" && r.URL.Path == "/graphs/Personal/rotation":
+			json.NewEncoder(w).Encode(remote.Rotation{Pending: []remote.PendingFile{
+				{FileId: legacyPage, Content: true}, {FileId: legacyDeleted},
+			}})
+		case r.Method == "GET" && r.URL.Path == "/Personal/content/"+legacyPage:
+			w.Write(content)
+		case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/graphs/Personal/rotation/files/"):
+			fileId := strings.TrimPrefix(r.URL.Path, "/graphs/Personal/rotation/files/")
+			rotated[fileId] = r.URL.Query().Get("new_file_id")
+			if fileId == legacyPage {
+				staged, _ = io.ReadAll(r.Body)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "POST" && r.URL.Path == "/graphs/Personal/rotation/commit":
+			committed = true
+			json.NewEncoder(w).Encode(remote.Graph{Name: "Personal", Encrypted: true, KeyCheck: check, KeyEpoch: 1})
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	s := graphSyncer{
+		ctx:        context.Background(),
+		savedGraph: &graph.Graph{Name: "Personal", Files: []graph.File{{Id: "pages/a.md"}}},
+		name:       "Personal",
+		key:        &graphKey{},
+		report:     &Report{},
+		mode:       ModeBidirectional,
+	}
+	s.config.Server.Host = server.URL
+	s.config.Encryption.Enabled = true
+	s.config.Encryption.Key = "secret"
+
+	err := s.rekeyLegacyIds()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !committed || !s.savedGraph.LegacyIdsChecked || s.key.epoch != 1 {
+		t.Fatalf("Expected the rotation to be committed in epoch 1, got %v, %+v", committed, s.key)
+	}
+	for legacyId, fileId := range map[string]string{legacyPage: "pages/a.md", legacyDeleted: "pages/b.md"} {
+		expected, _ := crypt.EncryptFileId(fileId, key)
+		if rotated[legacyId] != expected {
+			t.Fatalf("Expected %s to get the deterministic id %s, got %s", fileId, expected, rotated[legacyId])
+		}
+	}
+	plain, err := crypt.Open(staged, key, s.envelope("pages/a.md"))
+	if err != nil || string(plain) != "- a" {
+		t.Fatalf("Expected the content to be kept, got %q, %v", plain, err)
+	}
+
+	// the ids are only checked once
+	committed = false
+	err = s.rekeyLegacyIds()
+	if err != nil || committed {
+		t.Fatalf("Expected no second rotation, got %v", err)
+	}
+}
+
+func TestCreateGraphWithoutKeyParams(t *testing.T) {
+	// older servers don't store the key parameters of created graphs
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/graphs/Personal":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code": "graph-not-found"}`))
+		case r.Method == "POST" && r.URL.Path == "/graphs":
+			var created remote.Graph
+			json.NewDecoder(r.Body).Decode(&created)
+			created.KeyParams = ""
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	s := graphSyncer{
+		ctx:        context.Background(),
+		savedGraph: &graph.Graph{Name: "Personal"},
+		name:       "Personal",
+		key:        &graphKey{},
+		report:     newReport("Personal"),
+		mode:       ModeBidirectional,
+	}
+	s.config.Server.Host = server.URL
+	s.config.Encryption.Enabled = true
+	s.config.Encryption.Key = "secret"
+
+	err := s.createGraph()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if s.key.kdf != crypt.KDFLegacy || s.key.params != "" {
+		t.Fatalf("Expected the legacy key derivation, got %+v", s.key)
+	}
+	if len(s.report.Warnings) != 1 || !strings.Contains(s.report.Warnings[0], "logsync rotate-key") {
+		t.Fatalf("Expected a warning with the upgrade path, got %v", s.report.Warnings)
+	}
+}
+
+func TestDownloadKeepsDirectoryTimes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry := func(fileId string, kind graph.Kind, revision int64) change {
+		return change{
+			ChangeLogEntry: remote.ChangeLogEntry{FileId: fileId, Operation: "C", Kind: string(kind),
+				ModTime: modTime, Revision: revision},
+			localId: fileId,
+			relPath: fileId,
+		}
+	}
+	s := graphSyncer{
+		ctx:        context.Background(),
+		basePath:   dir,
+		savedGraph: &graph.Graph{Name: "Personal"},
+		name:       "Personal",
+		key:        &graphKey{},
+		report:     newReport("Personal"),
+		options:    graph.ReadOptions{Profile: graph.Plain},
+	}
+	s.config.Server.Host = server.URL
+
+	// the children are written after their directories
+	err := s.downloadChanges([]change{
+		entry("assets", graph.KindDir, 1),
+		entry("assets/images", graph.KindDir, 2),
+		entry("assets/images/a.png", graph.KindFile, 3),
+		entry("assets/b.png", graph.KindFile, 4),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, fileId := range []string{"assets", "assets/images"} {
+		info, err := os.Stat(filepath.Join(dir, fileId))
+		if err != nil || !info.ModTime().Equal(modTime) {
+			t.Fatalf("Expected %s to have the modification time %v, got %v (%v)", fileId, modTime, info.ModTime(), err)
+		}
+		index := slices.IndexFunc(s.savedGraph.Files, func(file graph.File) bool { return file.Id == fileId })
+		if index < 0 || !s.savedGraph.Files[index].LastChange.Equal(modTime) {
+			t.Fatalf("Expected the saved state of %s to have the modification time, got %+v", fileId, s.savedGraph.Files)
+		}
+	}
+}
+
+func TestGetPlanKeepsSavedState(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	graphPath := filepath.Join(t.TempDir(), "Personal")
+	if err := os.Mkdir(graphPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for fileId, content := range map[string]string{"pages/same.md": "- same", "pages/new.md": "- new"} {
+		_, err := graph.StoreFile(graphPath, fileId, []byte(content), 0, time.Now(), graph.SkipSymlinks)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	savePath, err := getLoadFilePath("Personal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ensureLoadFileDir(savePath); err != nil {
+		t.Fatal(err)
+	}
+	if err = graph.SaveGraphToFile(graph.New("Personal"), savePath); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(savePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/graphs/Personal":
+			json.NewEncoder(w).Encode(remote.Graph{Name: "Personal"})
+		case "/Personal/manifest":
+			sum := sha256.Sum256([]byte("- same"))
+			json.NewEncoder(w).Encode(remote.Manifest{Graph: "Personal", Revision: 1, Files: []remote.ManifestEntry{
+				{FileId: "pages/same.md", Revision: 1, Hash: hex.EncodeToString(sum[:]), Kind: string(graph.KindFile)},
+			}})
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var conf config.Config
+	conf.Server.Host = server.URL
+	conf.Sync.Profile = graph.Plain.Name()
+	conf.Sync.Compression = "none"
+	conf.Sync.Join = string(JoinAsk)
+	conf.Sync.DeleteLimit = "0"
+	plan, err := GetPlan(conf, graphPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if slices.Contains(plan.Upload, "pages/same.md") || !slices.Contains(plan.Upload, "pages/new.md") || len(plan.Download) != 0 {
+		t.Fatalf("Expected the identical file to be adopted, got %+v", plan)
+	}
+
+	after, err := os.ReadFile(savePath)
+	if err != nil || string(after) != string(saved) {
+		t.Fatalf("Expected the saved state to be unchanged, got %s (%v)", after, err)
+	}
+}
@@ -0,0 +1,179 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/soerenchrist/logsync/client/internal/compare"
+	"github.com/soerenchrist/logsync/client/internal/config"
+	"github.com/soerenchrist/logsync/client/internal/graph"
+	"github.com/soerenchrist/logsync/client/internal/log"
+	"github.com/soerenchrist/logsync/client/internal/remote"
+	"slices"
+)
+
+var ErrNoManifest = errors.New("the server provides no manifest of the graph")
+
+// Drift are the differences between the synced state of the graph and the manifest of the server,
+// that are not explained by changes since the last sync
+type Drift struct {
+	Graph    string `json:"graph"`
+	Revision int64  `json:"revision"`
+	// Missing files are on the server, but not in the local graph
+	Missing []string `json:"missing"`
+	// Extra files were synced, but are not on the server
+	Extra []string `json:"extra"`
+	// Mismatched files were not changed locally, but differ from the server.
+	// The content of encrypted graphs can't be compared.
+	Mismatched []string `json:"mismatched"`
+	Repaired   bool     `json:"repaired"`
+}
+
+func (d Drift) None() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Mismatched) == 0
+}
+
+// Verify compares the synced state of the graph with the manifest of the server.
+// With repair, missing and mismatched files are downloaded and extra files are uploaded on the next sync.
+func Verify(conf config.Config, graphPath string, repair bool) (Drift, error) {
+	syncer, err := newSyncer(graphPath, conf)
+	if err != nil {
+		return Drift{}, err
+	}
+
+	manifest, err := remote.NewManifestRequest(conf).Send(syncer.ctx, syncer.name)
+	if errors.Is(err, remote.ErrNotFound) {
+		return Drift{}, ErrNoManifest
+	}
+	if err != nil {
+		return Drift{}, err
+	}
+
+	// changes since the last sync are no drift, the next sync transfers them
+	localGraph, err := graph.ReadGraph(syncer.basePath, syncer.options)
+	if err != nil {
+		return Drift{}, err
+	}
+	localChanges := compare.Graphs(*syncer.savedGraph, localGraph)
+	remoteChanges, _, err := syncer.fetchRemoteChanges()
+	if err != nil {
+		return Drift{}, err
+	}
+	pendingIds := fileIds(localChanges.Created)
+	pendingIds = append(pendingIds, fileIds(localChanges.Changed)...)
+	pendingIds = append(pendingIds, fileIds(localChanges.Deleted)...)
+	for _, change := range remoteChanges {
+		pendingIds = append(pendingIds, change.localId)
+	}
+
+	drift := Drift{
+		Graph:      syncer.name,
+		Revision:   manifest.Revision,
+		Missing:    make([]string, 0),
+		Extra:      make([]string, 0),
+		Mismatched: make([]string, 0),
+	}
+	repairs := make([]change, 0)
+	onServer := make([]string, 0, len(manifest.Files))
+	for _, entry := range withHashes(syncer.toChanges(manifestEntries(manifest)), manifest) {
+		onServer = append(onServer, entry.localId)
+		if slices.Contains(pendingIds, entry.localId) || syncer.skipReason(entry) != "" {
+			continue
+		}
+
+		index := slices.IndexFunc(localGraph.Files, func(file graph.File) bool {
+			return file.Id == entry.localId
+		})
+		if index < 0 {
+			drift.Missing = append(drift.Missing, entry.localId)
+			repairs = append(repairs, entry)
+			continue
+		}
+		if syncer.contentDiffers(localGraph.Files[index], entry.hash) {
+			drift.Mismatched = append(drift.Mismatched, entry.localId)
+			repairs = append(repairs, entry)
+		}
+	}
+	for _, file := range syncer.savedGraph.Files {
+		if !slices.Contains(onServer, file.Id) && !slices.Contains(pendingIds, file.Id) {
+			drift.Extra = append(drift.Extra, file.Id)
+		}
+	}
+
+	if !repair || drift.None() {
+		return drift, nil
+	}
+	return drift, syncer.repair(&drift, repairs)
+}
+
+// repair forgets extra files, so that the next sync uploads them again.
+func (s graphSyncer) repair(drift *Drift, repairs []change) error {
+	var errs []error
+	dirs := make([]change, 0)
+	for _, change := range repairs {
+		log.Info("Repairing %s", change.localId)
+		err := s.downloadFile(change)
+		if err != nil {
+			errs = append(errs, err)
+		} else if change.isDir() {
+			dirs = append(dirs, change)
+		}
+	}
+	err := s.storeDirMetadata(dirs)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, fileId := range drift.Extra {
+		log.Info("Marking %s for upload", fileId)
+		s.savedGraph.RemoveFile(fileId)
+	}
+
+	err = s.saveFiles()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	drift.Repaired = len(errs) == 0
+	return errors.Join(errs...)
+}
+
+func (s graphSyncer) contentDiffers(file graph.File, hash string) bool {
+	if s.config.Encryption.Enabled || file.IsDir() || hash == "" {
+		return false
+	}
+
+	content, err := readContent(file)
+	if err != nil {
+		log.Error("Could not read file", err)
+		return false
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]) != hash
+}
+
+func manifestEntries(manifest remote.Manifest) []remote.ChangeLogEntry {
+	entries := make([]remote.ChangeLogEntry, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		entries = append(entries, remote.ChangeLogEntry{
+			GraphName: manifest.Graph,
+			FileId:    file.FileId,
+			Timestamp: file.ModTime,
+			Operation: "C",
+			Kind:      file.Kind,
+			Mode:      file.Mode,
+			ModTime:   file.ModTime,
+			Revision:  file.Revision,
+		})
+	}
+	return entries
+}
+
+func withHashes(changes []change, manifest remote.Manifest) []change {
+	hashes := make(map[string]string, len(manifest.Files))
+	for _, file := range manifest.Files {
+		hashes[file.FileId] = file.Hash
+	}
+	for i := range changes {
+		changes[i].hash = hashes[changes[i].FileId]
+	}
+	return changes
+}
@@ -0,0 +1,328 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+var ErrGraphNotFound = errors.New("graph not found")
+var ErrGraphExists = errors.New("graph already exists")
+
+// Admin manages the graphs on the database and the file store.
+// It is used by the graph endpoints and by the maintenance commands, while the server is not running.
+type Admin struct {
+	db    *gorm.DB
+	files files.FileStore
+}
+
+func New(db *gorm.DB, f files.FileStore) Admin {
+	return Admin{
+		db:    db,
+		files: f,
+	}
+}
+
+type GraphInfo struct {
+	model.Graph
+	// Files is the number of files currently stored
+	Files      int64     `json:"files"`
+	Changes    int64     `json:"changes"`
+	Size       int64     `json:"size"`
+	LastChange time.Time `json:"last_change"`
+}
+
+// GraphNames returns the names of all graphs, that are registered or have changes, mappings or stored files
+func (a Admin) GraphNames() ([]string, error) {
+	var names []string
+	err := a.db.Model(&model.Graph{}).Pluck("name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var changeNames []string
+	err = a.db.Model(&model.ChangeLogEntry{}).Distinct().Pluck("graph_name", &changeNames).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var mappingNames []string
+	err = a.db.Model(&model.FileMapping{}).Distinct().Pluck("graph_name", &mappingNames).Error
+	if err != nil {
+		return nil, err
+	}
+
+	dirNames, err := a.files.Graphs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range append(append(changeNames, mappingNames...), dirNames...) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (a Admin) Graphs() ([]GraphInfo, error) {
+	names, err := a.GraphNames()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]GraphInfo, 0, len(names))
+	for _, name := range names {
+		info, err := a.Graph(name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (a Admin) Graph(name string) (GraphInfo, error) {
+	info := GraphInfo{Graph: model.Graph{Name: name}}
+	registered := true
+	err := a.db.Where("name = ?", name).First(&info.Graph).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		registered = false
+	} else if err != nil {
+		return GraphInfo{}, err
+	}
+
+	err = a.db.Model(&model.FileMapping{}).Where("graph_name = ?", name).Count(&info.Files).Error
+	if err != nil {
+		return GraphInfo{}, err
+	}
+
+	err = a.db.Model(&model.ChangeLogEntry{}).Where("graph_name = ?", name).Count(&info.Changes).Error
+	if err != nil {
+		return GraphInfo{}, err
+	}
+
+	if info.Changes > 0 {
+		var last model.ChangeLogEntry
+		err = a.db.Where("graph_name = ?", name).Order("timestamp desc").First(&last).Error
+		if err != nil {
+			return GraphInfo{}, err
+		}
+		info.LastChange = last.Timestamp
+	}
+
+	blobs, err := a.files.Blobs(name)
+	if err != nil {
+		return GraphInfo{}, err
+	}
+	if !registered && info.Files == 0 && info.Changes == 0 && len(blobs) == 0 {
+		return GraphInfo{}, fmt.Errorf("%w: %s", ErrGraphNotFound, name)
+	}
+	for _, blob := range blobs {
+		info.Size += blob.Size
+	}
+
+	return info, nil
+}
+
+// DeleteGraph removes the changes, mappings, devices, key rotations, members and stored files of the graph
+func (a Admin) DeleteGraph(name string) error {
+	_, err := a.Graph(name)
+	if err != nil {
+		return err
+	}
+
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("graph_name = ?", name).Delete(&model.ChangeLogEntry{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("graph_name = ?", name).Delete(&model.FileMapping{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("graph_name = ?", name).Delete(&model.Device{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("graph_name = ?", name).Delete(&model.KeyRotation{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("graph_name = ?", name).Delete(&model.RotatedFile{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("graph_name = ?", name).Delete(&model.GraphMember{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("name = ?", name).Delete(&model.Graph{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.files.RemoveGraph(name)
+}
+
+// RenameGraph moves all changes, mappings, devices, key rotations, members and stored files to the new name.
+// Clients have to use the new name as the name of their graph directory.
+func (a Admin) RenameGraph(oldName, newName string) error {
+	err := a.checkRename(oldName, newName)
+	if err != nil {
+		return err
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		return a.renameGraph(tx, oldName, newName)
+	})
+}
+
+func (a Admin) checkRename(oldName, newName string) error {
+	err := ValidateGraphName(newName)
+	if err != nil {
+		return err
+	}
+	_, err = a.Graph(oldName)
+	if err != nil {
+		return err
+	}
+	_, err = a.Graph(newName)
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrGraphExists, newName)
+	}
+	if !errors.Is(err, ErrGraphNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (a Admin) renameGraph(tx *gorm.DB, oldName, newName string) error {
+	err := tx.Model(&model.ChangeLogEntry{}).
+		Where("graph_name = ?", oldName).
+		Update("graph_name", newName).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.FileMapping{}).
+		Where("graph_name = ?", oldName).
+		Update("graph_name", newName).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.Device{}).
+		Where("graph_name = ?", oldName).
+		Update("graph_name", newName).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.KeyRotation{}).
+		Where("graph_name = ?", oldName).
+		Update("graph_name", newName).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.RotatedFile{}).
+		Where("graph_name = ?", oldName).
+		Update("graph_name", newName).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.GraphMember{}).
+		Where("graph_name = ?", oldName).
+		Update("graph_name", newName).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.Graph{}).
+		Where("name = ?", oldName).
+		Update("name", newName).Error
+	if err != nil {
+		return err
+	}
+
+	// the database changes are rolled back, if the files can't be moved
+	return a.files.RenameGraph(oldName, newName)
+}
+
+// ValidateGraphName checks that the name is a valid directory name and not used by other endpoints
+func ValidateGraphName(name string) error {
+	err := files.ValidateName(name)
+	if err != nil {
+		return err
+	}
+	if model.IsReservedGraphName(name) {
+		return fmt.Errorf("%w: %s is reserved", files.ErrInvalidName, name)
+	}
+	return nil
+}
+
+// InvalidGraphNames returns the graphs with names, that older versions accepted, but ValidateGraphName rejects.
+// Clients can't sync them anymore, until they are renamed with RenameGraph.
+func (a Admin) InvalidGraphNames() ([]string, error) {
+	names, err := a.GraphNames()
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := make([]string, 0)
+	for _, name := range names {
+		if ValidateGraphName(name) != nil {
+			invalid = append(invalid, name)
+		}
+	}
+	return invalid, nil
+}
+
+// CreateGraph registers a new graph. The name must not be used by another graph.
+func (a Admin) CreateGraph(graph model.Graph) (model.Graph, error) {
+	err := ValidateGraphName(graph.Name)
+	if err != nil {
+		return model.Graph{}, err
+	}
+	_, err = a.Graph(graph.Name)
+	if err == nil {
+		return model.Graph{}, fmt.Errorf("%w: %s", ErrGraphExists, graph.Name)
+	}
+	if !errors.Is(err, ErrGraphNotFound) {
+		return model.Graph{}, err
+	}
+
+	graph.Id = uuid.New().String()
+	graph.CreatedAt = time.Now()
+	err = a.db.Create(&graph).Error
+	return graph, err
+}
+
+// UpdateGraph stores the metadata of the registered graph with the name. Another name in graph renames it
+// like RenameGraph, the metadata and the name are changed together or not at all.
+func (a Admin) UpdateGraph(name string, graph model.Graph) error {
+	rename := graph.Name != name
+	if rename {
+		err := a.checkRename(name, graph.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.Graph{}).
+			Where("id = ?", graph.Id).
+			Select("owner", "encrypted", "key_check", "key_params", "archived").
+			Updates(graph).Error
+		if err != nil || !rename {
+			return err
+		}
+		return a.renameGraph(tx, name, graph.Name)
+	})
+}
+
+// Vacuum rebuilds the database file to free the space of removed rows
+func (a Admin) Vacuum() error {
+	return a.db.Exec("VACUUM").Error
+}
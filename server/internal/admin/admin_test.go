@@ -0,0 +1,495 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/log"
+	"github.com/soerenchrist/logsync/server/internal/model"
+)
+
+func setup(t *testing.T) (Admin, files.Files) {
+	log.NewWithWriter(io.Discard, slog.LevelError)
+	dir := t.TempDir()
+	db, err := model.CreateDb(filepath.Join(dir, "logsync.db"))
+	if err != nil {
+		t.Fatalf("Could not create db: %v", err)
+	}
+	f := files.New(filepath.Join(dir, "files"))
+	return New(db, f), f
+}
+
+// addFile stores a file like an upload of a client
+func addFile(t *testing.T, a Admin, f files.Files, graphName, fileId, fileName, content string, timestamp time.Time) {
+	err := f.Store(graphName, fileName, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Could not store file: %v", err)
+	}
+	a.db.Save(&model.FileMapping{GraphName: graphName, FileId: fileId, FileName: fileName})
+	var count int64
+	a.db.Model(&model.ChangeLogEntry{}).Where("graph_name = ?", graphName).Count(&count)
+	a.db.Create(&model.ChangeLogEntry{
+		GraphName: graphName,
+		FileId:    fileId,
+		Timestamp: timestamp,
+		CreatedAt: timestamp,
+		Operation: model.Created,
+		Kind:      model.File,
+		Revision:  count + 1,
+	})
+}
+
+func TestGraphs(t *testing.T) {
+	a, f := setup(t)
+	now := time.Now()
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "aaa", now)
+	addFile(t, a, f, "Personal", "pages/b.md", "blob-b", "bb", now.Add(time.Second))
+	addFile(t, a, f, "Work", "pages/a.md", "blob-c", "c", now)
+
+	graphs, err := a.Graphs()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(graphs) != 2 {
+		t.Fatalf("Expected 2 graphs, got %v", graphs)
+	}
+	personal := graphs[0]
+	if personal.Name != "Personal" || personal.Files != 2 || personal.Changes != 2 || personal.Size != 5 {
+		t.Fatalf("Unexpected info for Personal: %+v", personal)
+	}
+
+	err = a.DeleteGraph("Personal")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = a.Graph("Personal")
+	if !errors.Is(err, ErrGraphNotFound) {
+		t.Fatalf("Expected graph to be deleted, got %v", err)
+	}
+	_, err = a.Graph("Work")
+	if err != nil {
+		t.Fatalf("Expected other graph to be kept, got %v", err)
+	}
+}
+
+func TestRenameGraph(t *testing.T) {
+	a, f := setup(t)
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "aaa", time.Now())
+	addFile(t, a, f, "Work", "pages/a.md", "blob-b", "b", time.Now())
+
+	err := a.RenameGraph("Personal", "Work")
+	if !errors.Is(err, ErrGraphExists) {
+		t.Fatalf("Expected ErrGraphExists, got %v", err)
+	}
+	err = a.RenameGraph("Personal", "../Work")
+	if !errors.Is(err, files.ErrInvalidName) {
+		t.Fatalf("Expected ErrInvalidName, got %v", err)
+	}
+
+	err = a.RenameGraph("Personal", "Private")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, err := f.Content("Private", "blob-a")
+	if err != nil || string(content) != "aaa" {
+		t.Fatalf("Expected the blob to be moved, got %q, %v", content, err)
+	}
+	info, err := a.Graph("Private")
+	if err != nil || info.Files != 1 || info.Changes != 1 {
+		t.Fatalf("Expected the mappings and changes to be moved, got %+v, %v", info, err)
+	}
+}
+
+func TestRenameInvalidGraphName(t *testing.T) {
+	a, _ := setup(t)
+	// older versions accepted any directory name, the stored files are moved in TestRenameInvalidGraph of files
+	legacyName := "Personal (old)"
+	err := a.db.Create(&model.Graph{Id: "legacy", Name: legacyName}).Error
+	if err != nil {
+		t.Fatalf("Could not create graph: %v", err)
+	}
+	a.db.Create(&model.FileMapping{GraphName: legacyName, FileId: "pages/a.md", FileName: "blob-a"})
+
+	invalid, err := a.InvalidGraphNames()
+	if err != nil || len(invalid) != 1 || invalid[0] != legacyName {
+		t.Fatalf("Expected the legacy graph to be reported, got %v, %v", invalid, err)
+	}
+	info, err := a.Graph(legacyName)
+	if err != nil || info.Files != 1 {
+		t.Fatalf("Expected the legacy graph with its mapping, got %+v, %v", info, err)
+	}
+
+	err = a.RenameGraph(legacyName, "Personal (new)")
+	if !errors.Is(err, files.ErrInvalidName) {
+		t.Fatalf("Expected ErrInvalidName for the new name, got %v", err)
+	}
+	err = a.RenameGraph(legacyName, "Personal old")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	info, err = a.Graph("Personal old")
+	if err != nil || info.Files != 1 {
+		t.Fatalf("Expected the mapping to be moved, got %+v, %v", info, err)
+	}
+	invalid, err = a.InvalidGraphNames()
+	if err != nil || len(invalid) != 0 {
+		t.Fatalf("Expected no invalid names after the rename, got %v, %v", invalid, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	a, f := setup(t)
+	now := time.Now()
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "aaa", now)
+	addFile(t, a, f, "Personal", "pages/b.md", "blob-b", "bb", now)
+	// directories have a mapping, but no blob
+	a.db.Create(&model.FileMapping{GraphName: "Personal", FileId: "pages", FileName: "blob-dir"})
+	a.db.Create(&model.ChangeLogEntry{GraphName: "Personal", FileId: "pages", Timestamp: now, Operation: model.Created, Kind: model.Directory})
+
+	problems, err := a.Verify(false)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("Expected no problems, got %v, %v", problems, err)
+	}
+
+	_ = f.Remove("Personal", "blob-b")
+	_ = f.Store("Personal", "orphan", strings.NewReader("o"))
+
+	problems, err = a.Verify(true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", problems)
+	}
+	for _, problem := range problems {
+		switch problem.Kind {
+		case MissingBlob:
+			if problem.FileId != "pages/b.md" || problem.Fixed {
+				t.Fatalf("Unexpected problem %+v", problem)
+			}
+		case OrphanedBlob:
+			if problem.FileName != "orphan" || !problem.Fixed {
+				t.Fatalf("Unexpected problem %+v", problem)
+			}
+		default:
+			t.Fatalf("Unexpected problem %+v", problem)
+		}
+	}
+
+	_, err = f.Content("Personal", "orphan")
+	if err == nil {
+		t.Fatal("Expected the orphaned blob to be removed")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	a, f := setup(t)
+	start := time.Now().Add(-time.Hour)
+	_, err := a.CreateGraph(model.Graph{Name: "Personal"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "1", start)
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "2", start.Add(time.Minute))
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "3", start.Add(2*time.Minute))
+	addFile(t, a, f, "Personal", "pages/b.md", "blob-b", "1", start)
+	addFile(t, a, f, "Personal", "pages/c.md", "blob-c", "1", start)
+	// the deletion has the timestamp of the creation, the revision orders them
+	a.db.Create(&model.ChangeLogEntry{GraphName: "Personal", FileId: "pages/c.md", Timestamp: start.Add(-time.Millisecond), CreatedAt: start.Add(time.Minute), Operation: model.Deleted, Revision: 6})
+
+	result, err := a.Compact("", start.Add(90*time.Second))
+	if err != nil || result.Changes != 3 || result.Tombstones != 1 {
+		t.Fatalf("Expected 3 removed changes and 1 tombstone, got %+v, %v", result, err)
+	}
+
+	info, _ := a.Graph("Personal")
+	if info.Changes != 2 {
+		t.Fatalf("Expected the latest change of every file to be kept, got %d changes", info.Changes)
+	}
+	if info.CompactedRevision != 6 || !info.CompactedAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Expected the horizon at the removed deletion, got %d, %v", info.CompactedRevision, info.CompactedAt)
+	}
+}
+
+func TestCompactKeepsRecentlyReceivedTombstones(t *testing.T) {
+	a, f := setup(t)
+	start := time.Now().Add(-time.Hour)
+	_, err := a.CreateGraph(model.Graph{Name: "Personal"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "1", start.Add(-48*time.Hour))
+	// the deletion carries the old modification time of the file, but was just received
+	a.db.Create(&model.ChangeLogEntry{GraphName: "Personal", FileId: "pages/a.md", Timestamp: start.Add(-47 * time.Hour), CreatedAt: time.Now(), Operation: model.Deleted, Revision: 2})
+
+	result, err := a.Compact("Personal", start)
+	if err != nil || result.Changes != 1 || result.Tombstones != 0 {
+		t.Fatalf("Expected the replaced creation to be removed and the deletion to be kept, got %+v, %v", result, err)
+	}
+	info, _ := a.Graph("Personal")
+	if info.Changes != 1 || info.CompactedRevision != 0 || !info.CompactedAt.IsZero() {
+		t.Fatalf("Expected no compaction horizon, got %+v", info)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	a, f := setup(t)
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "aaa", time.Now())
+	addFile(t, a, f, "Personal", "pages/b.md", "blob-b", "bb", time.Now())
+
+	var archive bytes.Buffer
+	err := a.Export("Personal", &archive)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	_, err = a.Import(bytes.NewReader(archive.Bytes()), "")
+	if !errors.Is(err, ErrGraphExists) {
+		t.Fatalf("Expected ErrGraphExists, got %v", err)
+	}
+
+	name, err := a.Import(bytes.NewReader(archive.Bytes()), "Copy")
+	if err != nil || name != "Copy" {
+		t.Fatalf("Expected import as Copy, got %s, %v", name, err)
+	}
+
+	original, _ := a.Graph("Personal")
+	imported, err := a.Graph("Copy")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if imported.Files != original.Files || imported.Changes != original.Changes || imported.Size != original.Size {
+		t.Fatalf("Expected %+v, got %+v", original, imported)
+	}
+	// the graph was not registered, the revision is taken from the changes
+	if imported.Revision != 2 {
+		t.Fatalf("Expected revision 2, got %d", imported.Revision)
+	}
+
+	problems, err := a.Verify(false)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("Expected no problems, got %v, %v", problems, err)
+	}
+}
+
+func TestCreateGraph(t *testing.T) {
+	a, f := setup(t)
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "aaa", time.Now())
+
+	graph, err := a.CreateGraph(model.Graph{Name: "Work", Encrypted: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if graph.Id == "" || !graph.Encrypted {
+		t.Fatalf("Expected an id and the metadata, got %+v", graph)
+	}
+
+	info, err := a.Graph("Work")
+	if err != nil || info.Id != graph.Id || info.Files != 0 {
+		t.Fatalf("Expected the empty graph, got %+v, %v", info, err)
+	}
+
+	_, err = a.CreateGraph(model.Graph{Name: "Personal"})
+	if !errors.Is(err, ErrGraphExists) {
+		t.Fatalf("Expected ErrGraphExists for a graph with files, got %v", err)
+	}
+	_, err = a.CreateGraph(model.Graph{Name: "graphs"})
+	if !errors.Is(err, files.ErrInvalidName) {
+		t.Fatalf("Expected ErrInvalidName for a reserved name, got %v", err)
+	}
+}
+
+func TestCompactKeepsUnacknowledgedTombstones(t *testing.T) {
+	a, f := setup(t)
+	start := time.Now().Add(-time.Hour)
+	_, err := a.CreateGraph(model.Graph{Name: "Personal"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	addFile(t, a, f, "Personal", "pages/a.md", "blob-a", "1", start)
+	addFile(t, a, f, "Personal", "pages/b.md", "blob-b", "1", start)
+	a.db.Create(&model.ChangeLogEntry{GraphName: "Personal", FileId: "pages/a.md", Timestamp: start.Add(time.Minute), CreatedAt: start.Add(time.Minute), Operation: model.Deleted, Revision: 3})
+	a.db.Create(&model.ChangeLogEntry{GraphName: "Personal", FileId: "pages/b.md", Timestamp: start.Add(time.Minute), CreatedAt: start.Add(time.Minute), Operation: model.Deleted, Revision: 4})
+	a.db.Create(&model.Device{GraphName: "Personal", Id: "laptop", Revision: 3, LastSeen: time.Now()})
+	a.db.Create(&model.Device{GraphName: "Personal", Id: "phone", Revision: 4, LastSeen: time.Now()})
+	// stale devices have to resync anyway
+	a.db.Create(&model.Device{GraphName: "Personal", Id: "old", Revision: 1, LastSeen: start.Add(-time.Hour)})
+
+	result, err := a.Compact("Personal", start.Add(30*time.Minute))
+	if err != nil || result.Tombstones != 1 {
+		t.Fatalf("Expected 1 removed tombstone, got %+v, %v", result, err)
+	}
+	info, _ := a.Graph("Personal")
+	if info.CompactedRevision != 3 {
+		t.Fatalf("Expected the horizon at the acknowledged deletion, got %d", info.CompactedRevision)
+	}
+
+	devices, err := a.Devices("Personal", start)
+	if err != nil || len(devices) != 3 {
+		t.Fatalf("Expected 3 devices, got %v, %v", devices, err)
+	}
+	stale, err := a.StaleDevices(start)
+	if err != nil || len(stale) != 1 || stale[0].Id != "old" {
+		t.Fatalf("Expected the old device to be stale, got %v, %v", stale, err)
+	}
+
+	err = a.RemoveDevice("Personal", "old")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = a.RemoveDevice("Personal", "old")
+	if !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("Expected ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	a, f := setup(t)
+	start := time.Now().Add(-time.Hour)
+	_, err := a.CreateGraph(model.Graph{Name: "Personal", Encrypted: true, KeyCheck: "old-check"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	addFile(t, a, f, "Personal", "old-a", "blob-a", "aaa", start)
+	addFile(t, a, f, "Personal", "old-b", "blob-b", "bbb", start)
+	a.db.Where("file_id = ?", "old-b").Delete(&model.FileMapping{})
+	f.Remove("Personal", "blob-b")
+	a.db.Create(&model.ChangeLogEntry{GraphName: "Personal", FileId: "old-b", Timestamp: start.Add(time.Minute), Operation: model.Deleted, Revision: 3})
+	a.db.Model(&model.FileMapping{}).Where("file_id = ?", "old-a").Update("revision", 1)
+
+	rotation, err := a.StartRotation("Personal", "params", "new-check")
+	if err != nil || len(rotation.Pending) != 2 {
+		t.Fatalf("Expected 2 pending files, got %+v, %v", rotation, err)
+	}
+	_, err = a.StartRotation("Personal", "params", "other-check")
+	if !errors.Is(err, ErrRotationRunning) {
+		t.Fatalf("Expected ErrRotationRunning, got %v", err)
+	}
+
+	err = a.RotateFile("Personal", "old-a", "new-a", nil)
+	if !errors.Is(err, ErrContentRequired) {
+		t.Fatalf("Expected ErrContentRequired, got %v", err)
+	}
+	err = a.RotateFile("Personal", "old-a", "new-a", []byte("AAAA"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = a.CommitRotation("Personal")
+	if !errors.Is(err, ErrRotationIncomplete) {
+		t.Fatalf("Expected ErrRotationIncomplete, got %v", err)
+	}
+
+	// resuming returns the files, that are still pending
+	rotation, err = a.StartRotation("Personal", "params", "new-check")
+	if err != nil || rotation.Rotated != 1 || len(rotation.Pending) != 1 || rotation.Pending[0].Content {
+		t.Fatalf("Expected the deleted file to be pending, got %+v, %v", rotation, err)
+	}
+	err = a.RotateFile("Personal", "old-b", "new-b", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	graph, err := a.CommitRotation("Personal")
+	if err != nil || graph.KeyEpoch != 1 || graph.KeyCheck != "new-check" || graph.KeyParams != "params" {
+		t.Fatalf("Expected the new key, got %+v, %v", graph, err)
+	}
+	var mapping model.FileMapping
+	a.db.Where("graph_name = ? AND file_id = ?", "Personal", "new-a").First(&mapping)
+	content, err := f.Content("Personal", mapping.FileName)
+	if err != nil || string(content) != "AAAA" || mapping.Revision != 1 {
+		t.Fatalf("Expected the rotated content with the old revision, got %s, %+v, %v", content, mapping, err)
+	}
+	var changes int64
+	a.db.Model(&model.ChangeLogEntry{}).Where("file_id IN ?", []string{"new-a", "new-b"}).Count(&changes)
+	if changes != 3 {
+		t.Fatalf("Expected the changes to use the new ids, got %d", changes)
+	}
+	problems, err := a.Verify(false)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("Expected the old content to be removed, got %v, %v", problems, err)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	a, _ := setup(t)
+	_, err := a.CreateGraph(model.Graph{Name: "Personal", Encrypted: true, KeyCheck: "old-check"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	owner := base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	colleague := base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+
+	_, err = a.SaveMember(model.GraphMember{GraphName: "Personal", PublicKey: "invalid", WrappedKey: "key"})
+	if !errors.Is(err, ErrInvalidPublicKey) {
+		t.Fatalf("Expected ErrInvalidPublicKey, got %v", err)
+	}
+	_, err = a.SaveMember(model.GraphMember{GraphName: "Personal", PublicKey: owner, WrappedKey: "owner-key"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = a.AcceptMember("Personal", owner)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// a member invited during a rotation gets access with the new key
+	_, err = a.StartRotation("Personal", "members", "new-check")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = a.SaveMember(model.GraphMember{GraphName: "Personal", PublicKey: colleague, WrappedKey: "colleague-key"})
+	if !errors.Is(err, ErrWrappedKeyRequired) {
+		t.Fatalf("Expected ErrWrappedKeyRequired, got %v", err)
+	}
+	_, err = a.SaveMember(model.GraphMember{GraphName: "Personal", PublicKey: colleague, PendingKey: "colleague-new"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = a.AcceptMember("Personal", colleague)
+	if !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("Expected the invitation to be pending until the rotation is committed, got %v", err)
+	}
+	err = a.CancelRotation("Personal")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	members, err := a.Members("Personal")
+	if err != nil || len(members) != 1 || members[0].PendingKey != "" {
+		t.Fatalf("Expected the canceled invitation to be removed, got %+v, %v", members, err)
+	}
+
+	_, err = a.StartRotation("Personal", "members", "new-check")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = a.SaveMember(model.GraphMember{GraphName: "Personal", PublicKey: colleague, PendingKey: "colleague-new"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = a.CommitRotation("Personal")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// the owner got no pending key and lost access
+	members, err = a.Members("Personal")
+	if err != nil || len(members) != 1 || members[0].PublicKey != colleague || members[0].WrappedKey != "colleague-new" {
+		t.Fatalf("Expected only the colleague with the new key, got %+v, %v", members, err)
+	}
+
+	err = a.RemoveMember("Personal", colleague)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = a.Member("Personal", colleague)
+	if !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("Expected ErrMemberNotFound, got %v", err)
+	}
+}
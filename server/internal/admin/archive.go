@@ -0,0 +1,259 @@
+package admin
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	archiveVersion  = 1
+	manifestName    = "graph.json"
+	blobsFolderName = "blobs"
+)
+
+var ErrInvalidArchive = errors.New("invalid archive")
+
+type archiveManifest struct {
+	Version  int                    `json:"version"`
+	Name     string                 `json:"name"`
+	Exported time.Time              `json:"exported"`
+	Graph    *model.Graph           `json:"graph,omitempty"`
+	Mappings []archiveMapping       `json:"mappings"`
+	Changes  []model.ChangeLogEntry `json:"changes"`
+}
+
+type archiveMapping struct {
+	FileId   string         `json:"file_id"`
+	FileName string         `json:"file_name"`
+	Revision int64          `json:"revision,omitempty"`
+	Size     int64          `json:"size,omitempty"`
+	Hash     string         `json:"hash,omitempty"`
+	Kind     model.FileKind `json:"kind,omitempty"`
+	Mode     uint32         `json:"mode,omitempty"`
+	ModTime  time.Time      `json:"mod_time"`
+}
+
+// Export writes the changes, mappings and stored files of the graph as gzipped tar archive
+func (a Admin) Export(graphName string, w io.Writer) error {
+	info, err := a.Graph(graphName)
+	if err != nil {
+		return err
+	}
+
+	manifest := archiveManifest{
+		Version:  archiveVersion,
+		Name:     graphName,
+		Exported: time.Now(),
+	}
+	if info.Id != "" {
+		manifest.Graph = &info.Graph
+	}
+	var mappings []model.FileMapping
+	err = a.db.Where("graph_name = ?", graphName).Find(&mappings).Error
+	if err != nil {
+		return err
+	}
+	for _, mapping := range mappings {
+		manifest.Mappings = append(manifest.Mappings, archiveMapping{
+			FileId:   mapping.FileId,
+			FileName: mapping.FileName,
+			Revision: mapping.Revision,
+			Size:     mapping.Size,
+			Hash:     mapping.Hash,
+			Kind:     mapping.Kind,
+			Mode:     mapping.Mode,
+			ModTime:  mapping.ModTime,
+		})
+	}
+	err = a.db.Where("graph_name = ?", graphName).Order("revision asc").Find(&manifest.Changes).Error
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	err = writeTarFile(tw, manifestName, content)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := a.files.Blobs(graphName)
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		content, err := a.files.Content(graphName, blob.FileName)
+		if err != nil {
+			return err
+		}
+		err = writeTarFile(tw, path.Join(blobsFolderName, blob.FileName), content)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+// Import restores an exported graph. Without a name, the name of the exported graph is used.
+// The graph must not exist yet. It returns the name of the imported graph.
+func (a Admin) Import(r io.Reader, graphName string) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	// the manifest is always written first
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return "", fmt.Errorf("%w: %s is missing", ErrInvalidArchive, manifestName)
+	}
+	var manifest archiveManifest
+	err = json.NewDecoder(tr).Decode(&manifest)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if manifest.Version != archiveVersion {
+		return "", fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, manifest.Version)
+	}
+
+	if graphName == "" {
+		graphName = manifest.Name
+	}
+	err = ValidateGraphName(graphName)
+	if err != nil {
+		return "", err
+	}
+	_, err = a.Graph(graphName)
+	if err == nil {
+		return "", fmt.Errorf("%w: %s", ErrGraphExists, graphName)
+	}
+	if !errors.Is(err, ErrGraphNotFound) {
+		return "", err
+	}
+
+	err = a.importBlobs(tr, graphName)
+	if err != nil {
+		_ = a.files.RemoveGraph(graphName)
+		return "", err
+	}
+
+	graph := model.Graph{}
+	if manifest.Graph != nil {
+		graph = *manifest.Graph
+	}
+	graph.Id = uuid.New().String()
+	graph.Name = graphName
+	graph.CreatedAt = time.Now()
+	// graphs, that were not registered, have no revision
+	for _, change := range manifest.Changes {
+		graph.Revision = max(graph.Revision, change.Revision)
+	}
+
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&graph).Error
+		if err != nil {
+			return err
+		}
+		for _, mapping := range manifest.Mappings {
+			err := tx.Create(&model.FileMapping{
+				GraphName: graphName,
+				FileId:    mapping.FileId,
+				FileName:  mapping.FileName,
+				Revision:  mapping.Revision,
+				Size:      mapping.Size,
+				Hash:      mapping.Hash,
+				Kind:      mapping.Kind,
+				Mode:      mapping.Mode,
+				ModTime:   mapping.ModTime,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		revisions := true
+		for _, change := range manifest.Changes {
+			change.GraphName = graphName
+			if change.CreatedAt.IsZero() {
+				// archives of older versions have no receive times
+				change.CreatedAt = change.Timestamp
+			}
+			revisions = revisions && change.Revision > 0
+			err := tx.Create(&change).Error
+			if err != nil {
+				return err
+			}
+		}
+		if !revisions {
+			return model.AssignRevisions(tx, graphName)
+		}
+		return nil
+	})
+	if err != nil {
+		_ = a.files.RemoveGraph(graphName)
+		return "", err
+	}
+
+	return graphName, nil
+}
+
+func (a Admin) importBlobs(tr *tar.Reader, graphName string) error {
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		folder, fileName := path.Split(header.Name)
+		if strings.TrimSuffix(folder, "/") != blobsFolderName {
+			return fmt.Errorf("%w: unexpected file %s", ErrInvalidArchive, header.Name)
+		}
+
+		// the file name is validated by the file store
+		err = a.files.Store(graphName, fileName, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+// CompactResult counts the removed changes
+type CompactResult struct {
+	// Changes were replaced by a newer change of the same file
+	Changes int64 `json:"changes"`
+	// Tombstones are deletions, that were the latest change of their file
+	Tombstones int64 `json:"tombstones"`
+}
+
+// Compact collapses the history older than before into the latest state of every file.
+// Changes, that were replaced by a newer change of the same file, are removed, so clients
+// still get the current state of all files. Deletions, that are the latest change of their file,
+// are removed too, once every device seen since before acknowledged them. They move the compaction
+// horizon of the graph: clients, that synced before it, missed the deletions and have to resync.
+// Without a graph name, all graphs are compacted.
+func (a Admin) Compact(graphName string, before time.Time) (CompactResult, error) {
+	names := []string{graphName}
+	if graphName == "" {
+		var err error
+		names, err = a.GraphNames()
+		if err != nil {
+			return CompactResult{}, err
+		}
+	}
+
+	var result CompactResult
+	for _, name := range names {
+		err := a.db.Transaction(func(tx *gorm.DB) error {
+			compacted, err := compactGraph(tx, name, before)
+			if err != nil {
+				return err
+			}
+			result.Changes += compacted.Changes
+			result.Tombstones += compacted.Tombstones
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// compactGraph orders the changes by revision, the timestamps are modification times sent by the clients
+// and a deletion may have the same timestamp as the creation of the file.
+func compactGraph(tx *gorm.DB, graphName string, before time.Time) (CompactResult, error) {
+	replaced := tx.Where("graph_name = ? AND created_at < ?", graphName, before).
+		Where(`EXISTS (SELECT 1 FROM change_log_entries AS newer
+			WHERE newer.graph_name = change_log_entries.graph_name
+			AND newer.file_id = change_log_entries.file_id
+			AND newer.revision > change_log_entries.revision)`).
+		Delete(&model.ChangeLogEntry{})
+	if replaced.Error != nil {
+		return CompactResult{}, replaced.Error
+	}
+	result := CompactResult{Changes: replaced.RowsAffected}
+
+	// the remaining deletions have no newer change
+	tombstonesQuery := tx.Where("graph_name = ? AND created_at < ? AND operation = ?", graphName, before, model.Deleted)
+	acknowledged, err := acknowledgedRevision(tx, graphName, before)
+	if err != nil {
+		return result, err
+	}
+	if acknowledged >= 0 {
+		tombstonesQuery = tombstonesQuery.Where("revision <= ?", acknowledged)
+	}
+	// the query is used to find and to delete the tombstones
+	tombstonesQuery = tombstonesQuery.Session(&gorm.Session{})
+
+	var tombstones []model.ChangeLogEntry
+	err = tombstonesQuery.Find(&tombstones).Error
+	if err != nil || len(tombstones) == 0 {
+		return result, err
+	}
+
+	var horizon int64
+	var horizonTime time.Time
+	for _, tombstone := range tombstones {
+		horizon = max(horizon, tombstone.Revision)
+		if tombstone.CreatedAt.After(horizonTime) {
+			horizonTime = tombstone.CreatedAt
+		}
+	}
+
+	removed := tombstonesQuery.Delete(&model.ChangeLogEntry{})
+	if removed.Error != nil {
+		return result, removed.Error
+	}
+	result.Tombstones = removed.RowsAffected
+
+	err = tx.Model(&model.Graph{}).
+		Where("name = ? AND compacted_revision < ?", graphName, horizon).
+		Updates(map[string]any{"compacted_revision": horizon, "compacted_at": horizonTime}).Error
+	return result, err
+}
+
+// acknowledgedRevision returns the revision, that all devices seen since before acknowledged.
+// Devices, that were not seen since then, have to resync anyway. Without devices, it returns -1.
+func acknowledgedRevision(tx *gorm.DB, graphName string, before time.Time) (int64, error) {
+	var devices []model.Device
+	err := tx.Where("graph_name = ? AND last_seen >= ?", graphName, before).Find(&devices).Error
+	if err != nil || len(devices) == 0 {
+		return -1, err
+	}
+
+	acknowledged := devices[0].Revision
+	for _, device := range devices {
+		acknowledged = min(acknowledged, device.Revision)
+	}
+	return acknowledged, nil
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"time"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+// DeviceInfo is a device with its staleness
+type DeviceInfo struct {
+	model.Device
+	// Stale devices were not seen since the stale time
+	Stale bool `json:"stale"`
+}
+
+// Devices returns the devices of the graph, ordered by their last contact.
+// Without a graph name, the devices of all graphs are returned.
+func (a Admin) Devices(graphName string, staleBefore time.Time) ([]DeviceInfo, error) {
+	tx := a.db.Order("last_seen desc")
+	if graphName != "" {
+		tx = tx.Where("graph_name = ?", graphName)
+	}
+	var devices []model.Device
+	err := tx.Find(&devices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]DeviceInfo, 0, len(devices))
+	for _, device := range devices {
+		infos = append(infos, DeviceInfo{
+			Device: device,
+			Stale:  device.LastSeen.Before(staleBefore),
+		})
+	}
+	return infos, nil
+}
+
+// StaleDevices returns the devices of all graphs, that were not seen since staleBefore
+func (a Admin) StaleDevices(staleBefore time.Time) ([]DeviceInfo, error) {
+	devices, err := a.Devices("", staleBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := make([]DeviceInfo, 0)
+	for _, device := range devices {
+		if device.Stale {
+			stale = append(stale, device)
+		}
+	}
+	return stale, nil
+}
+
+// RemoveDevice forgets a device, e.g. one that is not used anymore.
+// Removed devices no longer hold back the removal of deletions by compactions.
+func (a Admin) RemoveDevice(graphName, deviceId string) error {
+	tx := a.db.Where("graph_name = ? AND id = ?", graphName, deviceId).Delete(&model.Device{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceId)
+	}
+	return nil
+}
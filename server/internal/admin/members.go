@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	ErrMemberNotFound     = errors.New("member not found")
+	ErrInvalidPublicKey   = errors.New("invalid public key")
+	ErrWrappedKeyRequired = errors.New("wrapped key is required")
+)
+
+// Members returns the members of a shared graph in the order they were invited
+func (a Admin) Members(graphName string) ([]model.GraphMember, error) {
+	_, err := a.Graph(graphName)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]model.GraphMember, 0)
+	err = a.db.Where("graph_name = ?", graphName).Order("invited_at").Find(&members).Error
+	return members, err
+}
+
+// Member returns the member of the graph with the public key
+func (a Admin) Member(graphName, publicKey string) (model.GraphMember, error) {
+	var member model.GraphMember
+	err := a.db.Where("graph_name = ? AND public_key = ?", graphName, publicKey).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.GraphMember{}, fmt.Errorf("%w: %s", ErrMemberNotFound, publicKey)
+	}
+	return member, err
+}
+
+// SaveMember invites a member or replaces the wrapped key of a member. During a key rotation only the
+// pending key, that is wrapped for the new key, is stored. It replaces the wrapped key, when the rotation is committed.
+func (a Admin) SaveMember(member model.GraphMember) (model.GraphMember, error) {
+	info, err := a.Graph(member.GraphName)
+	if err != nil {
+		return model.GraphMember{}, err
+	}
+	if !info.Encrypted {
+		return model.GraphMember{}, fmt.Errorf("%w: %s", ErrNotEncrypted, member.GraphName)
+	}
+	err = validatePublicKey(member.PublicKey)
+	if err != nil {
+		return model.GraphMember{}, err
+	}
+
+	var rotations int64
+	err = a.db.Model(&model.KeyRotation{}).Where("graph_name = ?", member.GraphName).Count(&rotations).Error
+	if err != nil {
+		return model.GraphMember{}, err
+	}
+	rotating := rotations > 0
+	if rotating && member.PendingKey == "" {
+		return model.GraphMember{}, fmt.Errorf("%w: the key of the graph is being rotated, the pending key is required", ErrWrappedKeyRequired)
+	}
+	if !rotating && member.PendingKey != "" {
+		return model.GraphMember{}, fmt.Errorf("%w: %s", ErrRotationNotFound, member.GraphName)
+	}
+	if !rotating && member.WrappedKey == "" {
+		return model.GraphMember{}, ErrWrappedKeyRequired
+	}
+
+	saved, err := a.Member(member.GraphName, member.PublicKey)
+	if errors.Is(err, ErrMemberNotFound) {
+		saved = model.GraphMember{GraphName: member.GraphName, PublicKey: member.PublicKey, InvitedAt: time.Now()}
+	} else if err != nil {
+		return model.GraphMember{}, err
+	}
+	if member.Name != "" {
+		saved.Name = member.Name
+	}
+	if rotating {
+		saved.PendingKey = member.PendingKey
+	} else {
+		saved.WrappedKey = member.WrappedKey
+	}
+	err = a.db.Save(&saved).Error
+	return saved, err
+}
+
+// AcceptMember marks the invitation as accepted, members invited during a key rotation can't accept it yet
+func (a Admin) AcceptMember(graphName, publicKey string) error {
+	result := a.db.Model(&model.GraphMember{}).
+		Where("graph_name = ? AND public_key = ? AND wrapped_key <> ''", graphName, publicKey).
+		Update("accepted", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, publicKey)
+	}
+	return nil
+}
+
+// RemoveMember removes the wrapped key of the member. The member still knows the current key,
+// the key has to be rotated afterwards, so that the member can't decrypt new changes.
+func (a Admin) RemoveMember(graphName, publicKey string) error {
+	result := a.db.Where("graph_name = ? AND public_key = ?", graphName, publicKey).Delete(&model.GraphMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, publicKey)
+	}
+	return nil
+}
+
+// commitMemberKeys removes the members without a pending key, they can't decrypt the graph with the new key.
+func commitMemberKeys(tx *gorm.DB, graphName string) error {
+	err := tx.Where("graph_name = ? AND pending_key = ''", graphName).Delete(&model.GraphMember{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&model.GraphMember{}).Where("graph_name = ?", graphName).Updates(map[string]any{
+		"wrapped_key": gorm.Expr("pending_key"),
+		"pending_key": "",
+	}).Error
+}
+
+func discardMemberKeys(tx *gorm.DB, graphName string) error {
+	err := tx.Where("graph_name = ? AND wrapped_key = ''", graphName).Delete(&model.GraphMember{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&model.GraphMember{}).Where("graph_name = ?", graphName).Update("pending_key", "").Error
+}
+
+func validatePublicKey(publicKey string) error {
+	key, err := base64.RawURLEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != 32 {
+		return fmt.Errorf("%w: %s", ErrInvalidPublicKey, publicKey)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package admin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/log"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	ErrNotEncrypted       = errors.New("graph is not encrypted")
+	ErrRotationNotFound   = errors.New("no key rotation is running")
+	ErrRotationRunning    = errors.New("another key rotation is running")
+	ErrRotationIncomplete = errors.New("not all files were rotated")
+	ErrFileNotFound       = errors.New("file not found")
+	ErrContentRequired    = errors.New("the file has content, that has to be rotated")
+)
+
+// RotationInfo is a running rotation with the files, that still have to be encrypted with the new key
+type RotationInfo struct {
+	model.KeyRotation
+	Rotated int64         `json:"rotated"`
+	Pending []PendingFile `json:"pending"`
+}
+
+// PendingFile is a file id of the changes or the manifest, that was not rotated yet.
+// Deleted files and directories have no content.
+type PendingFile struct {
+	FileId  string `json:"file_id"`
+	Content bool   `json:"content"`
+}
+
+// StartRotation starts the rotation to the new key. Starting it again with the same key check
+// returns the running rotation, so that an interrupted rotation can be resumed.
+func (a Admin) StartRotation(graphName, keyParams, keyCheck string) (RotationInfo, error) {
+	info, err := a.Graph(graphName)
+	if err != nil {
+		return RotationInfo{}, err
+	}
+	if !info.Encrypted {
+		return RotationInfo{}, fmt.Errorf("%w: %s", ErrNotEncrypted, graphName)
+	}
+
+	var running []model.KeyRotation
+	err = a.db.Where("graph_name = ?", graphName).Find(&running).Error
+	if err != nil {
+		return RotationInfo{}, err
+	}
+	if len(running) > 0 {
+		if running[0].KeyCheck != keyCheck {
+			return RotationInfo{}, fmt.Errorf("%w: started at %v", ErrRotationRunning, running[0].StartedAt)
+		}
+		return a.Rotation(graphName)
+	}
+
+	err = a.db.Create(&model.KeyRotation{
+		GraphName: graphName,
+		KeyParams: keyParams,
+		KeyCheck:  keyCheck,
+		StartedAt: time.Now(),
+	}).Error
+	if err != nil {
+		return RotationInfo{}, err
+	}
+	return a.Rotation(graphName)
+}
+
+// Rotation returns the running rotation of the graph
+func (a Admin) Rotation(graphName string) (RotationInfo, error) {
+	var rotation model.KeyRotation
+	err := a.db.Where("graph_name = ?", graphName).First(&rotation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return RotationInfo{}, fmt.Errorf("%w: %s", ErrRotationNotFound, graphName)
+	}
+	if err != nil {
+		return RotationInfo{}, err
+	}
+
+	info := RotationInfo{KeyRotation: rotation}
+	err = a.db.Model(&model.RotatedFile{}).Where("graph_name = ?", graphName).Count(&info.Rotated).Error
+	if err != nil {
+		return RotationInfo{}, err
+	}
+	info.Pending, err = pendingFiles(a.db, graphName)
+	return info, err
+}
+
+func pendingFiles(tx *gorm.DB, graphName string) ([]PendingFile, error) {
+	var rotated []string
+	err := tx.Model(&model.RotatedFile{}).Where("graph_name = ?", graphName).Pluck("file_id", &rotated).Error
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(rotated))
+	for _, fileId := range rotated {
+		done[fileId] = true
+	}
+
+	var mappings []model.FileMapping
+	err = tx.Where("graph_name = ?", graphName).Order("file_id").Find(&mappings).Error
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]PendingFile, 0)
+	for _, mapping := range mappings {
+		if !done[mapping.FileId] {
+			pending = append(pending, PendingFile{FileId: mapping.FileId, Content: mapping.Kind != model.Directory})
+		}
+		done[mapping.FileId] = true
+	}
+
+	// deleted files are only referenced by their changes
+	var fileIds []string
+	err = tx.Model(&model.ChangeLogEntry{}).Where("graph_name = ?", graphName).
+		Distinct("file_id").Order("file_id").Pluck("file_id", &fileIds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, fileId := range fileIds {
+		if !done[fileId] {
+			pending = append(pending, PendingFile{FileId: fileId})
+		}
+	}
+	return pending, nil
+}
+
+// RotateFile stages the file encrypted with the new key. Rotating a file again replaces the staged file.
+func (a Admin) RotateFile(graphName, fileId, newFileId string, content []byte) error {
+	_, err := a.Rotation(graphName)
+	if err != nil {
+		return err
+	}
+
+	hasContent, err := a.fileHasContent(graphName, fileId)
+	if err != nil {
+		return err
+	}
+	if hasContent && content == nil {
+		return fmt.Errorf("%w: %s", ErrContentRequired, fileId)
+	}
+
+	rotated := model.RotatedFile{GraphName: graphName, FileId: fileId, NewFileId: newFileId}
+	if hasContent {
+		rotated.FileName = uuid.New().String()
+		rotated.Size = int64(len(content))
+		rotated.Hash = files.Hash(content)
+		err = a.files.Store(graphName, rotated.FileName, bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+	}
+
+	var previous []model.RotatedFile
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("graph_name = ? AND file_id = ?", graphName, fileId).Find(&previous).Error
+		if err != nil {
+			return err
+		}
+		return tx.Save(&rotated).Error
+	})
+	if err != nil {
+		a.removeStaged([]model.RotatedFile{rotated})
+		return err
+	}
+	a.removeStaged(previous)
+	return nil
+}
+
+func (a Admin) fileHasContent(graphName, fileId string) (bool, error) {
+	var mappings []model.FileMapping
+	err := a.db.Where("graph_name = ? AND file_id = ?", graphName, fileId).Find(&mappings).Error
+	if err != nil {
+		return false, err
+	}
+	if len(mappings) > 0 {
+		return mappings[0].Kind != model.Directory, nil
+	}
+
+	var changes int64
+	err = a.db.Model(&model.ChangeLogEntry{}).Where("graph_name = ? AND file_id = ?", graphName, fileId).Count(&changes).Error
+	if err != nil {
+		return false, err
+	}
+	if changes == 0 {
+		return false, fmt.Errorf("%w: %s", ErrFileNotFound, fileId)
+	}
+	return false, nil
+}
+
+// CommitRotation replaces the file ids of the changes and the files of the graph with the rotated ones
+// and stores the new key. The revisions of the files are kept, so clients continue their sync
+// once they use the new key. Members of a shared graph keep access, if the new key was wrapped for them.
+func (a Admin) CommitRotation(graphName string) (model.Graph, error) {
+	var replaced []model.FileMapping
+	var graph model.Graph
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		var rotation model.KeyRotation
+		err := tx.Where("graph_name = ?", graphName).First(&rotation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s", ErrRotationNotFound, graphName)
+		}
+		if err != nil {
+			return err
+		}
+
+		pending, err := pendingFiles(tx, graphName)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%w: %d files are pending", ErrRotationIncomplete, len(pending))
+		}
+
+		var rotated []model.RotatedFile
+		err = tx.Where("graph_name = ?", graphName).Find(&rotated).Error
+		if err != nil {
+			return err
+		}
+		for _, file := range rotated {
+			mapping, err := rotateMapping(tx, file)
+			if err != nil {
+				return err
+			}
+			if mapping.FileName != "" {
+				replaced = append(replaced, mapping)
+			}
+			err = tx.Model(&model.ChangeLogEntry{}).
+				Where("graph_name = ? AND file_id = ?", graphName, file.FileId).
+				Update("file_id", file.NewFileId).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = commitMemberKeys(tx, graphName)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&model.Graph{}).Where("name = ?", graphName).Updates(map[string]any{
+			"key_params": rotation.KeyParams,
+			"key_check":  rotation.KeyCheck,
+			"key_epoch":  gorm.Expr("key_epoch + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("graph_name = ?", graphName).Delete(&model.RotatedFile{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Delete(&rotation).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("name = ?", graphName).First(&graph).Error
+	})
+	if err != nil {
+		return model.Graph{}, err
+	}
+
+	// the content encrypted with the old key is not referenced anymore
+	for _, mapping := range replaced {
+		err = a.files.Remove(graphName, mapping.FileName)
+		if err != nil {
+			log.Error("Could not remove rotated file", "graph", graphName, "file", mapping.FileName, "error", err)
+		}
+	}
+	return graph, nil
+}
+
+func rotateMapping(tx *gorm.DB, file model.RotatedFile) (model.FileMapping, error) {
+	var mappings []model.FileMapping
+	err := tx.Where("graph_name = ? AND file_id = ?", file.GraphName, file.FileId).Find(&mappings).Error
+	if err != nil || len(mappings) == 0 {
+		return model.FileMapping{}, err
+	}
+
+	old := mappings[0]
+	err = tx.Delete(&old).Error
+	if err != nil {
+		return model.FileMapping{}, err
+	}
+	mapping := old
+	mapping.FileId = file.NewFileId
+	if file.FileName != "" {
+		mapping.FileName = file.FileName
+		mapping.Size = file.Size
+		mapping.Hash = file.Hash
+	}
+	err = tx.Create(&mapping).Error
+	if err != nil {
+		return model.FileMapping{}, err
+	}
+	if file.FileName == "" {
+		// directories keep their mapping without content
+		old.FileName = ""
+	}
+	return old, nil
+}
+
+// CancelRotation discards the running rotation, its staged files and the keys wrapped for the new key
+func (a Admin) CancelRotation(graphName string) error {
+	var rotated []model.RotatedFile
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("graph_name = ?", graphName).Delete(&model.KeyRotation{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %s", ErrRotationNotFound, graphName)
+		}
+		err := tx.Where("graph_name = ?", graphName).Find(&rotated).Error
+		if err != nil {
+			return err
+		}
+		err = discardMemberKeys(tx, graphName)
+		if err != nil {
+			return err
+		}
+		return tx.Where("graph_name = ?", graphName).Delete(&model.RotatedFile{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	a.removeStaged(rotated)
+	return nil
+}
+
+func (a Admin) removeStaged(rotated []model.RotatedFile) {
+	for _, file := range rotated {
+		if file.FileName == "" {
+			continue
+		}
+		err := a.files.Remove(file.GraphName, file.FileName)
+		if err != nil {
+			log.Error("Could not remove staged file", "graph", file.GraphName, "file", file.FileName, "error", err)
+		}
+	}
+}
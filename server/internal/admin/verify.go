@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"github.com/soerenchrist/logsync/server/internal/model"
+)
+
+type ProblemKind string
+
+const (
+	// MissingBlob the stored file of a mapping does not exist. The content is lost, a client has to upload it again.
+	MissingBlob ProblemKind = "missing blob"
+	// OrphanedBlob a stored file is not referenced by any mapping
+	OrphanedBlob ProblemKind = "orphaned blob"
+	// StaleMapping the latest change of the file is a deletion or there is no change at all
+	StaleMapping ProblemKind = "stale mapping"
+)
+
+type Problem struct {
+	Graph    string      `json:"graph"`
+	Kind     ProblemKind `json:"kind"`
+	FileId   string      `json:"file_id,omitempty"`
+	FileName string      `json:"file_name"`
+	// Fixed is set, when the problem was repaired
+	Fixed bool `json:"fixed"`
+}
+
+// Verify checks that every mapping has a stored file and every stored file a mapping.
+// With fix, orphaned blobs and stale mappings are removed. Missing blobs can't be fixed.
+func (a Admin) Verify(fix bool) ([]Problem, error) {
+	names, err := a.GraphNames()
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]Problem, 0)
+	for _, name := range names {
+		graphProblems, err := a.verifyGraph(name, fix)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, graphProblems...)
+	}
+	return problems, nil
+}
+
+func (a Admin) verifyGraph(graphName string, fix bool) ([]Problem, error) {
+	var mappings []model.FileMapping
+	err := a.db.Where("graph_name = ?", graphName).Find(&mappings).Error
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := a.latestChanges(graphName)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := a.files.Blobs(graphName)
+	if err != nil {
+		return nil, err
+	}
+	blobNames := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		blobNames[blob.FileName] = true
+	}
+
+	// the staged files of a running key rotation are referenced, once it is committed
+	var staged []string
+	err = a.db.Model(&model.RotatedFile{}).Where("graph_name = ? AND file_name <> ''", graphName).Pluck("file_name", &staged).Error
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]Problem, 0)
+	referenced := make(map[string]bool, len(mappings)+len(staged))
+	for _, fileName := range staged {
+		referenced[fileName] = true
+	}
+	for _, mapping := range mappings {
+		referenced[mapping.FileName] = true
+		change, ok := latest[mapping.FileId]
+		if !ok || change.Operation == model.Deleted {
+			problem := Problem{Graph: graphName, Kind: StaleMapping, FileId: mapping.FileId, FileName: mapping.FileName}
+			if fix {
+				err = a.db.Delete(&mapping).Error
+				if err != nil {
+					return nil, err
+				}
+				problem.Fixed = true
+				// the blob of the mapping is now orphaned and removed below
+				referenced[mapping.FileName] = false
+			}
+			problems = append(problems, problem)
+			continue
+		}
+
+		// directories have no content stored
+		if change.Kind != model.Directory && !blobNames[mapping.FileName] {
+			problems = append(problems, Problem{Graph: graphName, Kind: MissingBlob, FileId: mapping.FileId, FileName: mapping.FileName})
+		}
+	}
+
+	for _, blob := range blobs {
+		if referenced[blob.FileName] {
+			continue
+		}
+		problem := Problem{Graph: graphName, Kind: OrphanedBlob, FileName: blob.FileName}
+		if fix {
+			err = a.files.Remove(graphName, blob.FileName)
+			if err != nil {
+				return nil, err
+			}
+			problem.Fixed = true
+		}
+		// orphans of removed stale mappings were already reported
+		if _, wasMapped := referenced[blob.FileName]; !wasMapped {
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems, nil
+}
+
+func (a Admin) latestChanges(graphName string) (map[string]model.ChangeLogEntry, error) {
+	var changes []model.ChangeLogEntry
+	err := a.db.Where("graph_name = ?", graphName).Order("revision asc").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]model.ChangeLogEntry, len(changes))
+	for _, change := range changes {
+		latest[change.FileId] = change
+	}
+	return latest, nil
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+func (a *app) newDevicesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "devices",
+		Short: "Manage the devices syncing the graphs",
+		Args:  usageArgs(cobra.NoArgs),
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list [graph]",
+			Short: "List the devices with their last sync",
+			Long:  "List the devices with their acknowledged revision and last sync. Devices, that were not seen for devices.staledays, are stale.",
+			Args:  usageArgs(cobra.MaximumNArgs(1)),
+			RunE:  a.runListDevices,
+		},
+		&cobra.Command{
+			Use:   "remove <graph> <device>",
+			Short: "Forget a device, that is not used anymore",
+			Long:  "Forget a device, that is not used anymore. Removed devices no longer hold back the removal of deletions by compactions.",
+			Args:  usageArgs(cobra.ExactArgs(2)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return a.runRemoveDevice(args[0], args[1])
+			},
+		},
+	)
+	return cmd
+}
+
+func (a *app) runListDevices(cmd *cobra.Command, args []string) error {
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	graphName := ""
+	if len(args) > 0 {
+		graphName = args[0]
+	}
+	devices, err := adm.Devices(graphName, conf.Devices.StaleBefore())
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(devices)
+	}
+	a.printf("%-20s %-36s %-20s %8s  %s\n", "GRAPH", "DEVICE", "NAME", "REVISION", "LAST SYNC")
+	for _, device := range devices {
+		lastSync := "-"
+		if !device.Acknowledged.IsZero() {
+			lastSync = humanize.Time(device.Acknowledged)
+		}
+		if device.Stale {
+			lastSync += " (stale)"
+		}
+		a.printf("%-20s %-36s %-20s %8d  %s\n", device.GraphName, device.Id, device.Name, device.Revision, lastSync)
+	}
+	return nil
+}
+
+func (a *app) runRemoveDevice(graphName, deviceId string) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	err = adm.RemoveDevice(graphName, deviceId)
+	if err != nil {
+		return err
+	}
+	a.printf("Removed device %s of graph %s\n", deviceId, graphName)
+	return nil
+}
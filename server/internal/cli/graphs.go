@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func (a *app) newGraphsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "graphs",
+		Short: "Manage the stored graphs",
+		Args:  usageArgs(cobra.NoArgs),
+	}
+
+	var yes bool
+	deleteCmd := &cobra.Command{
+		Use:   "delete <graph>",
+		Short: "Delete the changes and all stored files of a graph",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !yes {
+				return usageError{errors.New("deleting a graph can't be undone, confirm with --yes")}
+			}
+			return a.runDeleteGraph(args[0])
+		},
+	}
+	deleteCmd.Flags().BoolVar(&yes, "yes", false, "confirm the deletion")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the graphs with their number of files and size",
+			Args:  usageArgs(cobra.NoArgs),
+			RunE:  a.runListGraphs,
+		},
+		deleteCmd,
+		&cobra.Command{
+			Use:   "rename <graph> <new name>",
+			Short: "Rename a graph",
+			Long:  "Rename a graph. The graph directories of the clients have to be renamed as well.",
+			Args:  usageArgs(cobra.ExactArgs(2)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return a.runRenameGraph(args[0], args[1])
+			},
+		},
+	)
+	return cmd
+}
+
+func (a *app) runListGraphs(cmd *cobra.Command, args []string) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	graphs, err := adm.Graphs()
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(graphs)
+	}
+	a.printf("%-30s %8s %8s %10s  %s\n", "NAME", "FILES", "CHANGES", "SIZE", "LAST CHANGE")
+	for _, graph := range graphs {
+		lastChange := "-"
+		if !graph.LastChange.IsZero() {
+			lastChange = graph.LastChange.Local().Format(time.DateTime)
+		}
+		a.printf("%-30s %8d %8d %10s  %s\n", graph.Name, graph.Files, graph.Changes, humanize.Bytes(uint64(graph.Size)), lastChange)
+	}
+	return nil
+}
+
+func (a *app) runDeleteGraph(name string) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	err = adm.DeleteGraph(name)
+	if err != nil {
+		return err
+	}
+	a.printf("Deleted graph %s\n", name)
+	return nil
+}
+
+func (a *app) runRenameGraph(oldName, newName string) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	err = adm.RenameGraph(oldName, newName)
+	if err != nil {
+		return err
+	}
+	a.printf("Renamed graph %s to %s\n", oldName, newName)
+	return nil
+}
+
+var errProblemsFound = errors.New("problems found")
+
+func (a *app) newVerifyCmd() *cobra.Command {
+	var fix bool
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check that every mapping has a stored file and every stored file a mapping",
+		Long: "Check that every mapping has a stored file and every stored file a mapping.\n" +
+			"With --fix, stored files without mapping and mappings of deleted files are removed.\n" +
+			"Missing files can't be fixed on the server, they have to be uploaded again by a client.\n" +
+			"Exits with 1, when there are problems, that were not fixed.",
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runVerify(fix)
+		},
+	}
+	cmd.Flags().BoolVar(&fix, "fix", false, "remove orphaned files and stale mappings")
+	return cmd
+}
+
+func (a *app) runVerify(fix bool) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	problems, err := adm.Verify(fix)
+	if err != nil {
+		return err
+	}
+
+	unfixed := 0
+	for _, problem := range problems {
+		if !problem.Fixed {
+			unfixed++
+		}
+	}
+
+	if a.json {
+		err = a.printJSON(problems)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, problem := range problems {
+			status := ""
+			if problem.Fixed {
+				status = " (fixed)"
+			}
+			a.printf("%s: %s %s %s%s\n", problem.Graph, problem.Kind, problem.FileName, problem.FileId, status)
+		}
+		if len(problems) == 0 {
+			a.printf("No problems found\n")
+		}
+	}
+
+	if unfixed > 0 {
+		return fmt.Errorf("%w: %d", errProblemsFound, unfixed)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"errors"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+func (a *app) newCompactCmd() *cobra.Command {
+	var graphName string
+	var olderThan time.Duration
+	cmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Collapse the change log into the latest state of every file",
+		Long: "Remove the changes, that were replaced by a newer change of the same file, and the deletions.\n" +
+			"The latest change of every file is kept, so clients still get the current state of all files.\n" +
+			"Clients, that synced before a removed deletion, have to resync.",
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if olderThan < 0 {
+				return usageError{errors.New("--older-than must not be negative")}
+			}
+			return a.runCompact(graphName, olderThan)
+		},
+	}
+	cmd.Flags().StringVarP(&graphName, "graph", "g", "", "only compact the given graph")
+	cmd.Flags().DurationVar(&olderThan, "older-than", 0, "only remove changes older than the duration, e.g. 720h")
+	return cmd
+}
+
+func (a *app) runCompact(graphName string, olderThan time.Duration) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	result, err := adm.Compact(graphName, time.Now().Add(-olderThan))
+	if err != nil {
+		return err
+	}
+
+	if a.json {
+		return a.printJSON(result)
+	}
+	a.printf("Removed %d changes and %d deletions\n", result.Changes, result.Tombstones)
+	return nil
+}
+
+func (a *app) newVacuumCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "vacuum",
+		Short: "Rebuild the database file to free unused space",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			adm, err := a.openAdmin()
+			if err != nil {
+				return err
+			}
+			return adm.Vacuum()
+		},
+	}
+}
+
+func (a *app) newExportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "export <graph> <file>",
+		Short: "Export a graph with its changes and stored files to a .tar.gz archive",
+		Long:  "Export a graph with its changes and stored files to a .tar.gz archive. Use - as file to write to stdout.",
+		Args:  usageArgs(cobra.ExactArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runExport(args[0], args[1])
+		},
+	}
+}
+
+func (a *app) runExport(graphName, fileName string) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	if fileName == "-" {
+		return adm.Export(graphName, a.out)
+	}
+
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = adm.Export(graphName, file)
+	closeErr := file.Close()
+	if err != nil {
+		_ = os.Remove(fileName)
+		return err
+	}
+	return closeErr
+}
+
+func (a *app) newImportCmd() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import a graph exported by export",
+		Long:  "Import a graph exported by export. The graph must not exist yet. Use - as file to read from stdin.",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runImport(args[0], name)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "import the graph with another name")
+	return cmd
+}
+
+func (a *app) runImport(fileName, graphName string) error {
+	adm, err := a.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if fileName != "-" {
+		in, err = os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	imported, err := adm.Import(in, graphName)
+	if err != nil {
+		return err
+	}
+	a.printf("Imported graph %s\n", imported)
+	return nil
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/config"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/log"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+const (
+	ExitOK    = 0
+	ExitError = 1
+	// ExitUsage invalid arguments, flags or configuration
+	ExitUsage = 2
+)
+
+type app struct {
+	configFile string
+	json       bool
+
+	out    io.Writer
+	errOut io.Writer
+}
+
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+// Execute runs the command given by the arguments of the process and returns the exit code
+func Execute() int {
+	a := &app{out: os.Stdout, errOut: os.Stderr}
+	return a.execute(os.Args[1:])
+}
+
+func (a *app) execute(args []string) int {
+	cmd := a.newRootCmd()
+	cmd.SetArgs(args)
+	cmd.SetOut(a.out)
+	cmd.SetErr(a.errOut)
+
+	err := cmd.Execute()
+	if err == nil {
+		return ExitOK
+	}
+
+	fmt.Fprintf(a.errOut, "Error: %v\n", err)
+	if errors.As(err, &usageError{}) {
+		return ExitUsage
+	}
+	return ExitError
+}
+
+func (a *app) newRootCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logsync-server",
+		Short: "Server to sync logseq graphs",
+		Long: "Server to sync logseq graphs. Without a command, the server is started.\n" +
+			"The maintenance commands work directly on db.path and files.path, stop the server before running them.",
+		Args:          usageArgs(cobra.NoArgs),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE:          a.runServe,
+	}
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err}
+	})
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&a.configFile, "config", "", "path of the config file")
+	flags.BoolVar(&a.json, "json", false, "print the output as json")
+
+	cmd.AddCommand(
+		a.newServeCmd(),
+		a.newGraphsCmd(),
+		a.newDevicesCmd(),
+		a.newVerifyCmd(),
+		a.newCompactCmd(),
+		a.newVacuumCmd(),
+		a.newExportCmd(),
+		a.newImportCmd(),
+	)
+	return cmd
+}
+
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		err := validate(cmd, args)
+		if err != nil {
+			return usageError{err}
+		}
+		return nil
+	}
+}
+
+func (a *app) readConfig() (config.Config, error) {
+	if a.configFile != "" {
+		config.SetFile(a.configFile)
+	}
+
+	conf, err := config.Read()
+	if err != nil {
+		return config.Config{}, usageError{fmt.Errorf("could not read config: %w", err)}
+	}
+	return conf, nil
+}
+
+// openAdmin logs to stderr, so the output of the maintenance commands stays parsable.
+func (a *app) openAdmin() (admin.Admin, error) {
+	conf, err := a.readConfig()
+	if err != nil {
+		return admin.Admin{}, err
+	}
+	log.NewWithWriter(a.errOut, conf.Logging.Level)
+
+	db, err := model.CreateDb(conf.Db.Path)
+	if err != nil {
+		return admin.Admin{}, err
+	}
+
+	return admin.New(db, files.New(conf.Files.Path)), nil
+}
+
+func (a *app) printJSON(v any) error {
+	encoder := json.NewEncoder(a.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (a *app) printf(format string, args ...any) {
+	fmt.Fprintf(a.out, format, args...)
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	slogchi "github.com/samber/slog-chi"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/config"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/log"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"github.com/soerenchrist/logsync/server/internal/routes"
+	"github.com/spf13/cobra"
+	"net/http"
+	"time"
+)
+
+func (a *app) newServeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Start the server",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE:  a.runServe,
+	}
+}
+
+func (a *app) runServe(cmd *cobra.Command, args []string) error {
+	conf, err := a.readConfig()
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(conf.Logging.Level)
+
+	r := chi.NewRouter()
+	r.Use(slogchi.New(logger))
+	r.Use(middleware.Recoverer)
+	r.Use(routes.CreateApiTokenMiddleware(conf))
+	r.Use(routes.Scope)
+	r.Use(routes.EscapedPath)
+	r.Use(routes.DecompressRequest)
+	r.Use(middleware.Compress(5, "text/plain", "text/markdown", "text/html", "application/json"))
+
+	db, err := model.CreateDb(conf.Db.Path)
+	if err != nil {
+		return err
+	}
+
+	f := files.New(conf.Files.Path)
+	warnInvalidGraphNames(admin.New(db, f))
+
+	c := routes.NewController(db, r, f, conf)
+	c.MapEndpoints()
+
+	go maintainPeriodically(admin.New(db, f), conf)
+
+	log.Info("Server is listening", "url", conf.Url())
+	return http.ListenAndServe(conf.Url(), r)
+}
+
+func warnInvalidGraphNames(adm admin.Admin) {
+	names, err := adm.InvalidGraphNames()
+	if err != nil {
+		log.Error("Could not check the graph names", "error", err)
+		return
+	}
+	for _, name := range names {
+		log.Warn("Graph name is not valid anymore, clients can't sync it until it is renamed with logsync-server graphs rename",
+			"graph", name)
+	}
+}
+
+func maintainPeriodically(adm admin.Admin, conf config.Config) {
+	for {
+		if conf.Retention.Days > 0 {
+			before := time.Now().AddDate(0, 0, -conf.Retention.Days)
+			result, err := adm.Compact("", before)
+			if err != nil {
+				log.Error("Could not compact the change log", "error", err)
+			} else {
+				log.Info("Compacted the change log", "before", before, "changes", result.Changes, "tombstones", result.Tombstones)
+			}
+		}
+
+		stale, err := adm.StaleDevices(conf.Devices.StaleBefore())
+		if err != nil {
+			log.Error("Could not check the devices", "error", err)
+		}
+		for _, device := range stale {
+			log.Warn("Device did not sync for a long time", "graph", device.GraphName, "device", device.Id,
+				"name", device.Name, "last_seen", device.LastSeen, "revision", device.Revision)
+		}
+
+		if conf.Retention.Interval <= 0 {
+			return
+		}
+		time.Sleep(conf.Retention.Interval)
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"fmt"
 	"github.com/spf13/viper"
 	"log/slog"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	Server  ServerConfig
-	Files   FilesConfig
-	Db      DbConfig
-	Logging LoggingConfig
+	Server    ServerConfig
+	Files     FilesConfig
+	Db        DbConfig
+	Logging   LoggingConfig
+	Graphs    GraphsConfig
+	Retention RetentionConfig
+	Devices   DevicesConfig
+	Deletes   DeletesConfig
 }
 
 type ServerConfig struct {
@@ -22,12 +28,77 @@ type ServerConfig struct {
 
 type FilesConfig struct {
 	Path string
+	// MaxSize of an upload in megabytes, 0 allows any size
+	MaxSize int64
 }
 
 type DbConfig struct {
 	Path string
 }
 
+type GraphsConfig struct {
+	// AutoCreate creates unknown graphs on the first upload instead of rejecting it
+	AutoCreate bool
+}
+
+type RetentionConfig struct {
+	// Days of history, that are kept. Older changes are compacted, 0 keeps them forever.
+	Days int
+	// Interval between the compactions
+	Interval time.Duration
+}
+
+type DevicesConfig struct {
+	// StaleDays after the last contact of a device, it is reported as stale
+	StaleDays int
+}
+
+// StaleBefore devices, that were not seen since then, are stale
+func (d DevicesConfig) StaleBefore() time.Time {
+	return time.Now().AddDate(0, 0, -d.StaleDays)
+}
+
+type DeletesConfig struct {
+	// Limit of the deletions of a transaction, clients have to confirm deletions above it
+	Limit DeleteLimit
+	// Window in which the deletions of a device count towards the limit, even when they are sent in several transactions
+	Window time.Duration
+}
+
+// minDeleteLimit keeps percentages from rejecting the first deletions of small graphs
+const minDeleteLimit = 10
+
+// DeleteLimit is the number of deletions, either absolute or as percentage of the files of the graph.
+// The zero value does not limit the deletions.
+type DeleteLimit struct {
+	Count   int64
+	Percent int64
+}
+
+// ParseDeleteLimit parses a number of files or a percentage like 50%, 0 disables the limit
+func ParseDeleteLimit(value string) (DeleteLimit, error) {
+	number, isPercent := strings.CutSuffix(strings.TrimSpace(value), "%")
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || isPercent && n > 100 {
+		return DeleteLimit{}, fmt.Errorf("invalid delete limit %q, expected a number of files or a percentage like 50%%", value)
+	}
+	if isPercent {
+		return DeleteLimit{Percent: n}, nil
+	}
+	return DeleteLimit{Count: n}, nil
+}
+
+// Exceeded reports if the deletions exceed the limit of a graph with the number of files
+func (l DeleteLimit) Exceeded(deletions, files int64) bool {
+	switch {
+	case l.Count > 0:
+		return deletions > l.Count
+	case l.Percent > 0:
+		return deletions > max(files*l.Percent/100, minDeleteLimit)
+	}
+	return false
+}
+
 type LoggingConfig struct {
 	Level slog.Level
 }
@@ -36,6 +107,11 @@ func (c Config) Url() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
 
+// SetFile reads the config from the given file instead of searching the default locations
+func SetFile(path string) {
+	viper.SetConfigFile(path)
+}
+
 func Read() (Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
@@ -55,15 +131,27 @@ func Read() (Config, error) {
 		}
 	}
 
-	return getConfig(), nil
+	conf := getConfig()
+	conf.Deletes.Limit, err = ParseDeleteLimit(viper.GetString("deletes.limit"))
+	if err != nil {
+		return Config{}, fmt.Errorf("deletes.limit: %w", err)
+	}
+	return conf, nil
 }
 
 func defineDefaults() {
 	viper.SetDefault("db.path", "logsync.db")
 	viper.SetDefault("files.path", "files")
+	viper.SetDefault("files.maxsize", 0)
 	viper.SetDefault("server.host", "localhost")
 	viper.SetDefault("server.port", 3000)
 	viper.SetDefault("log.level", "info")
+	viper.SetDefault("graphs.autocreate", false)
+	viper.SetDefault("retention.days", 0)
+	viper.SetDefault("retention.interval", "24h")
+	viper.SetDefault("devices.staledays", 30)
+	viper.SetDefault("deletes.limit", "50%")
+	viper.SetDefault("deletes.window", "1h")
 }
 
 func getConfig() Config {
@@ -74,7 +162,8 @@ func getConfig() Config {
 			ApiToken: viper.GetString("server.apitoken"),
 		},
 		Files: FilesConfig{
-			Path: viper.GetString("files.path"),
+			Path:    viper.GetString("files.path"),
+			MaxSize: viper.GetInt64("files.maxsize"),
 		},
 		Db: DbConfig{
 			Path: viper.GetString("db.path"),
@@ -82,6 +171,19 @@ func getConfig() Config {
 		Logging: LoggingConfig{
 			Level: getLogLevel(),
 		},
+		Graphs: GraphsConfig{
+			AutoCreate: viper.GetBool("graphs.autocreate"),
+		},
+		Retention: RetentionConfig{
+			Days:     viper.GetInt("retention.days"),
+			Interval: viper.GetDuration("retention.interval"),
+		},
+		Devices: DevicesConfig{
+			StaleDays: viper.GetInt("devices.staledays"),
+		},
+		Deletes: DeletesConfig{
+			Window: viper.GetDuration("deletes.window"),
+		},
 	}
 }
 
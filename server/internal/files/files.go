@@ -1,16 +1,29 @@
 package files
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
-	"path"
+	"strings"
 )
 
 type FileStore interface {
 	Store(graphName string, fileName string, reader io.Reader) error
 	Remove(graphName string, fileName string) error
 	Content(graphName string, fileName string) ([]byte, error)
+	Graphs() ([]string, error)
+	Blobs(graphName string) ([]Blob, error)
+	RemoveGraph(graphName string) error
+	RenameGraph(oldName string, newName string) error
+}
+
+// Blob is a stored file of a graph
+type Blob struct {
+	FileName string
+	Size     int64
 }
 
 type Files struct {
@@ -29,7 +42,10 @@ func (f Files) Store(graphName string, fileName string, reader io.Reader) error
 		return err
 	}
 
-	filePath := path.Join(f.basePath, graphName, fileName)
+	filePath, err := confine(f.basePath, graphName, fileName)
+	if err != nil {
+		return err
+	}
 	file, err := os.Create(filePath)
 	if err != nil {
 		return err
@@ -45,24 +61,116 @@ func (f Files) Store(graphName string, fileName string, reader io.Reader) error
 }
 
 func (f Files) Remove(graphName string, fileName string) error {
-	filePath := path.Join(f.basePath, graphName, fileName)
+	filePath, err := confine(f.basePath, graphName, fileName)
+	if err != nil {
+		return err
+	}
 
 	return os.Remove(filePath)
 }
 
 func (f Files) Content(graphName string, fileName string) ([]byte, error) {
-	filePath := path.Join(f.basePath, graphName, fileName)
+	filePath, err := confine(f.basePath, graphName, fileName)
+	if err != nil {
+		return nil, err
+	}
 
 	return os.ReadFile(filePath)
 }
 
+// Graphs returns the names of all graph directories, also of graphs with names, that are not valid anymore
+func (f Files) Graphs() ([]string, error) {
+	entries, err := os.ReadDir(f.basePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	graphs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			graphs = append(graphs, entry.Name())
+		}
+	}
+	return graphs, nil
+}
+
+// Blobs returns the stored files of the graph. A graph without a directory has no blobs.
+func (f Files) Blobs(graphName string) ([]Blob, error) {
+	graphPath, err := confineExisting(f.basePath, graphName)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(graphPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Blob{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]Blob, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, Blob{FileName: entry.Name(), Size: info.Size()})
+	}
+	return blobs, nil
+}
+
+// RemoveGraph removes the directory of the graph with all stored files
+func (f Files) RemoveGraph(graphName string) error {
+	graphPath, err := confineExisting(f.basePath, graphName)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(graphPath)
+}
+
+// RenameGraph moves the stored files to the directory of the new name, which must not exist yet.
+// Only the new name has to be valid, so graphs with names of older versions can be renamed.
+func (f Files) RenameGraph(oldName string, newName string) error {
+	oldPath, err := confineExisting(f.basePath, oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := confine(f.basePath, newName)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(newPath)
+	if err == nil {
+		return fmt.Errorf("%w: %s", os.ErrExist, newPath)
+	}
+
+	err = os.Rename(oldPath, newPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// graphs without stored files have no directory
+		return nil
+	}
+	return err
+}
+
 func (f Files) ensureGraphDirExists(graphName string) error {
 	err := f.ensureExists(f.basePath)
 	if err != nil {
 		return err
 	}
 
-	graphPath := path.Join(f.basePath, graphName)
+	graphPath, err := confine(f.basePath, graphName)
+	if err != nil {
+		return err
+	}
 	return f.ensureExists(graphPath)
 }
 
@@ -77,3 +185,9 @@ func (f Files) ensureExists(path string) error {
 
 	return nil
 }
+
+// Hash returns the hex encoded sha256 of the content
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	valid := []string{"Personal", "My Graph", "graph-1_2.0", "Notizen Ä", "6f1c2a4e-0c1b-4f5e-9a3d-2b7e8c9d0f1a"}
+	for _, name := range valid {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateName(name)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		})
+	}
+
+	invalid := []string{"", ".", "..", "../etc", "a/b", "a\\b", ".hidden", "trailing.", "trailing ", "a\x00b", strings.Repeat("a", 129)}
+	for _, name := range invalid {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateName(name)
+			if !errors.Is(err, ErrInvalidName) {
+				t.Fatalf("Expected ErrInvalidName, got %v", err)
+			}
+		})
+	}
+}
+
+func FuzzFiles(f *testing.F) {
+	f.Add("graph", "file")
+	f.Add("..", "file")
+	f.Add("graph", "../../outside")
+	f.Add("graph/../..", "file")
+	f.Add("/etc", "passwd")
+	f.Add("graph", "")
+
+	f.Fuzz(func(t *testing.T, graphName string, fileName string) {
+		root := t.TempDir()
+		basePath := filepath.Join(root, "files")
+		files := New(basePath)
+
+		err := files.Store(graphName, fileName, bytes.NewBufferString("content"))
+		if err == nil {
+			content, err := files.Content(graphName, fileName)
+			if err != nil || string(content) != "content" {
+				t.Fatalf("Expected stored content, got %s (%v)", content, err)
+			}
+			err = files.Remove(graphName, fileName)
+			if err != nil {
+				t.Fatalf("Expected no error on remove, got %v", err)
+			}
+		}
+
+		// nothing may be created next to the files directory
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, entry := range entries {
+			if entry.Name() != "files" {
+				t.Fatalf("Created %s outside of the base path for graph %q and file %q", entry.Name(), graphName, fileName)
+			}
+		}
+	})
+}
+
+func TestRenameInvalidGraph(t *testing.T) {
+	basePath := t.TempDir()
+	files := New(basePath)
+	// older versions stored graphs with any directory name
+	legacyPath := filepath.Join(basePath, "Personal (old)")
+	_ = os.MkdirAll(legacyPath, os.ModePerm)
+	_ = os.WriteFile(filepath.Join(legacyPath, "blob"), []byte("content"), 0644)
+
+	graphs, err := files.Graphs()
+	if err != nil || len(graphs) != 1 || graphs[0] != "Personal (old)" {
+		t.Fatalf("Expected the legacy graph, got %v, %v", graphs, err)
+	}
+	blobs, err := files.Blobs("Personal (old)")
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("Expected the blob of the legacy graph, got %v, %v", blobs, err)
+	}
+
+	err = files.RenameGraph("Personal (old)", "Personal (new)")
+	if !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("Expected ErrInvalidName for the new name, got %v", err)
+	}
+	err = files.RenameGraph("../outside", "Personal")
+	if !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("Expected ErrInvalidName for a path, got %v", err)
+	}
+
+	err = files.RenameGraph("Personal (old)", "Personal")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, err := files.Content("Personal", "blob")
+	if err != nil || string(content) != "content" {
+		t.Fatalf("Expected the blob to be moved, got %q, %v", content, err)
+	}
+}
@@ -0,0 +1,65 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidName = errors.New("invalid name")
+
+const maxNameLength = 128
+
+// ValidateName checks that the name of a graph or a stored file is a single
+// path segment: letters, digits, spaces, "-", "_" and ".", not starting with a "."
+// and not ending with a space or ".".
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidName)
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidName, maxNameLength)
+	}
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return fmt.Errorf("%w: %q must not start with a dot or end with a dot or space", ErrInvalidName, name)
+	}
+
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" -_.", r) {
+			continue
+		}
+		return fmt.Errorf("%w: %q contains the invalid character %q", ErrInvalidName, name, r)
+	}
+
+	return nil
+}
+
+func confine(basePath string, elems ...string) (string, error) {
+	for _, elem := range elems {
+		err := ValidateName(elem)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	p := filepath.Join(append([]string{basePath}, elems...)...)
+	rel, err := filepath.Rel(basePath, p)
+	if err != nil {
+		return "", err
+	}
+	if rel != filepath.Join(elems...) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is outside of %s", ErrInvalidName, p, basePath)
+	}
+
+	return p, nil
+}
+
+// confineExisting only requires a single path segment, older versions accepted names, that ValidateName rejects.
+func confineExisting(basePath string, graphName string) (string, error) {
+	if graphName == "" || graphName == "." || graphName == ".." || strings.ContainsAny(graphName, `/\`) {
+		return "", fmt.Errorf("%w: %q is not a single path segment", ErrInvalidName, graphName)
+	}
+	return filepath.Join(basePath, graphName), nil
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"io"
 	"log/slog"
 	"os"
 )
@@ -8,7 +9,12 @@ import (
 var logger *slog.Logger
 
 func New(level slog.Level) *slog.Logger {
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	return NewWithWriter(os.Stdout, level)
+}
+
+// NewWithWriter writes the log messages to w, e.g. to keep the output of commands parsable
+func NewWithWriter(w io.Writer, level slog.Level) *slog.Logger {
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{
 		Level: level,
 	})
 	logger = slog.New(handler)
@@ -29,6 +35,10 @@ func Info(msg string, args ...any) {
 	logger.Info(msg, args...)
 }
 
+func Warn(msg string, args ...any) {
+	logger.Warn(msg, args...)
+}
+
 func With(args []any) *slog.Logger {
 	return logger.With(args...)
 }
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Device is a client of a graph. Clients identify themselves by the X-Device-Id and X-Device-Name headers.
+type Device struct {
+	GraphName string `gorm:"primaryKey" json:"graph_name"`
+	Id        string `gorm:"primaryKey" json:"id"`
+	Name      string `json:"name"`
+	// Revision, that the device acknowledged after applying all changes up to it
+	Revision int64 `json:"revision"`
+	// Acknowledged is the time of the latest acknowledgement, the last successful sync of the device
+	Acknowledged time.Time `json:"acknowledged"`
+	LastSeen     time.Time `json:"last_seen"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"net/url"
+	"strings"
+)
+
+const legacySeparator = "___"
+
+// LegacyFileId converts a file id of older clients to the current encoding,
+// where every path segment is escaped and joined by "/".
+// Encrypted ids are hex encoded and therefore not changed.
+func LegacyFileId(fileId string) string {
+	parts := strings.Split(fileId, legacySeparator)
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
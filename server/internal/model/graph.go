@@ -0,0 +1,110 @@
+package model
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+// Graph is a graph, that was created explicitly or by the first upload with graphs.autocreate enabled.
+// The changes and files of the graph reference it by its name.
+type Graph struct {
+	Id    string `gorm:"primaryKey" json:"id"`
+	Name  string `gorm:"uniqueIndex" json:"name"`
+	Owner string `json:"owner"`
+	// Encrypted graphs only store content encrypted by the clients
+	Encrypted bool `json:"encrypted"`
+	// KeyCheck lets clients check, that they use the same key as the other clients of the graph
+	KeyCheck string `json:"key_check,omitempty"`
+	// KeyParams are the salt and the parameters, that the clients derive the key of the graph from the passphrase with.
+	// Graphs encrypted by older clients have none.
+	KeyParams string `json:"key_params,omitempty"`
+	// KeyEpoch counts the rotations of the key. Clients send the epoch of their key with every change.
+	KeyEpoch int64 `json:"key_epoch"`
+	// Archived graphs can be read, but not changed
+	Archived bool `json:"archived"`
+	// Revision of the latest change of the graph
+	Revision int64 `json:"revision"`
+	// CompactedRevision is the latest deletion, that was removed by a compaction.
+	// Clients, that synced before it or before CompactedAt, have to resync.
+	CompactedRevision int64     `json:"compacted_revision"`
+	CompactedAt       time.Time `json:"compacted_at"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// reservedGraphNames are the first path segments of other endpoints
+var reservedGraphNames = []string{"graphs", "transactions"}
+
+func IsReservedGraphName(name string) bool {
+	return slices.Contains(reservedGraphNames, name)
+}
+
+// NextRevision increments the revision of the graph and returns it.
+// It has to be called in the transaction, that stores the change.
+func NextRevision(tx *gorm.DB, graphName string) (int64, error) {
+	result := tx.Model(&Graph{}).
+		Where("name = ?", graphName).
+		Update("revision", gorm.Expr("revision + 1"))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, fmt.Errorf("graph %s is not registered", graphName)
+	}
+
+	var graph Graph
+	err := tx.Select("revision").Where("name = ?", graphName).First(&graph).Error
+	return graph.Revision, err
+}
+
+// AssignRevisions numbers the changes of the graph in the order of their timestamps
+// and updates the revisions of the graph and its mappings
+func AssignRevisions(tx *gorm.DB, graphName string) error {
+	var changes []ChangeLogEntry
+	err := tx.Where("graph_name = ?", graphName).Order("timestamp asc").Find(&changes).Error
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]ChangeLogEntry)
+	for i, change := range changes {
+		change.Revision = int64(i + 1)
+		err = tx.Model(&ChangeLogEntry{}).
+			Where("graph_name = ? AND file_id = ? AND timestamp = ?", graphName, change.FileId, change.Timestamp).
+			Update("revision", change.Revision).Error
+		if err != nil {
+			return err
+		}
+		latest[change.FileId] = change
+	}
+
+	err = tx.Model(&Graph{}).Where("name = ?", graphName).Update("revision", len(changes)).Error
+	if err != nil {
+		return err
+	}
+
+	var mappings []FileMapping
+	err = tx.Where("graph_name = ?", graphName).Find(&mappings).Error
+	if err != nil {
+		return err
+	}
+	for _, mapping := range mappings {
+		change, ok := latest[mapping.FileId]
+		if !ok {
+			continue
+		}
+		err = tx.Model(&FileMapping{}).
+			Where("graph_name = ? AND file_id = ?", graphName, mapping.FileId).
+			Updates(map[string]any{
+				"revision": change.Revision,
+				"kind":     change.Kind,
+				"mode":     change.Mode,
+				"mod_time": change.ModTime,
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// GraphMember can decrypt a shared graph. The random data key of the graph is wrapped for the public key
+// of the member, the server never sees the key itself.
+type GraphMember struct {
+	GraphName string `gorm:"primaryKey" json:"graph_name"`
+	PublicKey string `gorm:"primaryKey" json:"public_key"`
+	Name      string `json:"name"`
+	// WrappedKey is the data key of the graph, members invited during a key rotation have none until it is committed
+	WrappedKey string `json:"wrapped_key"`
+	// PendingKey is the new data key wrapped for the member during a key rotation
+	PendingKey string `json:"pending_key,omitempty"`
+	// Accepted the member unwrapped the key and syncs the graph
+	Accepted  bool      `json:"accepted"`
+	InvitedAt time.Time `json:"invited_at"`
+}
@@ -0,0 +1,241 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"github.com/soerenchrist/logsync/server/internal/log"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// SchemaVersion stores the data migrations, that were already applied
+type SchemaVersion struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations are applied in order after the schema was migrated.
+// Never change or remove a migration, that was already released.
+var migrations = []migration{
+	{version: 1, name: "escaped path file ids", up: migrateLegacyFileIds},
+	{version: 2, name: "file mappings per graph", up: migrateMappingsPerGraph},
+	{version: 3, name: "register existing graphs", up: registerExistingGraphs},
+	{version: 4, name: "change revisions", up: assignExistingRevisions},
+	{version: 5, name: "change receive times", up: assignReceiveTimes},
+}
+
+func runMigrations(db *gorm.DB) error {
+	var applied []SchemaVersion
+	tx := db.Find(&applied)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, m := range migrations {
+		if isApplied(applied, m.version) {
+			continue
+		}
+
+		log.Info("Applying migration", "version", m.version, "name", m.name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := m.up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{
+				Version:   m.version,
+				Name:      m.name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isApplied(applied []SchemaVersion, version int) bool {
+	for _, a := range applied {
+		if a.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+func migrateLegacyFileIds(tx *gorm.DB) error {
+	var mappings []FileMapping
+	err := tx.Find(&mappings).Error
+	if err != nil {
+		return err
+	}
+	for _, mapping := range mappings {
+		fileId := LegacyFileId(mapping.FileId)
+		if fileId == mapping.FileId {
+			continue
+		}
+		err = tx.Model(&FileMapping{}).
+			Where("file_id = ?", mapping.FileId).
+			Update("file_id", fileId).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var fileIds []string
+	err = tx.Model(&ChangeLogEntry{}).Distinct().Pluck("file_id", &fileIds).Error
+	if err != nil {
+		return err
+	}
+	for _, oldId := range fileIds {
+		fileId := LegacyFileId(oldId)
+		if fileId == oldId {
+			continue
+		}
+		err = tx.Model(&ChangeLogEntry{}).
+			Where("file_id = ?", oldId).
+			Update("file_id", fileId).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateMappingsPerGraph copies the mappings, that older versions shared between all graphs, to every graph with changes of the file.
+func migrateMappingsPerGraph(tx *gorm.DB) error {
+	var mappings []FileMapping
+	err := tx.Find(&mappings).Error
+	if err != nil {
+		return err
+	}
+
+	migrated := make([]FileMapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		if mapping.GraphName != "" {
+			migrated = append(migrated, mapping)
+			continue
+		}
+
+		var graphNames []string
+		err = tx.Model(&ChangeLogEntry{}).
+			Where("file_id = ?", mapping.FileId).
+			Distinct().
+			Pluck("graph_name", &graphNames).Error
+		if err != nil {
+			return err
+		}
+		for _, graphName := range graphNames {
+			migrated = append(migrated, FileMapping{
+				GraphName: graphName,
+				FileId:    mapping.FileId,
+				FileName:  mapping.FileName,
+			})
+		}
+	}
+
+	err = tx.Migrator().DropTable(&FileMapping{})
+	if err != nil {
+		return err
+	}
+	err = tx.Migrator().CreateTable(&FileMapping{})
+	if err != nil {
+		return err
+	}
+	if len(migrated) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(migrated, 100).Error
+}
+
+func registerExistingGraphs(tx *gorm.DB) error {
+	var names []string
+	err := tx.Model(&ChangeLogEntry{}).Distinct().Pluck("graph_name", &names).Error
+	if err != nil {
+		return err
+	}
+
+	var mappingNames []string
+	err = tx.Model(&FileMapping{}).Distinct().Pluck("graph_name", &mappingNames).Error
+	if err != nil {
+		return err
+	}
+
+	for _, name := range append(names, mappingNames...) {
+		var count int64
+		err = tx.Model(&Graph{}).Where("name = ?", name).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		var fileIds []string
+		err = tx.Model(&ChangeLogEntry{}).Where("graph_name = ?", name).Distinct().Pluck("file_id", &fileIds).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&Graph{
+			Id:        uuid.New().String(),
+			Name:      name,
+			Encrypted: len(fileIds) > 0 && allEncrypted(fileIds),
+			CreatedAt: time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func assignExistingRevisions(tx *gorm.DB) error {
+	var names []string
+	err := tx.Model(&Graph{}).Pluck("name", &names).Error
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = AssignRevisions(tx, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func assignReceiveTimes(tx *gorm.DB) error {
+	return tx.Model(&ChangeLogEntry{}).
+		Where("created_at IS NULL").
+		Update("created_at", gorm.Expr("timestamp")).Error
+}
+
+// minEncryptedIdLength is the hex encoded length of the nonce, the tag and at least one byte of an encrypted id
+const minEncryptedIdLength = 2 * (12 + 16 + 1)
+
+// allEncrypted guesses the encryption of older graphs, encrypted ids are hex strings and plain ids are paths.
+func allEncrypted(fileIds []string) bool {
+	for _, fileId := range fileIds {
+		if len(fileId) < minEncryptedIdLength {
+			return false
+		}
+		for _, r := range fileId {
+			if !strings.ContainsRune("0123456789abcdef", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
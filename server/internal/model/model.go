@@ -15,19 +15,48 @@ const (
 	Modified OperationType = "M"
 )
 
+type FileKind string
+
+const (
+	File      FileKind = "file"
+	Directory FileKind = "dir"
+	// Symlink the content of a symlink is its target
+	Symlink FileKind = "symlink"
+)
+
 type ChangeLogEntry struct {
 	GraphName     string        `gorm:"primaryKey" json:"graph_name"`
 	FileId        string        `gorm:"primaryKey" json:"file_id"`
 	Timestamp     time.Time     `gorm:"primaryKey" json:"timestamp"`
 	TransactionId string        `json:"transaction_id"`
 	Operation     OperationType `json:"operation"`
+	Kind          FileKind      `gorm:"default:file" json:"kind"`
+	Mode          uint32        `json:"mode"`
+	ModTime       time.Time     `json:"mod_time"`
+	// Revision orders the changes of a graph, every change gets the next revision of its graph
+	Revision int64 `gorm:"index" json:"revision"`
+	// DeviceId of the client, that made the change. Changes of older clients have none.
+	DeviceId string `json:"device_id"`
+	// CreatedAt is the time the server received the change. Timestamp is the modification time sent by the
+	// client, a deletion carries the modification time of the deleted file.
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
 }
 
 // FileMapping encrypted filename may be longer than 255 chars
-// therefore we need a mapping from id to a generated filename
+// therefore we need a mapping from id to a generated filename.
+// Deleted files have no mapping, the mappings of a graph are its manifest.
 type FileMapping struct {
-	FileId   string `gorm:"primaryKey"`
-	FileName string
+	GraphName string `gorm:"primaryKey"`
+	FileId    string `gorm:"primaryKey"`
+	FileName  string
+	// Revision of the latest change of the file
+	Revision int64
+	Size     int64
+	// Hash is the hex encoded sha256 of the stored content, directories have none
+	Hash    string
+	Kind    FileKind `gorm:"default:file"`
+	Mode    uint32
+	ModTime time.Time
 }
 
 func CreateDb(path string) (*gorm.DB, error) {
@@ -39,11 +68,17 @@ func CreateDb(path string) (*gorm.DB, error) {
 	}
 
 	log.Debug("Migrating database")
-	err = db.AutoMigrate(&ChangeLogEntry{}, &FileMapping{})
+	err = db.AutoMigrate(&ChangeLogEntry{}, &FileMapping{}, &Graph{}, &Device{}, &KeyRotation{}, &RotatedFile{}, &GraphMember{}, &SchemaVersion{})
 	if err != nil {
 		log.Error("Could migrate database", "error", err)
 		return nil, err
 	}
 
+	err = runMigrations(db)
+	if err != nil {
+		log.Error("Could not migrate data", "error", err)
+		return nil, err
+	}
+
 	return db, nil
 }
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// KeyRotation re-encrypts an encrypted graph with a new key. The client rotating the key uploads every file
+// encrypted with the new key, the files are staged until the rotation is committed.
+// Changes of the graph are rejected while the rotation is running.
+type KeyRotation struct {
+	GraphName string `gorm:"primaryKey" json:"graph_name"`
+	// KeyParams and KeyCheck of the new key
+	KeyParams string    `json:"key_params"`
+	KeyCheck  string    `json:"key_check"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// RotatedFile is a file of a running rotation, encrypted with the new key
+type RotatedFile struct {
+	GraphName string `gorm:"primaryKey"`
+	// FileId is the id encrypted with the current key
+	FileId    string `gorm:"primaryKey"`
+	NewFileId string
+	// FileName of the staged content, files without content have none
+	FileName string
+	Size     int64
+	Hash     string
+}
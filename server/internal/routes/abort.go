@@ -1,34 +1,69 @@
 package routes
 
 import (
+	"errors"
+	"fmt"
 	"github.com/go-chi/render"
 	"log/slog"
 	"net/http"
 )
 
+// Error codes of the api. Clients react on the code, the message is meant for humans and may change.
+const (
+	codeBadRequest       = "bad-request"
+	codeUnauthorized     = "unauthorized"
+	codeNotFound         = "not-found"
+	codeMethodNotAllowed = "method-not-allowed"
+	codeGraphNotFound    = "graph-not-found"
+	codeGraphArchived    = "graph-archived"
+	codeConflict         = "conflict"
+	codeTooLarge         = "too-large"
+	codeResyncRequired   = "resync-required"
+	codeKeyRotation      = "key-rotation"
+	codeKeyRotated       = "key-rotated"
+	codeTooManyDeletes   = "too-many-deletes"
+	codeInternal         = "internal"
+)
+
 type apiError struct {
-	Code  int    `json:"code"`
-	Error string `json:"error"`
+	// Code is the http status code
+	Code      int    `json:"code"`
+	ErrorCode string `json:"error_code"`
+	Error     string `json:"error"`
 }
 
 func abort500(w http.ResponseWriter, r *http.Request, err error) {
 	logger := r.Context().Value("logger").(*slog.Logger)
 	logger.Error("An error occurred", "error", err)
-	abort(w, r, 500, "An error occurred")
+	abort(w, r, 500, codeInternal, "An error occurred")
 }
 
 func abort400(w http.ResponseWriter, r *http.Request, message string) {
-	abort(w, r, 400, message)
+	abort(w, r, 400, codeBadRequest, message)
 }
 
 func abort404(w http.ResponseWriter, r *http.Request) {
-	abort(w, r, 404, "Not found")
+	abort(w, r, 404, codeNotFound, "Not found")
+}
+
+func abort409(w http.ResponseWriter, r *http.Request, message string) {
+	abort(w, r, 409, codeConflict, message)
+}
+
+func abortTooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	abort(w, r, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("The body is limited to %d bytes", maxBytesErr.Limit))
+	return true
 }
 
-func abort(w http.ResponseWriter, r *http.Request, status int, error string) {
+func abort(w http.ResponseWriter, r *http.Request, status int, code string, error string) {
 	render.Status(r, status)
 	render.JSON(w, r, apiError{
-		Code:  status,
-		Error: error,
+		Code:      status,
+		ErrorCode: code,
+		Error:     error,
 	})
 }
@@ -1,37 +1,94 @@
 package routes
 
 import (
-	"github.com/go-chi/chi/v5"
+	"errors"
+	"fmt"
 	"github.com/go-chi/render"
 	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 )
 
+// getChanges answers clients behind the compaction horizon with 410, they missed deletions and have to resync.
 func (c *Controller) getChanges(w http.ResponseWriter, r *http.Request) {
 	logger := r.Context().Value("logger").(*slog.Logger)
-	graphId := chi.URLParam(r, "graphID")
+	graphId := readGraphName(r)
 
-	since := r.URL.Query().Get("since")
-	sinceTime, err := parseTime(since)
-	if err != nil {
-		abort400(w, r, "Could not parse time")
+	var graph model.Graph
+	var changes []model.ChangeLogEntry
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		graph, err = findGraph(tx, graphId)
+		if err != nil {
+			return err
+		}
+
+		query := tx.Where("graph_name = ? AND revision <= ?", graphId, graph.Revision)
+		afterRevision := r.URL.Query().Get("after_revision")
+		if afterRevision != "" {
+			after, err := strconv.ParseInt(afterRevision, 10, 64)
+			if err != nil {
+				return errInvalidQuery
+			}
+			if after > 0 && after < graph.CompactedRevision {
+				return errResyncRequired
+			}
+			logger.Debug("Getting changes for graph", "graph", graphId, "after_revision", after)
+			query = query.Where("revision > ?", after).Order("revision asc")
+		} else {
+			sinceTime, err := parseTime(r.URL.Query().Get("since"))
+			if err != nil {
+				return errInvalidQuery
+			}
+			if sinceTime.UnixMilli() > 0 && sinceTime.Before(graph.CompactedAt) {
+				return errResyncRequired
+			}
+			logger.Debug("Getting changes for graph", "graph", graphId, "since", sinceTime)
+			query = query.Where("timestamp > ?", sinceTime)
+		}
+		// the device already has its own changes, it doesn't have to download them again
+		deviceId := r.Header.Get(deviceIdHeader)
+		if deviceId != "" {
+			query = query.Where("device_id IS NULL OR device_id <> ?", deviceId)
+		}
+		return query.Find(&changes).Error
+	})
+	if errors.Is(err, errInvalidQuery) {
+		abort400(w, r, "Could not parse since or after_revision")
 		return
 	}
-	logger.Debug("Getting changes for graph", "graph", graphId, "since", sinceTime)
-
-	var changes []model.ChangeLogEntry
-	tx := c.db.Where("graph_name = ? AND timestamp > ?", graphId, sinceTime).Find(&changes)
-	if tx.Error != nil {
+	if errors.Is(err, errResyncRequired) {
+		abort(w, r, http.StatusGone, codeResyncRequired, fmt.Sprintf("Resync required, deletions up to revision %d were compacted", graph.CompactedRevision))
+		return
+	}
+	if err != nil {
 		abort500(w, r, err)
 		return
 	}
 
+	w.Header().Set(graphRevisionHeader, strconv.FormatInt(graph.Revision, 10))
 	render.JSON(w, r, changes)
 }
 
+const graphRevisionHeader = "X-Graph-Revision"
+
+var (
+	errInvalidQuery   = errors.New("invalid query")
+	errResyncRequired = errors.New("resync required")
+)
+
+func findGraph(tx *gorm.DB, graphName string) (model.Graph, error) {
+	var graph model.Graph
+	err := tx.Where("name = ?", graphName).First(&graph).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Graph{Name: graphName}, nil
+	}
+	return graph, err
+}
+
 func parseTime(since string) (time.Time, error) {
 	if since == "" {
 		return time.UnixMilli(0), nil
@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm/clause"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type acknowledgeRequest struct {
+	Revision int64 `json:"revision"`
+}
+
+func (c *Controller) trackDevice(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceId := r.Header.Get(deviceIdHeader)
+		if deviceId == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		device := model.Device{
+			GraphName: readGraphName(r),
+			Id:        deviceId,
+			Name:      r.Header.Get(deviceNameHeader),
+			LastSeen:  now,
+			CreatedAt: now,
+		}
+		err := c.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "graph_name"}, {Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"name", "last_seen"}),
+		}).Create(&device).Error
+		if err != nil {
+			abort500(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *Controller) acknowledge(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	deviceId := r.Header.Get(deviceIdHeader)
+	if deviceId == "" {
+		abort400(w, r, fmt.Sprintf("Expected %s header", deviceIdHeader))
+		return
+	}
+
+	var request acknowledgeRequest
+	err := render.DecodeJSON(r.Body, &request)
+	if err != nil {
+		abort400(w, r, "Could not parse body")
+		return
+	}
+
+	graph, err := findGraph(c.db, graphName)
+	if err != nil {
+		abort500(w, r, err)
+		return
+	}
+	if request.Revision < 0 || request.Revision > graph.Revision {
+		abort400(w, r, fmt.Sprintf("Revision must be between 0 and %d", graph.Revision))
+		return
+	}
+
+	err = c.db.Model(&model.Device{}).
+		Where("graph_name = ? AND id = ?", graphName, deviceId).
+		Updates(map[string]any{"revision": request.Revision, "acknowledged": time.Now()}).Error
+	if err != nil {
+		abort500(w, r, err)
+		return
+	}
+	logger.Debug("Acknowledged revision", "graph", graphName, "revision", request.Revision)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) listDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := c.admin.Devices(readGraphName(r), c.config.Devices.StaleBefore())
+	if err != nil {
+		abort500(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, devices)
+}
+
+func (c *Controller) removeDevice(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	deviceId, err := url.PathUnescape(chi.URLParam(r, "deviceID"))
+	if err != nil {
+		abort400(w, r, "Could not parse device id")
+		return
+	}
+
+	err = c.admin.RemoveDevice(graphName, deviceId)
+	if errors.Is(err, admin.ErrDeviceNotFound) {
+		abort404(w, r)
+		return
+	}
+	if err != nil {
+		abort500(w, r, err)
+		return
+	}
+	logger.Info("Removed device", "graph", graphName, "removed_device", deviceId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,199 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/render"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"log/slog"
+	"net/http"
+)
+
+type createGraphRequest struct {
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`
+	Encrypted bool   `json:"encrypted"`
+	KeyCheck  string `json:"key_check"`
+	KeyParams string `json:"key_params"`
+}
+
+type updateGraphRequest struct {
+	Name      *string `json:"name"`
+	Owner     *string `json:"owner"`
+	KeyCheck  *string `json:"key_check"`
+	KeyParams *string `json:"key_params"`
+	Archived  *bool   `json:"archived"`
+}
+
+func (c *Controller) listGraphs(w http.ResponseWriter, r *http.Request) {
+	graphs, err := c.admin.Graphs()
+	if err != nil {
+		abort500(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, graphs)
+}
+
+func (c *Controller) createGraph(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	var request createGraphRequest
+	err := render.DecodeJSON(r.Body, &request)
+	if err != nil {
+		abort400(w, r, "Could not parse body")
+		return
+	}
+
+	graph, err := c.admin.CreateGraph(model.Graph{
+		Name:      request.Name,
+		Owner:     request.Owner,
+		Encrypted: request.Encrypted,
+		KeyCheck:  request.KeyCheck,
+		KeyParams: request.KeyParams,
+	})
+	if err != nil {
+		abortGraphError(w, r, err)
+		return
+	}
+	logger.Info("Created graph", "graph", graph.Name, "id", graph.Id)
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, graph)
+}
+
+type graphResponse struct {
+	admin.GraphInfo
+	UploadEncodings []string `json:"upload_encodings"`
+}
+
+func (c *Controller) getGraph(w http.ResponseWriter, r *http.Request) {
+	info, err := c.admin.Graph(readGraphName(r))
+	if err != nil {
+		abortGraphError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, graphResponse{GraphInfo: info, UploadEncodings: []string{"gzip"}})
+}
+
+func (c *Controller) updateGraph(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	var request updateGraphRequest
+	err := render.DecodeJSON(r.Body, &request)
+	if err != nil {
+		abort400(w, r, "Could not parse body")
+		return
+	}
+
+	info, err := c.admin.Graph(graphName)
+	if err != nil {
+		abortGraphError(w, r, err)
+		return
+	}
+	if info.Id == "" {
+		abort404(w, r)
+		return
+	}
+
+	graph := info.Graph
+	if request.Owner != nil {
+		graph.Owner = *request.Owner
+	}
+	if request.KeyCheck != nil {
+		graph.KeyCheck = *request.KeyCheck
+	}
+	if request.KeyParams != nil {
+		graph.KeyParams = *request.KeyParams
+	}
+	if request.Archived != nil {
+		graph.Archived = *request.Archived
+	}
+	if request.Name != nil {
+		graph.Name = *request.Name
+	}
+	err = c.admin.UpdateGraph(graphName, graph)
+	if err != nil {
+		abortGraphError(w, r, err)
+		return
+	}
+	if graph.Name != graphName {
+		logger.Info("Renamed graph", "graph", graphName, "name", graph.Name)
+	}
+
+	info, err = c.admin.Graph(graph.Name)
+	if err != nil {
+		abort500(w, r, err)
+		return
+	}
+	render.JSON(w, r, info)
+}
+
+func (c *Controller) deleteGraph(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	if r.URL.Query().Get("confirm") != graphName {
+		abort400(w, r, "Confirm the deletion with the graph name in the confirm query param")
+		return
+	}
+
+	err := c.admin.DeleteGraph(graphName)
+	if err != nil {
+		abortGraphError(w, r, err)
+		return
+	}
+	logger.Info("Deleted graph", "graph", graphName)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func abortGraphError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, admin.ErrGraphNotFound):
+		abort(w, r, http.StatusNotFound, codeGraphNotFound, "Graph not found")
+	case errors.Is(err, admin.ErrGraphExists):
+		abort409(w, r, err.Error())
+	case errors.Is(err, files.ErrInvalidName):
+		abort400(w, r, err.Error())
+	default:
+		abort500(w, r, err)
+	}
+}
+
+func (c *Controller) requireGraph(write bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			graphName := readGraphName(r)
+			var graph model.Graph
+			err := c.db.Where("name = ?", graphName).First(&graph).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				if !c.config.Graphs.AutoCreate {
+					abort(w, r, http.StatusNotFound, codeGraphNotFound, fmt.Sprintf("Graph %s does not exist", graphName))
+					return
+				}
+				if write {
+					_, err = c.admin.CreateGraph(model.Graph{Name: graphName})
+					if err != nil && !errors.Is(err, admin.ErrGraphExists) {
+						abortGraphError(w, r, err)
+						return
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				abort500(w, r, err)
+				return
+			}
+
+			if write && graph.Archived {
+				abort(w, r, http.StatusForbidden, codeGraphArchived, fmt.Sprintf("Graph %s is archived", graphName))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
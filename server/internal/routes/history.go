@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"github.com/go-chi/render"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"log/slog"
+	"net/http"
+)
+
+func (c *Controller) getHistory(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	fileId, err := readFileId(r)
+	if err != nil {
+		abort400(w, r, "Could not parse file id")
+		return
+	}
+	page := readPageOptions(r)
+	logger.Debug("Getting history of file", "graph", graphName, "file", fileId)
+
+	var changes []model.ChangeLogEntry
+	tx := c.db.Where("graph_name = ? AND file_id = ?", graphName, fileId).
+		Order("revision desc").
+		Limit(page.size).
+		Offset(page.skip()).
+		Find(&changes)
+	if tx.Error != nil {
+		abort500(w, r, tx.Error)
+		return
+	}
+
+	render.JSON(w, r, changes)
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+type manifest struct {
+	Graph    string          `json:"graph"`
+	Revision int64           `json:"revision"`
+	Files    []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	FileId   string         `json:"file_id"`
+	Revision int64          `json:"revision"`
+	Size     int64          `json:"size"`
+	Hash     string         `json:"hash,omitempty"`
+	Kind     model.FileKind `json:"kind"`
+	Mode     uint32         `json:"mode"`
+	ModTime  time.Time      `json:"mod_time"`
+}
+
+func (c *Controller) getManifest(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+
+	result := manifest{
+		Graph: graphName,
+		Files: make([]manifestEntry, 0),
+	}
+	var mappings []model.FileMapping
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		graph, err := findGraph(tx, graphName)
+		if err != nil {
+			return err
+		}
+		result.Revision = graph.Revision
+		return tx.Where("graph_name = ?", graphName).Order("file_id asc").Find(&mappings).Error
+	})
+	if err != nil {
+		abort500(w, r, err)
+		return
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Hash == "" && mapping.Kind != model.Directory {
+			mapping, err = c.hashMapping(mapping)
+			if err != nil {
+				// the manifest is still useful without the hash, verify reports the missing content
+				logger.Warn("Could not hash stored file", "graph", graphName, "file_id", mapping.FileId, "error", err)
+			}
+		}
+		result.Files = append(result.Files, manifestEntry{
+			FileId:   mapping.FileId,
+			Revision: mapping.Revision,
+			Size:     mapping.Size,
+			Hash:     mapping.Hash,
+			Kind:     mapping.Kind,
+			Mode:     mapping.Mode,
+			ModTime:  mapping.ModTime,
+		})
+	}
+
+	render.JSON(w, r, result)
+}
+
+func (c *Controller) hashMapping(mapping model.FileMapping) (model.FileMapping, error) {
+	content, err := c.files.Content(mapping.GraphName, mapping.FileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return mapping, errors.New("stored file is missing")
+		}
+		return mapping, err
+	}
+
+	mapping.Size = int64(len(content))
+	mapping.Hash = files.Hash(content)
+	err = c.db.Model(&model.FileMapping{}).
+		Where("graph_name = ? AND file_id = ?", mapping.GraphName, mapping.FileId).
+		Updates(map[string]any{"size": mapping.Size, "hash": mapping.Hash}).Error
+	return mapping, err
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"log/slog"
+	"net/http"
+)
+
+type saveMemberRequest struct {
+	Name       string `json:"name"`
+	WrappedKey string `json:"wrapped_key"`
+	PendingKey string `json:"pending_key"`
+}
+
+func (c *Controller) listMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := c.admin.Members(readGraphName(r))
+	if err != nil {
+		abortMemberError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, members)
+}
+
+func (c *Controller) getMember(w http.ResponseWriter, r *http.Request) {
+	member, err := c.admin.Member(readGraphName(r), chi.URLParam(r, "publicKey"))
+	if err != nil {
+		abortMemberError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, member)
+}
+
+func (c *Controller) saveMember(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	var request saveMemberRequest
+	err := render.DecodeJSON(r.Body, &request)
+	if err != nil {
+		abort400(w, r, "Could not parse body")
+		return
+	}
+
+	graphName := readGraphName(r)
+	member, err := c.admin.SaveMember(model.GraphMember{
+		GraphName:  graphName,
+		PublicKey:  chi.URLParam(r, "publicKey"),
+		Name:       request.Name,
+		WrappedKey: request.WrappedKey,
+		PendingKey: request.PendingKey,
+	})
+	if err != nil {
+		abortMemberError(w, r, err)
+		return
+	}
+	logger.Info("Saved member", "graph", graphName, "member", member.Name, "pending", member.PendingKey != "")
+
+	render.JSON(w, r, member)
+}
+
+func (c *Controller) acceptMember(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	publicKey := chi.URLParam(r, "publicKey")
+	err := c.admin.AcceptMember(graphName, publicKey)
+	if err != nil {
+		abortMemberError(w, r, err)
+		return
+	}
+	logger.Info("Member accepted the invitation", "graph", graphName, "public_key", publicKey)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) removeMember(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	publicKey := chi.URLParam(r, "publicKey")
+	err := c.admin.RemoveMember(graphName, publicKey)
+	if err != nil {
+		abortMemberError(w, r, err)
+		return
+	}
+	logger.Info("Removed member", "graph", graphName, "public_key", publicKey)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func abortMemberError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, admin.ErrMemberNotFound):
+		abort404(w, r)
+	case errors.Is(err, admin.ErrInvalidPublicKey), errors.Is(err, admin.ErrWrappedKeyRequired):
+		abort400(w, r, err.Error())
+	default:
+		abortRotationError(w, r, err)
+	}
+}
@@ -1,13 +1,19 @@
 package routes
 
 import (
+	"compress/gzip"
 	"context"
+	"github.com/go-chi/chi/v5"
 	"github.com/soerenchrist/logsync/server/internal/config"
+	"github.com/soerenchrist/logsync/server/internal/files"
 	"github.com/soerenchrist/logsync/server/internal/log"
 	"net/http"
+	"net/url"
 )
 
 const transactionHeader = "X-Transaction-Id"
+const deviceIdHeader = "X-Device-Id"
+const deviceNameHeader = "X-Device-Name"
 const requestIdHeader = "X-Request-Id"
 const apiTokenHeader = "X-Api-Token"
 
@@ -21,6 +27,11 @@ func Scope(next http.Handler) http.Handler {
 			ctx = context.WithValue(ctx, "transaction", transaction)
 		}
 
+		deviceId := r.Header.Get(deviceIdHeader)
+		if deviceId != "" {
+			args = append(args, "device", deviceId)
+		}
+
 		requestId := r.Header.Get(requestIdHeader)
 		if requestId != "" {
 			args = append(args, "request.id", requestId)
@@ -33,6 +44,67 @@ func Scope(next http.Handler) http.Handler {
 	})
 }
 
+// EscapedPath routes by the escaped path, so url params like file ids
+// can contain escaped slashes and are always unescaped exactly once by the handlers
+func EscapedPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		if rctx != nil && rctx.RoutePath == "" {
+			rctx.RoutePath = r.URL.EscapedPath()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ValidateGraphName rejects graph names, that are not valid directory names on the server
+func ValidateGraphName(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		graphName := chi.URLParam(r, "graphID")
+		unescaped, err := url.PathUnescape(graphName)
+		if err != nil {
+			abort400(w, r, "Could not parse graph name")
+			return
+		}
+
+		err = files.ValidateName(unescaped)
+		if err != nil {
+			abort400(w, r, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxDecompressedBody limits decompressed request bodies, also when files.maxsize allows any size
+var maxDecompressedBody int64 = 512 << 20
+
+// DecompressRequest decodes request bodies, that clients compressed with gzip
+func DecompressRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Content-Encoding") {
+		case "", "identity":
+		case "gzip":
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				abort400(w, r, "Could not decompress body")
+				return
+			}
+			defer reader.Close()
+			// limits of the body size apply to the decompressed body
+			r.Body = http.MaxBytesReader(w, reader, maxDecompressedBody)
+			r.ContentLength = -1
+			r.Header.Del("Content-Encoding")
+		default:
+			abort(w, r, http.StatusUnsupportedMediaType, codeBadRequest, "Unsupported content encoding")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func CreateApiTokenMiddleware(conf config.Config) func(handler http.Handler) http.Handler {
 	if conf.Server.ApiToken == "" {
 		return noop
@@ -41,7 +113,7 @@ func CreateApiTokenMiddleware(conf config.Config) func(handler http.Handler) htt
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			token := r.Header.Get(apiTokenHeader)
 			if token != conf.Server.ApiToken {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				abort(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
 				return
 			}
 
@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/render"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const keyEpochHeader = "X-Key-Epoch"
+
+type startRotationRequest struct {
+	KeyParams string `json:"key_params"`
+	KeyCheck  string `json:"key_check"`
+}
+
+func (c *Controller) startRotation(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	var request startRotationRequest
+	err := render.DecodeJSON(r.Body, &request)
+	if err != nil || request.KeyCheck == "" {
+		abort400(w, r, "Expected key_params and key_check in body")
+		return
+	}
+
+	graphName := readGraphName(r)
+	rotation, err := c.admin.StartRotation(graphName, request.KeyParams, request.KeyCheck)
+	if err != nil {
+		abortRotationError(w, r, err)
+		return
+	}
+	logger.Info("Started key rotation", "graph", graphName, "pending", len(rotation.Pending))
+
+	render.JSON(w, r, rotation)
+}
+
+func (c *Controller) getRotation(w http.ResponseWriter, r *http.Request) {
+	rotation, err := c.admin.Rotation(readGraphName(r))
+	if err != nil {
+		abortRotationError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, rotation)
+}
+
+func (c *Controller) rotateFile(w http.ResponseWriter, r *http.Request) {
+	fileId, err := readFileId(r)
+	if err != nil {
+		abort400(w, r, "Could not parse file id")
+		return
+	}
+	newFileId := r.URL.Query().Get("new_file_id")
+	if newFileId == "" {
+		abort400(w, r, "Expected new_file_id query param")
+		return
+	}
+
+	var content []byte
+	if r.ContentLength != 0 {
+		content, err = io.ReadAll(r.Body)
+		if abortTooLarge(w, r, err) {
+			return
+		}
+		if err != nil {
+			abort500(w, r, err)
+			return
+		}
+	}
+
+	err = c.admin.RotateFile(readGraphName(r), fileId, newFileId, content)
+	if err != nil {
+		abortRotationError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) commitRotation(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	graph, err := c.admin.CommitRotation(graphName)
+	if err != nil {
+		abortRotationError(w, r, err)
+		return
+	}
+	logger.Info("Rotated key", "graph", graphName, "epoch", graph.KeyEpoch)
+
+	render.JSON(w, r, graph)
+}
+
+func (c *Controller) cancelRotation(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*slog.Logger)
+	graphName := readGraphName(r)
+	err := c.admin.CancelRotation(graphName)
+	if err != nil {
+		abortRotationError(w, r, err)
+		return
+	}
+	logger.Info("Canceled key rotation", "graph", graphName)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func abortRotationError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, admin.ErrRotationNotFound), errors.Is(err, admin.ErrFileNotFound):
+		abort404(w, r)
+	case errors.Is(err, admin.ErrNotEncrypted), errors.Is(err, admin.ErrContentRequired):
+		abort400(w, r, err.Error())
+	case errors.Is(err, admin.ErrRotationRunning):
+		abort(w, r, http.StatusLocked, codeKeyRotation, err.Error())
+	case errors.Is(err, admin.ErrRotationIncomplete):
+		abort409(w, r, err.Error())
+	default:
+		abortGraphError(w, r, err)
+	}
+}
+
+var errKeyRotation = errors.New("the key of the graph is being rotated")
+
+type keyEpochError struct {
+	current int64
+}
+
+func (e keyEpochError) Error() string {
+	return fmt.Sprintf("the key of the graph was rotated to epoch %d", e.current)
+}
+
+// checkKeyEpoch runs in the transaction of the change, so a rotation can't commit in between.
+// Graphs, that were never rotated, accept changes without epoch.
+func checkKeyEpoch(tx *gorm.DB, r *http.Request, graphName string) error {
+	var rotations int64
+	err := tx.Model(&model.KeyRotation{}).Where("graph_name = ?", graphName).Count(&rotations).Error
+	if err != nil {
+		return err
+	}
+	if rotations > 0 {
+		return errKeyRotation
+	}
+
+	var graph model.Graph
+	err = tx.Select("key_epoch").Where("name = ?", graphName).First(&graph).Error
+	if err != nil {
+		return err
+	}
+	header := r.Header.Get(keyEpochHeader)
+	if header == "" && graph.KeyEpoch == 0 {
+		return nil
+	}
+	epoch, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || epoch != graph.KeyEpoch {
+		return keyEpochError{current: graph.KeyEpoch}
+	}
+	return nil
+}
+
+func abortKeyEpoch(w http.ResponseWriter, r *http.Request, err error) bool {
+	if errors.Is(err, errKeyRotation) {
+		abort(w, r, http.StatusLocked, codeKeyRotation, "The key of the graph is being rotated, try again later")
+		return true
+	}
+	var epochErr keyEpochError
+	if !errors.As(err, &epochErr) {
+		return false
+	}
+	w.Header().Set(keyEpochHeader, strconv.FormatInt(epochErr.current, 10))
+	abort(w, r, http.StatusConflict, codeKeyRotated, fmt.Sprintf("The key of the graph was rotated to epoch %d", epochErr.current))
+	return true
+}
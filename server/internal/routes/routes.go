@@ -2,30 +2,77 @@ package routes
 
 import (
 	"github.com/go-chi/chi/v5"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/config"
 	"github.com/soerenchrist/logsync/server/internal/files"
 	"gorm.io/gorm"
+	"net/http"
 )
 
 type Controller struct {
 	db     *gorm.DB
 	router *chi.Mux
 	files  files.FileStore
+	admin  admin.Admin
+	config config.Config
 }
 
-func NewController(db *gorm.DB, router *chi.Mux, f files.FileStore) *Controller {
+func NewController(db *gorm.DB, router *chi.Mux, f files.FileStore, conf config.Config) *Controller {
 	c := &Controller{
 		db:     db,
 		router: router,
 		files:  f,
+		admin:  admin.New(db, f),
+		config: conf,
 	}
 	return c
 }
 
 func (c *Controller) MapEndpoints() {
-	c.router.Get("/{graphID}/changes", c.getChanges)
-	c.router.Post("/{graphID}/upload", c.uploadFile)
-	c.router.Delete("/{graphID}/delete/{fileID}", c.deleteFile)
-	c.router.Get("/{graphID}/content/{fileID}", c.content)
+	c.router.NotFound(abort404)
+	c.router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		abort(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	})
+
+	c.router.Route("/graphs", func(r chi.Router) {
+		r.Get("/", c.listGraphs)
+		r.Post("/", c.createGraph)
+		r.Route("/{graphID}", func(r chi.Router) {
+			r.Use(ValidateGraphName)
+			r.Get("/", c.getGraph)
+			r.Patch("/", c.updateGraph)
+			r.Delete("/", c.deleteGraph)
+			r.Get("/rotation", c.getRotation)
+			r.Post("/rotation", c.startRotation)
+			r.Delete("/rotation", c.cancelRotation)
+			r.Put("/rotation/files/{fileID}", c.rotateFile)
+			r.Post("/rotation/commit", c.commitRotation)
+			r.Get("/members", c.listMembers)
+			r.Get("/members/{publicKey}", c.getMember)
+			r.Put("/members/{publicKey}", c.saveMember)
+			r.Post("/members/{publicKey}/accept", c.acceptMember)
+			r.Delete("/members/{publicKey}", c.removeMember)
+		})
+	})
+
+	c.router.Route("/{graphID}", func(r chi.Router) {
+		r.Use(ValidateGraphName)
+		r.Group(func(r chi.Router) {
+			r.Use(c.requireGraph(false), c.trackDevice)
+			r.Get("/changes", c.getChanges)
+			r.Get("/manifest", c.getManifest)
+			r.Get("/content/{fileID}", c.content)
+			r.Get("/history/{fileID}", c.getHistory)
+			r.Post("/ack", c.acknowledge)
+			r.Get("/devices", c.listDevices)
+			r.Delete("/devices/{deviceID}", c.removeDevice)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(c.requireGraph(true), c.trackDevice)
+			r.Post("/upload", c.uploadFile)
+			r.Delete("/delete/{fileID}", c.deleteFile)
+		})
+	})
 
 	c.router.Route("/transactions", func(r chi.Router) {
 		r.Get("/", c.getTransactions)
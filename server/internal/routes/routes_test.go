@@ -0,0 +1,395 @@
+package routes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/soerenchrist/logsync/server/internal/admin"
+	"github.com/soerenchrist/logsync/server/internal/config"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/log"
+	"github.com/soerenchrist/logsync/server/internal/model"
+	"gorm.io/gorm"
+)
+
+type testServer struct {
+	t      *testing.T
+	router *chi.Mux
+	db     *gorm.DB
+	files  files.Files
+	// modified is the modification time of the next change, every change gets another one
+	modified time.Time
+}
+
+func setup(t *testing.T, conf config.Config) *testServer {
+	log.NewWithWriter(io.Discard, slog.LevelError)
+	dir := t.TempDir()
+	db, err := model.CreateDb(filepath.Join(dir, "logsync.db"))
+	if err != nil {
+		t.Fatalf("Could not create db: %v", err)
+	}
+	f := files.New(filepath.Join(dir, "files"))
+
+	router := chi.NewRouter()
+	router.Use(Scope, EscapedPath, DecompressRequest)
+	NewController(db, router, f, conf).MapEndpoints()
+	return &testServer{t: t, router: router, db: db, files: f, modified: time.Now().Add(-time.Hour)}
+}
+
+func (s *testServer) send(req *http.Request, header http.Header) *httptest.ResponseRecorder {
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if req.Header.Get(transactionHeader) == "" {
+		req.Header.Set(transactionHeader, "transaction")
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *testServer) nextModified() time.Time {
+	s.modified = s.modified.Add(time.Second)
+	return s.modified
+}
+
+// upload uploads the content of the file based on the revision, a negative base skips the check
+func (s *testServer) upload(graphName, fileId, content string, base int64, header http.Header) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "file")
+	part.Write([]byte(content))
+	form.WriteField("file_id", fileId)
+	form.WriteField("operation", "M")
+	form.WriteField("modified-date", s.nextModified().Format(time.RFC3339))
+	if base >= 0 {
+		form.WriteField("base_revision", strconv.FormatInt(base, 10))
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/%s/upload", url.PathEscape(graphName)), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return s.send(req, header)
+}
+
+// delete deletes the file, the query contains further parameters
+func (s *testServer) delete(graphName, fileId, query string, header http.Header) *httptest.ResponseRecorder {
+	target := fmt.Sprintf("/%s/delete/%s?modified_date=%d", url.PathEscape(graphName), url.PathEscape(fileId), s.nextModified().UnixMilli())
+	if query != "" {
+		target += "&" + query
+	}
+	return s.send(httptest.NewRequest("DELETE", target, nil), header)
+}
+
+func (s *testServer) get(target string, header http.Header) *httptest.ResponseRecorder {
+	return s.send(httptest.NewRequest("GET", target, nil), header)
+}
+
+func (s *testServer) expectStatus(rec *httptest.ResponseRecorder, status int, code string) {
+	s.t.Helper()
+	if rec.Code != status {
+		s.t.Fatalf("Expected status %d, got %d: %s", status, rec.Code, rec.Body.String())
+	}
+	if code == "" {
+		return
+	}
+	var body apiError
+	err := json.Unmarshal(rec.Body.Bytes(), &body)
+	if err != nil || body.ErrorCode != code {
+		s.t.Fatalf("Expected error code %s, got %s", code, rec.Body.String())
+	}
+}
+
+func (s *testServer) content(graphName, fileId string) string {
+	s.t.Helper()
+	rec := s.get(fmt.Sprintf("/%s/content/%s", url.PathEscape(graphName), url.PathEscape(fileId)), nil)
+	s.expectStatus(rec, http.StatusOK, "")
+	return rec.Body.String()
+}
+
+func (s *testServer) createGraph(graph model.Graph) {
+	s.t.Helper()
+	graph.CreatedAt = time.Now()
+	err := s.db.Create(&graph).Error
+	if err != nil {
+		s.t.Fatalf("Could not create graph: %v", err)
+	}
+}
+
+func TestStaleBaseRevision(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Name: "Personal"})
+
+	rec := s.upload("Personal", "pages/a.md", "first", 0, nil)
+	s.expectStatus(rec, http.StatusCreated, "")
+	rec = s.upload("Personal", "pages/a.md", "second", 1, nil)
+	s.expectStatus(rec, http.StatusCreated, "")
+
+	// another device changed the file since revision 1
+	rec = s.upload("Personal", "pages/a.md", "stale", 1, nil)
+	s.expectStatus(rec, http.StatusConflict, codeConflict)
+	if rec.Header().Get(fileRevisionHeader) != "2" {
+		t.Fatalf("Expected the current revision 2, got %q", rec.Header().Get(fileRevisionHeader))
+	}
+	if content := s.content("Personal", "pages/a.md"); content != "second" {
+		t.Fatalf("Expected the rejected upload to keep the content, got %q", content)
+	}
+	blobs, err := s.files.Blobs("Personal")
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("Expected only the blob of the current content, got %v, %v", blobs, err)
+	}
+
+	rec = s.delete("Personal", "pages/a.md", "base_revision=1", nil)
+	s.expectStatus(rec, http.StatusConflict, codeConflict)
+	if content := s.content("Personal", "pages/a.md"); content != "second" {
+		t.Fatalf("Expected the rejected deletion to keep the content, got %q", content)
+	}
+
+	rec = s.delete("Personal", "pages/a.md", "base_revision=2", nil)
+	s.expectStatus(rec, http.StatusNoContent, "")
+	blobs, err = s.files.Blobs("Personal")
+	if err != nil || len(blobs) != 0 {
+		t.Fatalf("Expected the blob to be removed with the deletion, got %v, %v", blobs, err)
+	}
+}
+
+func TestDeleteLimit(t *testing.T) {
+	s := setup(t, config.Config{Deletes: config.DeletesConfig{Limit: config.DeleteLimit{Count: 2}, Window: time.Hour}})
+	s.createGraph(model.Graph{Name: "Personal"})
+	for i := 0; i < 8; i++ {
+		s.expectStatus(s.upload("Personal", fmt.Sprintf("pages/%d.md", i), "content", -1, nil), http.StatusCreated, "")
+	}
+
+	cleanup := http.Header{transactionHeader: {"cleanup"}}
+	s.expectStatus(s.delete("Personal", "pages/0.md", "", cleanup), http.StatusNoContent, "")
+	s.expectStatus(s.delete("Personal", "pages/1.md", "", cleanup), http.StatusNoContent, "")
+	rec := s.delete("Personal", "pages/2.md", "", cleanup)
+	s.expectStatus(rec, http.StatusConflict, codeTooManyDeletes)
+	if content := s.content("Personal", "pages/2.md"); content != "content" {
+		t.Fatalf("Expected the refused deletion to keep the file, got %q", content)
+	}
+
+	// confirmed deletions and other transactions are not limited
+	s.expectStatus(s.delete("Personal", "pages/2.md", "confirm_deletes=true", cleanup), http.StatusNoContent, "")
+	s.expectStatus(s.delete("Personal", "pages/3.md", "", http.Header{transactionHeader: {"other"}}), http.StatusNoContent, "")
+
+	// a device can't avoid the limit with a transaction per deletion
+	for i, status := range []int{http.StatusNoContent, http.StatusNoContent, http.StatusConflict} {
+		header := http.Header{transactionHeader: {fmt.Sprintf("device-%d", i)}, deviceIdHeader: {"laptop"}}
+		s.expectStatus(s.delete("Personal", fmt.Sprintf("pages/%d.md", i+4), "", header), status, "")
+	}
+}
+
+// changes decodes the changes after the revision, that the device gets
+func (s *testServer) changes(graphName string, after int64, header http.Header) []model.ChangeLogEntry {
+	s.t.Helper()
+	rec := s.get(fmt.Sprintf("/%s/changes?after_revision=%d", url.PathEscape(graphName), after), header)
+	s.expectStatus(rec, http.StatusOK, "")
+	var changes []model.ChangeLogEntry
+	err := json.Unmarshal(rec.Body.Bytes(), &changes)
+	if err != nil {
+		s.t.Fatalf("Could not decode changes: %v", err)
+	}
+	return changes
+}
+
+func TestChangesWithoutOwnChanges(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Name: "Personal"})
+	laptop := http.Header{deviceIdHeader: {"laptop"}}
+	phone := http.Header{deviceIdHeader: {"phone"}}
+	s.expectStatus(s.upload("Personal", "pages/a.md", "a", -1, laptop), http.StatusCreated, "")
+	s.expectStatus(s.upload("Personal", "pages/b.md", "b", -1, phone), http.StatusCreated, "")
+	// older clients send no device id
+	s.expectStatus(s.upload("Personal", "pages/c.md", "c", -1, nil), http.StatusCreated, "")
+
+	changes := s.changes("Personal", 0, laptop)
+	if len(changes) != 2 || changes[0].FileId != "pages/b.md" || changes[1].FileId != "pages/c.md" {
+		t.Fatalf("Expected the changes of the other devices, got %+v", changes)
+	}
+	changes = s.changes("Personal", 0, nil)
+	if len(changes) != 3 {
+		t.Fatalf("Expected all changes without a device id, got %+v", changes)
+	}
+}
+
+func TestChangesBehindCompactionHorizon(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Name: "Personal"})
+	s.expectStatus(s.upload("Personal", "pages/a.md", "a", -1, nil), http.StatusCreated, "")
+	s.expectStatus(s.upload("Personal", "pages/b.md", "b", -1, nil), http.StatusCreated, "")
+	s.expectStatus(s.delete("Personal", "pages/a.md", "", nil), http.StatusNoContent, "")
+	lastSync := time.Now().Add(-time.Minute)
+
+	_, err := admin.New(s.db, s.files).Compact("Personal", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Could not compact: %v", err)
+	}
+
+	// the client missed the removed deletion
+	rec := s.get("/Personal/changes?after_revision=1", nil)
+	s.expectStatus(rec, http.StatusGone, codeResyncRequired)
+	rec = s.get(fmt.Sprintf("/Personal/changes?since=%d", lastSync.UnixMilli()), nil)
+	s.expectStatus(rec, http.StatusGone, codeResyncRequired)
+
+	changes := s.changes("Personal", 3, nil)
+	if len(changes) != 0 {
+		t.Fatalf("Expected no changes after the horizon, got %+v", changes)
+	}
+	// a resync starts without a cursor
+	changes = s.changes("Personal", 0, nil)
+	if len(changes) != 1 || changes[0].FileId != "pages/b.md" {
+		t.Fatalf("Expected the latest state of the graph, got %+v", changes)
+	}
+}
+
+func TestKeyEpoch(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Name: "Personal", Encrypted: true, KeyCheck: "check", KeyEpoch: 1})
+	epoch := func(epoch string) http.Header {
+		return http.Header{keyEpochHeader: {epoch}}
+	}
+	s.expectStatus(s.upload("Personal", "a", "a", -1, epoch("1")), http.StatusCreated, "")
+
+	// clients, that didn't notice the rotation, encrypted the change with the old key
+	rec := s.upload("Personal", "a", "old", -1, epoch("0"))
+	s.expectStatus(rec, http.StatusConflict, codeKeyRotated)
+	if rec.Header().Get(keyEpochHeader) != "1" {
+		t.Fatalf("Expected the current epoch 1, got %q", rec.Header().Get(keyEpochHeader))
+	}
+	s.expectStatus(s.delete("Personal", "a", "", nil), http.StatusConflict, codeKeyRotated)
+
+	_, err := admin.New(s.db, s.files).StartRotation("Personal", "params", "new-check")
+	if err != nil {
+		t.Fatalf("Could not start rotation: %v", err)
+	}
+	s.expectStatus(s.upload("Personal", "a", "during", -1, epoch("1")), http.StatusLocked, codeKeyRotation)
+	s.expectStatus(s.delete("Personal", "a", "", epoch("1")), http.StatusLocked, codeKeyRotation)
+	if content := s.content("Personal", "a"); content != "a" {
+		t.Fatalf("Expected the rejected changes to keep the content, got %q", content)
+	}
+}
+
+func TestUpdateGraphWithFailingRename(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Id: "personal", Name: "Personal", Owner: "alice"})
+	s.createGraph(model.Graph{Id: "work", Name: "Work"})
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/graphs/Personal", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return s.send(req, nil)
+	}
+
+	s.expectStatus(patch(`{"owner": "bob", "name": "Work"}`), http.StatusConflict, codeConflict)
+	s.expectStatus(patch(`{"owner": "bob", "name": "graphs"}`), http.StatusBadRequest, codeBadRequest)
+	var graph model.Graph
+	s.db.Where("id = ?", "personal").First(&graph)
+	if graph.Name != "Personal" || graph.Owner != "alice" {
+		t.Fatalf("Expected the graph to be unchanged, got %+v", graph)
+	}
+
+	s.expectStatus(patch(`{"owner": "bob", "name": "Private"}`), http.StatusOK, "")
+	s.db.Where("id = ?", "personal").First(&graph)
+	if graph.Name != "Private" || graph.Owner != "bob" {
+		t.Fatalf("Expected the graph to be renamed and updated, got %+v", graph)
+	}
+}
+
+func TestDecompressedBodyLimit(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Name: "Personal"})
+	limit := maxDecompressedBody
+	maxDecompressedBody = 1 << 20
+	defer func() { maxDecompressedBody = limit }()
+
+	rec := s.get("/graphs/Personal", nil)
+	s.expectStatus(rec, http.StatusOK, "")
+	if !strings.Contains(rec.Body.String(), `"upload_encodings":["gzip"]`) {
+		t.Fatalf("Expected the graph to advertise gzip uploads, got %s", rec.Body.String())
+	}
+
+	// a multipart body with a large file, that is small when compressed
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "file")
+	part.Write(make([]byte, 2<<20))
+	writer.Close()
+	for _, target := range []string{"/Personal/upload", "/graphs/Personal/rotation/files/a?new_file_id=b"} {
+		var body bytes.Buffer
+		compressed := gzip.NewWriter(&body)
+		compressed.Write(form.Bytes())
+		compressed.Close()
+
+		method := "POST"
+		if strings.HasPrefix(target, "/graphs") {
+			method = "PUT"
+		}
+		req := httptest.NewRequest(method, target, &body)
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec = s.send(req, nil)
+		s.expectStatus(rec, http.StatusRequestEntityTooLarge, codeTooLarge)
+	}
+}
+
+func TestLegacyFileIds(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Name: "Personal"})
+	s.expectStatus(s.upload("Personal", "pages/my%20page.md", "content", 0, nil), http.StatusCreated, "")
+
+	// older clients join the path segments with ___ and don't escape them
+	if content := s.content("Personal", "pages___my page.md"); content != "content" {
+		t.Fatalf("Expected the content of the legacy id, got %q", content)
+	}
+	rec := s.delete("Personal", "pages___my page.md", "kind=file", nil)
+	s.expectStatus(rec, http.StatusNoContent, "")
+
+	var deletion model.ChangeLogEntry
+	s.db.Where("graph_name = ? AND operation = ?", "Personal", model.Deleted).First(&deletion)
+	if deletion.FileId != "pages/my%20page.md" {
+		t.Fatalf("Expected the deletion of the converted id, got %q", deletion.FileId)
+	}
+}
+
+func TestRetriedChanges(t *testing.T) {
+	s := setup(t, config.Config{})
+	s.createGraph(model.Graph{Name: "Personal"})
+	first := s.upload("Personal", "pages/a.md", "content", 0, nil)
+	s.expectStatus(first, http.StatusCreated, "")
+	s.expectStatus(s.upload("Personal", "pages/b.md", "content", 0, nil), http.StatusCreated, "")
+
+	// a retry after a lost response sends the same modified date again
+	s.modified = s.modified.Add(-2 * time.Second)
+	retry := s.upload("Personal", "pages/a.md", "content", 0, nil)
+	s.expectStatus(retry, http.StatusCreated, "")
+	if retry.Header().Get(graphRevisionHeader) == "" || retry.Header().Get(graphRevisionHeader) != first.Header().Get(graphRevisionHeader) {
+		t.Fatalf("Expected revision %s for the retry, got %q", first.Header().Get(graphRevisionHeader), retry.Header().Get(graphRevisionHeader))
+	}
+
+	s.modified = s.modified.Add(time.Hour)
+	deletion := s.delete("Personal", "pages/b.md", "", nil)
+	s.expectStatus(deletion, http.StatusNoContent, "")
+	s.modified = s.modified.Add(-time.Second)
+	retry = s.delete("Personal", "pages/b.md", "", nil)
+	s.expectStatus(retry, http.StatusNoContent, "")
+	if retry.Header().Get(graphRevisionHeader) == "" || retry.Header().Get(graphRevisionHeader) != deletion.Header().Get(graphRevisionHeader) {
+		t.Fatalf("Expected revision %s for the retry, got %q", deletion.Header().Get(graphRevisionHeader), retry.Header().Get(graphRevisionHeader))
+	}
+	if changes := s.changes("Personal", 0, nil); len(changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %v", changes)
+	}
+}
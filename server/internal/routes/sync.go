@@ -1,13 +1,18 @@
 package routes
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/render"
 	"github.com/google/uuid"
+	"github.com/soerenchrist/logsync/server/internal/config"
+	"github.com/soerenchrist/logsync/server/internal/files"
+	"github.com/soerenchrist/logsync/server/internal/log"
 	"github.com/soerenchrist/logsync/server/internal/model"
 	"gorm.io/gorm"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -17,12 +22,20 @@ import (
 )
 
 func (c *Controller) content(w http.ResponseWriter, r *http.Request) {
-	graphName := chi.URLParam(r, "graphID")
-	fileId := chi.URLParam(r, "fileID")
+	graphName := readGraphName(r)
+	fileId, err := c.readStoredFileId(r, graphName)
+	if err != nil {
+		abort400(w, r, "Could not parse file id")
+		return
+	}
 
-	mapping, err := c.getMapping(fileId)
+	mapping, err := c.getMapping(graphName, fileId)
 	if err != nil {
-		abort500(w, r, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			abort404(w, r)
+		} else {
+			abort500(w, r, err)
+		}
 		return
 	}
 
@@ -36,17 +49,53 @@ func (c *Controller) content(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	render.Data(w, r, data)
+	// the content type decides, if middleware.Compress compresses the response, encrypted content is binary
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	if err != nil {
+		logger := r.Context().Value("logger").(*slog.Logger)
+		logger.Warn("Could not write content", "error", err)
+	}
 }
 
-func (c *Controller) getMapping(fileId string) (model.FileMapping, error) {
-	fileId, err := url.PathUnescape(fileId)
+func readGraphName(r *http.Request) string {
+	graphName := chi.URLParam(r, "graphID")
+	unescaped, err := url.PathUnescape(graphName)
 	if err != nil {
-		return model.FileMapping{}, err
+		return graphName
 	}
+	return unescaped
+}
 
+func readFileId(r *http.Request) (string, error) {
+	return url.PathUnescape(chi.URLParam(r, "fileID"))
+}
+
+// readStoredFileId falls back to the converted id, because the migration replaced the legacy ids of the mappings.
+func (c *Controller) readStoredFileId(r *http.Request, graphName string) (string, error) {
+	fileId, err := readFileId(r)
+	if err != nil {
+		return "", err
+	}
+	converted := model.LegacyFileId(fileId)
+	if converted == fileId {
+		return fileId, nil
+	}
+	_, err = c.getMapping(graphName, fileId)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fileId, nil
+	}
+	_, err = c.getMapping(graphName, converted)
+	if err != nil {
+		return fileId, nil
+	}
+	return converted, nil
+}
+
+func (c *Controller) getMapping(graphName, fileId string) (model.FileMapping, error) {
 	var fileMapping model.FileMapping
-	tx := c.db.Where("file_id = ?", fileId).First(&fileMapping)
+	tx := c.db.Where("graph_name = ? AND file_id = ?", graphName, fileId).First(&fileMapping)
 	if tx.Error != nil {
 		return model.FileMapping{}, tx.Error
 	}
@@ -54,43 +103,51 @@ func (c *Controller) getMapping(fileId string) (model.FileMapping, error) {
 	return fileMapping, nil
 }
 
-func (c *Controller) getOrCreateMapping(fileId string) (model.FileMapping, error) {
-	fileId, err := url.PathUnescape(fileId)
-	if err != nil {
-		return model.FileMapping{}, err
-	}
-
+// findMapping returns a new mapping for unknown files, it is saved with the first change of the file.
+func (c *Controller) findMapping(graphName, fileId string) (model.FileMapping, error) {
 	var found model.FileMapping
-	tx := c.db.Where("file_id = ?", fileId).First(&found)
-	if tx.Error != nil {
-		if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			return model.FileMapping{}, tx.Error
-		}
-	} else {
+	tx := c.db.Where("graph_name = ? AND file_id = ?", graphName, fileId).First(&found)
+	if tx.Error == nil {
 		return found, nil
 	}
-	mapping := model.FileMapping{
-		FileId:   fileId,
-		FileName: uuid.New().String(),
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return model.FileMapping{}, tx.Error
 	}
-	tx = c.db.Create(&mapping)
-	return mapping, tx.Error
+	return model.FileMapping{
+		GraphName: graphName,
+		FileId:    fileId,
+		FileName:  uuid.New().String(),
+	}, nil
 }
 
-func (c *Controller) removeMapping(fileId string) error {
-	fileId, err := url.PathUnescape(fileId)
+func removeMapping(tx *gorm.DB, graphName, fileId string) (model.FileMapping, error) {
+	var mappings []model.FileMapping
+	err := tx.Where("graph_name = ? AND file_id = ?", graphName, fileId).Find(&mappings).Error
 	if err != nil {
-		return err
+		return model.FileMapping{}, err
+	}
+	if len(mappings) == 0 {
+		return model.FileMapping{}, os.ErrNotExist
+	}
+	return mappings[0], tx.Delete(&mappings[0]).Error
+}
+
+// removeBlob runs after the change was committed or rolled back, so a blob is never removed while it is referenced.
+// Blobs, that could not be removed, are found by logsync-server verify.
+func (c *Controller) removeBlob(graphName, fileName string) {
+	err := c.files.Remove(graphName, fileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("Could not remove file", "graph", graphName, "file", fileName, "error", err)
 	}
-	tx := c.db.Delete(&model.FileMapping{
-		FileId: fileId,
-	})
-	return tx.Error
 }
 
 func (c *Controller) deleteFile(w http.ResponseWriter, r *http.Request) {
-	graphName := chi.URLParam(r, "graphID")
-	fileName := chi.URLParam(r, "fileID")
+	graphName := readGraphName(r)
+	fileName, err := c.readStoredFileId(r, graphName)
+	if err != nil {
+		abort400(w, r, "Could not parse file id")
+		return
+	}
 
 	transaction := r.Context().Value("transaction").(string)
 	if transaction == "" {
@@ -104,54 +161,106 @@ func (c *Controller) deleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check of duplicate entry
-	var existing []model.ChangeLogEntry
-	c.db.Where("timestamp = ? AND file_id = ? and graph_name = ?", timestamp, fileName, graphName).Find(&existing)
-	if len(existing) > 0 {
-		w.WriteHeader(http.StatusNoContent)
+	kind, err := validateKind(r.URL.Query().Get("kind"))
+	if err != nil {
+		abort400(w, r, fmt.Sprintf("Invalid kind, allowed values: %v", allowedKinds))
 		return
 	}
 
-	mapping, err := c.getOrCreateMapping(fileName)
+	mapping, err := c.findMapping(graphName, fileName)
 	if err != nil {
 		abort500(w, r, err)
 		return
 	}
 
-	err = c.files.Remove(graphName, mapping.FileName)
+	base, err := readBaseRevision(r.URL.Query().Get("base_revision"))
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			abort404(w, r)
-		} else {
-			abort500(w, r, err)
-		}
+		abort400(w, r, "Could not parse base_revision")
 		return
 	}
 
-	err = c.removeMapping(fileName)
-	if err != nil {
-		abort500(w, r, err)
-		return
-	}
 	entry := model.ChangeLogEntry{
 		GraphName:     graphName,
 		FileId:        fileName,
 		Operation:     model.Deleted,
 		Timestamp:     timestamp,
 		TransactionId: transaction,
+		Kind:          kind,
+		ModTime:       timestamp,
+		DeviceId:      r.Header.Get(deviceIdHeader),
+		CreatedAt:     time.Now(),
+	}
+	var removed model.FileMapping
+	var duplicate model.ChangeLogEntry
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		duplicate, err = checkDuplicate(tx, graphName, fileName, timestamp)
+		if err != nil {
+			return err
+		}
+		entry.Revision, err = model.NextRevision(tx, graphName)
+		if err != nil {
+			return err
+		}
+		err = checkKeyEpoch(tx, r, graphName)
+		if err != nil {
+			return err
+		}
+		err = c.checkDeleteLimit(tx, r, graphName, transaction)
+		if err != nil {
+			return err
+		}
+		// deleting a file, that is already deleted, conflicts with nothing
+		if mapping.Revision > 0 {
+			err = checkBaseRevision(tx, graphName, fileName, base)
+			if err != nil {
+				return err
+			}
+		}
+
+		removed, err = removeMapping(tx, graphName, fileName)
+		if err != nil && !(kind == model.Directory && errors.Is(err, os.ErrNotExist)) {
+			return err
+		}
+		return tx.Create(&entry).Error
+	})
+	if err == nil && kind != model.Directory {
+		// the content is removed, once the deletion is committed, directories have no content stored
+		c.removeBlob(graphName, removed.FileName)
 	}
-	tx := c.db.Create(entry)
-	if tx.Error != nil {
+	if errors.Is(err, errDuplicateChange) {
+		w.Header().Set(graphRevisionHeader, strconv.FormatInt(duplicate.Revision, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if abortStaleRevision(w, r, err) || abortKeyEpoch(w, r, err) {
+		return
+	}
+	if errors.Is(err, errTooManyDeletes) {
+		abort(w, r, http.StatusConflict, codeTooManyDeletes, err.Error())
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		abort404(w, r)
+		return
+	}
+	if err != nil {
 		abort500(w, r, err)
 		return
 	}
 
+	w.Header().Set(graphRevisionHeader, strconv.FormatInt(entry.Revision, 10))
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (c *Controller) uploadFile(w http.ResponseWriter, r *http.Request) {
-	graphName := chi.URLParam(r, "graphID")
+	graphName := readGraphName(r)
+	if c.config.Files.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, c.config.Files.MaxSize<<20)
+	}
 	err := r.ParseMultipartForm(10 << 20) // max of 10MB
+	if abortTooLarge(w, r, err) {
+		return
+	}
 	if err != nil {
 		abort400(w, r, "Expected multipart body")
 		return
@@ -163,6 +272,7 @@ func (c *Controller) uploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer file.Close()
+	fileId := readUploadFileId(r, header.Filename)
 
 	transaction := r.Context().Value("transaction").(string)
 	if transaction == "" {
@@ -187,46 +297,151 @@ func (c *Controller) uploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check of duplicate entry
-	var existing []model.ChangeLogEntry
-	c.db.Where("timestamp = ? AND file_id = ? and graph_name = ?", timestamp, header.Filename, graphName).Find(&existing)
-	if len(existing) > 0 {
-		w.WriteHeader(http.StatusCreated)
+	kind, err := validateKind(r.FormValue("kind"))
+	if err != nil {
+		abort400(w, r, fmt.Sprintf("Invalid kind, allowed values: %v", allowedKinds))
 		return
 	}
 
-	mapping, err := c.getOrCreateMapping(header.Filename)
+	mode, err := readMode(r)
 	if err != nil {
-		abort500(w, r, err)
+		abort400(w, r, "Could not parse mode")
 		return
 	}
 
-	err = c.files.Store(graphName, mapping.FileName, file)
+	mapping, err := c.findMapping(graphName, fileId)
 	if err != nil {
 		abort500(w, r, err)
 		return
 	}
 
+	base, err := readBaseRevision(r.FormValue("base_revision"))
+	if err != nil {
+		abort400(w, r, "Could not parse base_revision")
+		return
+	}
+
+	var content []byte
+	if kind != model.Directory {
+		content, err = io.ReadAll(file)
+		if err != nil {
+			abort500(w, r, err)
+			return
+		}
+	}
+
+	// the content is stored in a new blob, the mapping only refers to it, once the change is committed.
+	// A rolled back change keeps the previous content.
+	if kind != model.Directory {
+		mapping.FileName = uuid.New().String()
+	}
+	mapping.Kind = kind
+	mapping.Mode = mode
+	mapping.ModTime = timestamp
+	mapping.Size = 0
+	mapping.Hash = ""
 	entry := model.ChangeLogEntry{
 		GraphName:     graphName,
-		FileId:        header.Filename,
+		FileId:        fileId,
 		Operation:     opType,
 		Timestamp:     timestamp,
 		TransactionId: transaction,
+		Kind:          kind,
+		Mode:          mode,
+		ModTime:       timestamp,
+		DeviceId:      r.Header.Get(deviceIdHeader),
+		CreatedAt:     time.Now(),
+	}
+	var previous []model.FileMapping
+	var duplicate model.ChangeLogEntry
+	err = c.db.Transaction(func(tx *gorm.DB) error {
+		duplicate, err = checkDuplicate(tx, graphName, fileId, timestamp)
+		if err != nil {
+			return err
+		}
+		entry.Revision, err = model.NextRevision(tx, graphName)
+		if err != nil {
+			return err
+		}
+		err = checkKeyEpoch(tx, r, graphName)
+		if err != nil {
+			return err
+		}
+		// the content is only stored, when the change is accepted
+		err = checkBaseRevision(tx, graphName, fileId, base)
+		if err != nil {
+			return err
+		}
+
+		if kind != model.Directory {
+			err = c.files.Store(graphName, mapping.FileName, bytes.NewReader(content))
+			if err != nil {
+				return err
+			}
+			mapping.Size = int64(len(content))
+			mapping.Hash = files.Hash(content)
+		}
+		err = tx.Where("graph_name = ? AND file_id = ?", graphName, fileId).Find(&previous).Error
+		if err != nil {
+			return err
+		}
+		mapping.Revision = entry.Revision
+		err = tx.Save(&mapping).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&entry).Error
+	})
+	if err != nil && kind != model.Directory {
+		c.removeBlob(graphName, mapping.FileName)
 	}
-	tx := c.db.Create(entry)
-	if tx.Error != nil {
-		abort500(w, r, tx.Error)
+	if err == nil && len(previous) > 0 && previous[0].FileName != mapping.FileName {
+		c.removeBlob(graphName, previous[0].FileName)
+	}
+	if errors.Is(err, errDuplicateChange) {
+		w.Header().Set(graphRevisionHeader, strconv.FormatInt(duplicate.Revision, 10))
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if abortStaleRevision(w, r, err) || abortKeyEpoch(w, r, err) {
+		return
+	}
+	if err != nil {
+		abort500(w, r, err)
 		return
 	}
 
+	w.Header().Set(graphRevisionHeader, strconv.FormatInt(entry.Revision, 10))
 	w.WriteHeader(http.StatusCreated)
 }
 
+var errDuplicateChange = errors.New("the change is already stored")
+
+func checkDuplicate(tx *gorm.DB, graphName, fileId string, timestamp time.Time) (model.ChangeLogEntry, error) {
+	var existing []model.ChangeLogEntry
+	err := tx.Where("timestamp = ? AND file_id = ? AND graph_name = ?", timestamp, fileId, graphName).Find(&existing).Error
+	if err != nil {
+		return model.ChangeLogEntry{}, err
+	}
+	if len(existing) > 0 {
+		return existing[0], errDuplicateChange
+	}
+	return model.ChangeLogEntry{}, nil
+}
+
 var uploadAllowedOperationTypes = []model.OperationType{
 	model.Modified, model.Created,
 }
 
+func readUploadFileId(r *http.Request, fileName string) string {
+	fileId := r.FormValue("file_id")
+	if fileId != "" {
+		return fileId
+	}
+
+	return model.LegacyFileId(fileName)
+}
+
 func readModifiedDateFromForm(request *http.Request) (time.Time, error) {
 	modifiedDate := request.FormValue("modified-date")
 	if modifiedDate == "" {
@@ -253,6 +468,36 @@ func readModifiedDateFromQuery(request *http.Request) (time.Time, error) {
 	return timestamp, nil
 }
 
+var allowedKinds = []model.FileKind{
+	model.File, model.Directory, model.Symlink,
+}
+
+func validateKind(kind string) (model.FileKind, error) {
+	if kind == "" {
+		return model.File, nil
+	}
+
+	fileKind := model.FileKind(kind)
+	if slices.Contains(allowedKinds, fileKind) {
+		return fileKind, nil
+	}
+
+	return "", errors.New(fmt.Sprintf("kind %s not allowed", kind))
+}
+
+func readMode(request *http.Request) (uint32, error) {
+	mode := request.FormValue("mode")
+	if mode == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(parsed) & 0777, nil
+}
+
 func validateOperation(operation string) (model.OperationType, error) {
 	opType := model.OperationType(operation)
 	if slices.Contains(uploadAllowedOperationTypes, opType) {
@@ -261,3 +506,85 @@ func validateOperation(operation string) (model.OperationType, error) {
 
 	return "", errors.New(fmt.Sprintf("operation type %s not allowed", operation))
 }
+
+const fileRevisionHeader = "X-File-Revision"
+
+type staleRevisionError struct {
+	current int64
+}
+
+func (e staleRevisionError) Error() string {
+	return fmt.Sprintf("the file was changed in revision %d", e.current)
+}
+
+func readBaseRevision(value string) (int64, error) {
+	if value == "" {
+		return -1, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func checkBaseRevision(tx *gorm.DB, graphName, fileId string, base int64) error {
+	if base < 0 {
+		return nil
+	}
+
+	var revisions []int64
+	err := tx.Model(&model.FileMapping{}).
+		Where("graph_name = ? AND file_id = ?", graphName, fileId).
+		Pluck("revision", &revisions).Error
+	if err != nil {
+		return err
+	}
+	current := int64(0)
+	if len(revisions) > 0 {
+		current = revisions[0]
+	}
+	if current != base {
+		return staleRevisionError{current: current}
+	}
+	return nil
+}
+
+func abortStaleRevision(w http.ResponseWriter, r *http.Request, err error) bool {
+	var staleErr staleRevisionError
+	if !errors.As(err, &staleErr) {
+		return false
+	}
+	w.Header().Set(fileRevisionHeader, strconv.FormatInt(staleErr.current, 10))
+	abort409(w, r, fmt.Sprintf("File was changed in revision %d", staleErr.current))
+	return true
+}
+
+var errTooManyDeletes = errors.New("too many files were deleted in the transaction, confirm the deletions with confirm_deletes=true")
+
+// checkDeleteLimit also counts the deletions of the device within the window, the transaction id is chosen by the client.
+func (c *Controller) checkDeleteLimit(tx *gorm.DB, r *http.Request, graphName, transaction string) error {
+	limit := c.config.Deletes.Limit
+	if limit == (config.DeleteLimit{}) || r.URL.Query().Get("confirm_deletes") == "true" {
+		return nil
+	}
+
+	var deletions, files int64
+	query := tx.Model(&model.ChangeLogEntry{}).Where("graph_name = ? AND operation = ?", graphName, model.Deleted)
+	device := r.Header.Get(deviceIdHeader)
+	if device != "" && c.config.Deletes.Window > 0 {
+		since := time.Now().Add(-c.config.Deletes.Window)
+		query = query.Where("(transaction_id = ? OR (device_id = ? AND created_at > ?))", transaction, device, since)
+	} else {
+		query = query.Where("transaction_id = ?", transaction)
+	}
+	err := query.Count(&deletions).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&model.FileMapping{}).Where("graph_name = ?", graphName).Count(&files).Error
+	if err != nil {
+		return err
+	}
+	// the percentage is based on the files before the transaction
+	if limit.Exceeded(deletions+1, files+deletions) {
+		return errTooManyDeletes
+	}
+	return nil
+}